@@ -0,0 +1,95 @@
+// Command migrate applies, reverts or lists the embedded database
+// migrations against DATABASE_URL:
+//
+//	go run ./cmd/migrate up
+//	go run ./cmd/migrate down [steps]
+//	go run ./cmd/migrate status
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/joho/godotenv"
+
+	pgstore "helixrun/internal/store/postgres"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	ctx := context.Background()
+	pool, err := pgstore.NewPool(ctx, pgstore.FromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer pool.Close()
+	migrator := pgstore.NewMigrator(pool)
+
+	switch os.Args[1] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		exitOnError(err)
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "down":
+		steps := 1
+		if len(os.Args) > 2 {
+			if steps, err = strconv.Atoi(os.Args[2]); err != nil || steps < 1 {
+				usage()
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		exitOnError(err)
+		if len(reverted) == 0 {
+			fmt.Println("no migrations to revert")
+		}
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		exitOnError(err)
+		for _, st := range statuses {
+			state := "pending"
+			if st.AppliedAt != nil {
+				state = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05Z07:00")
+			}
+			switch {
+			case st.Modified:
+				state += " (MODIFIED since applied)"
+			case st.Missing:
+				state += " (file MISSING)"
+			}
+			fmt.Printf("%04d_%-30s %s\n", st.Version, st.Name, state)
+		}
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: migrate up | down [steps] | status")
+	os.Exit(2)
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -5,16 +5,29 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 
 	"helixrun/internal/agents"
+	"helixrun/internal/checkpoint"
+	"helixrun/internal/keypool"
+	"helixrun/internal/model"
+	"helixrun/internal/runstore"
+	"helixrun/internal/usage"
 
 	httpserver "helixrun/internal/http"
+	runnersvc "helixrun/internal/runner"
 	pgstore "helixrun/internal/store/postgres"
 )
 
+// shutdownTimeout bounds the graceful shutdown after SIGINT or SIGTERM.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// .env laden (optioneel, errors negeren als er geen .env is)
 	_ = godotenv.Load()
@@ -36,17 +49,133 @@ func main() {
 
 	log.Printf("Loaded agents: %v", reg.ListAgentIDs())
 
+	if raw := os.Getenv("HELIXRUN_CONFIG_RELOAD_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			log.Fatalf("invalid HELIXRUN_CONFIG_RELOAD_INTERVAL %q: %v", raw, err)
+		}
+		log.Printf("Watching %s for agent config changes every %s", configDir, interval)
+		go reg.Watch(context.Background(), interval)
+	}
+
 	mux := http.NewServeMux()
 
-	chatServer := httpserver.NewChatServer(reg)
+	runnerService := runnersvc.NewService(reg)
+	var usageRecorder *usage.Recorder
+	var runStore runstore.Store = runstore.NewMemoryStore()
+	var checkpointStore checkpoint.Store = checkpoint.NewMemoryStore()
+	if pool := initPostgresPool(); pool != nil {
+		if os.Getenv("HELIXRUN_AUTO_MIGRATE") != "false" {
+			if _, err := pgstore.NewMigrator(pool).Up(context.Background()); err != nil {
+				log.Fatalf("failed to migrate database: %v", err)
+			}
+		}
+
+		runnerService.WithSessionService(pgstore.NewSessionService(pool))
+		runnerID := os.Getenv("HELIXRUN_RUNNER_ID")
+		pgRuns := pgstore.NewRunStore(pool).WithRunnerID(runnerID)
+		if runnerID == "" {
+			log.Printf("HELIXRUN_RUNNER_ID is not set: runs interrupted by a restart stay running")
+		} else if n, err := pgRuns.FailInterrupted(context.Background(), "server restarted"); err != nil {
+			log.Fatalf("failed to finish interrupted runs: %v", err)
+		} else if n > 0 {
+			log.Printf("Marked %d interrupted run(s) as failed", n)
+		}
+		runStore = pgRuns
+		checkpointStore = pgstore.NewCheckpointStore(pool)
+		log.Printf("Using PostgreSQL session, run and checkpoint store")
+
+		keyRepo := pgstore.NewKeyRepository(pool)
+		usageRepo := pgstore.NewUsageRepository(pool)
+		model.RegisterSecretResolver(model.SecretSchemeCLIProxy, keyRepo.ResolveSecret)
+		model.SetKeySource(keypool.New(keyRepo).WithUsageCounter(usageRepo))
+
+		pricingFile := os.Getenv("HELIXRUN_PRICING_FILE")
+		if pricingFile == "" {
+			pricingFile = "./configs/pricing.json"
+		}
+		prices, err := usage.LoadPriceTable(pricingFile)
+		if err != nil {
+			log.Fatalf("failed to load price table: %v", err)
+		}
+		usageRecorder = usage.NewRecorder(usageRepo, prices)
+		runnerService.WithUsageRecorder(usageRecorder)
+		log.Printf("Recording token usage (%d priced models)", len(prices))
+
+		budgetsFile := os.Getenv("HELIXRUN_BUDGETS_FILE")
+		if budgetsFile == "" {
+			budgetsFile = "./configs/budgets.json"
+		}
+		budgets, err := usage.LoadBudgets(budgetsFile)
+		if err != nil {
+			log.Fatalf("failed to load budgets: %v", err)
+		}
+		if len(budgets) > 0 {
+			runnerService.WithBudgetChecker(usage.NewBudgetChecker(usageRepo, budgets))
+			log.Printf("Enforcing %d usage budgets", len(budgets))
+		}
+
+		usageServer := httpserver.NewUsageServer(usageRepo, budgets)
+		mux.HandleFunc("/api/usage/summary", usageServer.SummaryHandler)
+
+		cliproxyServer := httpserver.NewCLIProxyServer(
+			keyRepo,
+			usageRepo,
+		)
+		mux.HandleFunc("/api/cliproxy/keys", cliproxyServer.KeysHandler)
+		mux.HandleFunc("/api/cliproxy/keys/", cliproxyServer.KeyHandler)
+		mux.HandleFunc("/api/cliproxy/usage", cliproxyServer.UsageHandler)
+	}
+
+	runnerService.WithCheckpointStore(checkpointStore)
+
+	chatServer := httpserver.NewChatServer(runnerService)
 	mux.HandleFunc("/chat", chatServer.ChatHandler)
 
+	runsServer := httpserver.NewRunsServer(runnerService, runStore)
+	mux.HandleFunc("/runs", runsServer.RunsHandler)
+	mux.HandleFunc("/runs/", runsServer.RunHandler)
+
+	wsServer := httpserver.NewWSServer(runnerService)
+	if raw := os.Getenv("HELIXRUN_WS_ALLOWED_ORIGINS"); raw != "" {
+		wsServer.WithAllowedOrigins(strings.Split(raw, ",")...)
+	}
+	mux.Handle("/ws", wsServer.Handler())
+
+	openAIServer := httpserver.NewOpenAIServer(runnerService, reg)
+	mux.HandleFunc("/v1/chat/completions", openAIServer.ChatCompletionsHandler)
+	mux.HandleFunc("/v1/models", openAIServer.ModelsHandler)
+
 	fileServer := http.FileServer(http.Dir("./web"))
 	mux.Handle("/", fileServer)
 
-	log.Printf("HelixRun starter listening on %s", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("HelixRun starter listening on %s", addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
 		log.Fatalf("server error: %v", err)
+	case <-ctx.Done():
+	}
+	log.Printf("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+
+	// Write the usage of the runs that finished before the shutdown.
+	if usageRecorder != nil {
+		if err := usageRecorder.Close(shutdownCtx); err != nil {
+			log.Printf("%v", err)
+		}
 	}
 }
 
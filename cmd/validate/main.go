@@ -0,0 +1,32 @@
+// Command validate checks all agent configs in a directory and exits with a
+// non-zero status when any of them is invalid. It is meant to run in CI:
+//
+//	go run ./cmd/validate configs/agents
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"helixrun/internal/agents"
+)
+
+func main() {
+	dir := os.Getenv("HELIXRUN_CONFIG_DIR")
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	if dir == "" {
+		dir = "./configs/agents"
+	}
+
+	ids, err := agents.ValidateDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid agent configs in %s:\n%v\n", dir, err)
+		os.Exit(1)
+	}
+
+	sort.Strings(ids)
+	fmt.Printf("%d agent configs OK in %s: %v\n", len(ids), dir, ids)
+}
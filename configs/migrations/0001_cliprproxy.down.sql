@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS cliproxy_sync_state;
+DROP TABLE IF EXISTS cliproxy_usage_events;
+DROP TABLE IF EXISTS cliproxy_api_keys;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS session_user_states;
+DROP TABLE IF EXISTS session_app_states;
+DROP TABLE IF EXISTS session_events;
+DROP TABLE IF EXISTS sessions;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS run_events;
+DROP TABLE IF EXISTS runs;
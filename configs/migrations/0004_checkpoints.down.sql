@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS graph_checkpoint_writes;
+DROP TABLE IF EXISTS graph_checkpoints;
+DROP TABLE IF EXISTS graph_lineages;
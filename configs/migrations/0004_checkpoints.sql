@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS graph_lineages (
+    id TEXT PRIMARY KEY,
+    agent_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    session_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS graph_checkpoints (
+    lineage_id TEXT NOT NULL,
+    checkpoint_ns TEXT NOT NULL,
+    checkpoint_id TEXT NOT NULL,
+    parent_checkpoint_id TEXT NOT NULL DEFAULT '',
+    ts BIGINT NOT NULL, -- unix nanoseconds, orders checkpoints of a lineage
+    checkpoint JSONB NOT NULL,
+    metadata JSONB NOT NULL,
+    PRIMARY KEY (lineage_id, checkpoint_ns, checkpoint_id)
+);
+
+CREATE INDEX IF NOT EXISTS graph_checkpoints_ts_idx ON graph_checkpoints (lineage_id, checkpoint_ns, ts);
+
+CREATE TABLE IF NOT EXISTS graph_checkpoint_writes (
+    lineage_id TEXT NOT NULL,
+    checkpoint_ns TEXT NOT NULL,
+    checkpoint_id TEXT NOT NULL,
+    task_id TEXT NOT NULL,
+    idx INTEGER NOT NULL,
+    channel TEXT NOT NULL,
+    value JSONB NOT NULL,
+    task_path TEXT NOT NULL DEFAULT '',
+    seq BIGINT NOT NULL,
+    PRIMARY KEY (lineage_id, checkpoint_ns, checkpoint_id, task_id, idx)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS runs_running_idx;
+ALTER TABLE runs DROP COLUMN IF EXISTS runner_id;
@@ -0,0 +1,3 @@
+ALTER TABLE runs ADD COLUMN IF NOT EXISTS runner_id TEXT;
+
+CREATE INDEX IF NOT EXISTS runs_running_idx ON runs (runner_id) WHERE status = 'running';
@@ -0,0 +1,13 @@
+// Package migrations embeds the SQL migrations so the server and the
+// migrate command can apply them without the source tree.
+//
+// Files are named <version>_<name>.sql; the optional <version>_<name>.down.sql
+// reverts them.
+package migrations
+
+import "embed"
+
+// FS holds the migration files.
+//
+//go:embed *.sql
+var FS embed.FS
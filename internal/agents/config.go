@@ -1,60 +1,177 @@
 package agents
 
-import "helixrun/internal/model"
+import (
+	"helixrun/internal/model"
+
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+)
 
 // AgentType values.
 const (
-	AgentTypeSingle    = "single"      // single LLM agent
-	AgentTypeMultiChain = "multi_chain" // chain multi-agent
-	AgentTypeGraph     = "graph"       // graph-based agent
+	AgentTypeSingle     = "single"      // single LLM agent
+	AgentTypeMultiChain = "multi_chain" // multi-agent, flow selected by multi.mode
+	AgentTypeGraph      = "graph"       // graph-based agent
+)
+
+// ToolType values.
+const (
+	ToolTypeCalculator = "calculator"
+	ToolTypeAgent      = "agent" // another registry agent, called with a request string
+	ToolTypeHTTP       = "http"  // REST call described by ToolConfig.HTTP
 )
 
+// MultiMode values.
+const (
+	MultiModeChain    = "chain"    // run sub-agents one after another
+	MultiModeParallel = "parallel" // fan out the same input to all sub-agents
+	MultiModeCycle    = "cycle"    // repeat the sub-agents until stopped
+)
+
+// defaultCycleMaxIterations bounds cycle agents without max_iterations.
+const defaultCycleMaxIterations = 10
+
+// GraphNodeType values.
+const (
+	GraphNodeTypeEntry     = "entry"     // passthrough node
+	GraphNodeTypeLLM       = "llm"       // LLM node
+	GraphNodeTypeRouter    = "router"    // LLM classification node writing a label to state
+	GraphNodeTypeTools     = "tools"     // executes tool calls of an LLM node and loops back
+	GraphNodeTypeTransform = "transform" // sets or copies state keys
+)
+
+// GraphConditionType values.
+const (
+	GraphConditionStateKey   = "state_key"  // route on the value of a state key
+	GraphConditionLLM        = "llm"        // route on an LLM classification
+	GraphConditionExpression = "expression" // route on a boolean expression ("true"/"false")
+)
+
+// defaultRouterOutputKey is the state key router nodes write to when
+// output_key is not set.
+const defaultRouterOutputKey = "route"
+
 // AgentConfig is the JSON schema used to describe agents.
 type AgentConfig struct {
-	ID          string         `json:"id"`
-	Type        string         `json:"type"`
-	Description string         `json:"description,omitempty"`
-	Instruction string         `json:"instruction,omitempty"`
-	Stream      bool           `json:"stream"`
-	Model       model.Config   `json:"model"`
-	Tools       []ToolConfig   `json:"tools,omitempty"`
-	Multi       *MultiConfig   `json:"multi,omitempty"`
-	Graph       *GraphConfig   `json:"graph,omitempty"`
+	ID          string       `json:"id"`
+	Type        string       `json:"type"`
+	Description string       `json:"description,omitempty"`
+	Instruction string       `json:"instruction,omitempty"`
+	Stream      bool         `json:"stream"`
+	Model       model.Config `json:"model"`
+	Tools       []ToolConfig `json:"tools,omitempty"`
+	Multi       *MultiConfig `json:"multi,omitempty"`
+	Graph       *GraphConfig `json:"graph,omitempty"`
+
+	// SubAgents lists registry agents a single agent may transfer the
+	// conversation to with the transfer_to_agent tool.
+	SubAgents []string `json:"sub_agents,omitempty"`
 }
 
-// ToolConfig configures tools by name/type. For this starter, we only
-// implement a simple "calculator" function tool.
+// ToolConfig configures tools by name/type: a simple "calculator" function
+// tool, an "agent" tool that calls another registry agent, or an "http"
+// tool that calls a REST endpoint.
 type ToolConfig struct {
 	Name string `json:"name"`
-	Type string `json:"type"` // e.g. "calculator"
+	Type string `json:"type"` // "calculator", "agent" or "http"
+
+	// Agent is the ID of the registry agent an "agent" tool calls. The tool
+	// is named after it unless Name is set.
+	Agent string `json:"agent,omitempty"`
+
+	// HTTP describes the request of an "http" tool.
+	HTTP *HTTPToolConfig `json:"http,omitempty"`
+
+	// RequiresApproval pauses every call until a client approves, denies or
+	// edits it. Calls nobody decides on within ApprovalTimeout (default 5m)
+	// are denied.
+	RequiresApproval bool           `json:"requires_approval,omitempty"`
+	ApprovalTimeout  model.Duration `json:"approval_timeout,omitempty"`
+}
+
+// HTTPToolConfig describes the request an "http" tool sends. Arguments that
+// fill no URL placeholder go into the query string for GET and DELETE and
+// into a JSON body otherwise.
+type HTTPToolConfig struct {
+	Description string `json:"description,omitempty"`
+	Method      string `json:"method,omitempty"` // GET, POST, PUT, PATCH or DELETE; default GET
+	URL         string `json:"url"`              // {arg} placeholders are filled with escaped arguments
+
+	// Headers values may contain {secret:<ref>} placeholders with the same
+	// references as model.api_key_env (env:NAME, file:/path, ...), which are
+	// resolved on every call.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Parameters is the JSON schema of the arguments, an object schema.
+	// Required properties and the types and enums of top-level properties
+	// are checked before the request is sent.
+	Parameters *tool.Schema `json:"parameters,omitempty"`
+
+	// Response maps result fields to dotted paths into the JSON response,
+	// e.g. {"name": "data.customer.name", "first_id": "items.0.id"}. The
+	// whole response is the result when it is empty.
+	Response map[string]string `json:"response,omitempty"`
+
+	Timeout      model.Duration `json:"timeout,omitempty"` // default 10s
+	AllowedHosts []string       `json:"allowed_hosts"`     // hosts the URL and redirects may go to; "*.example.com" matches subdomains
 }
 
 // MultiConfig configures multi-agent flows.
 type MultiConfig struct {
-	Mode   string            `json:"mode"`   // e.g. "chain"
-	Agents []SubAgentConfig  `json:"agents"` // sub-agents for the chain
+	Mode   string           `json:"mode"`   // "chain", "parallel" or "cycle"
+	Agents []SubAgentConfig `json:"agents"` // sub-agents for the flow
+
+	// Cycle mode only.
+	MaxIterations int    `json:"max_iterations,omitempty"` // default 10
+	StopPhrase    string `json:"stop_phrase,omitempty"`    // stop once the final answer of StopAgent contains it
+	StopAgent     string `json:"stop_agent,omitempty"`     // sub-agent checked for stop_phrase, default the last one
 }
 
 // SubAgentConfig describes a single step agent in a multi-agent flow.
+// Model, Stream, Generation and Tools are optional overrides; when absent
+// the parent agent's values are used.
 type SubAgentConfig struct {
 	ID          string `json:"id"`
 	Instruction string `json:"instruction"`
 	Description string `json:"description,omitempty"`
+
+	Model      *model.Config            `json:"model,omitempty"`      // unset fields inherit from the parent model
+	Stream     *bool                    `json:"stream,omitempty"`     // defaults to the parent's stream flag
+	Generation *model.GenerationOptions `json:"generation,omitempty"` // applied on top of the model defaults
+	Tools      []ToolConfig             `json:"tools,omitempty"`      // nil inherits parent tools, [] disables tools
 }
 
 // GraphConfig describes a simple state graph for GraphAgent.
 type GraphConfig struct {
-	Nodes []GraphNodeConfig `json:"nodes"`
-	Edges []GraphEdgeConfig `json:"edges"`
-	Entry string            `json:"entry"`
-	Finish string           `json:"finish"`
+	Nodes            []GraphNodeConfig            `json:"nodes"`
+	Edges            []GraphEdgeConfig            `json:"edges"`
+	ConditionalEdges []GraphConditionalEdgeConfig `json:"conditional_edges,omitempty"`
+	Entry            string                       `json:"entry"`
+	Finish           string                       `json:"finish,omitempty"`
+	FinishNodes      []string                     `json:"finish_nodes,omitempty"`     // extra finish points for branching graphs
+	InterruptBefore  []string                     `json:"interrupt_before,omitempty"` // nodes that pause the run until it is resumed
 }
 
 // GraphNodeConfig describes a node in the graph.
 type GraphNodeConfig struct {
 	ID          string `json:"id"`
-	Type        string `json:"type"` // "entry" or "llm"
+	Type        string `json:"type"` // "entry", "llm", "router", "tools" or "transform"
 	Instruction string `json:"instruction,omitempty"`
+
+	// LLM and router nodes: applied on top of the agent's model settings.
+	Generation *model.GenerationOptions `json:"generation,omitempty"`
+
+	// Router nodes only.
+	Labels    []string `json:"labels,omitempty"`     // allowed classification labels
+	OutputKey string   `json:"output_key,omitempty"` // state key for the label, default "route"
+
+	// Tools nodes only. The LLM node gets the same tools bound.
+	LLMNode string   `json:"llm_node,omitempty"` // LLM node whose tool calls are executed
+	Next    string   `json:"next,omitempty"`     // node after the LLM answers without tool calls; graph end if empty
+	Tools   []string `json:"tools,omitempty"`    // agent tool names; all agent tools if empty
+
+	// Transform nodes only.
+	Set  map[string]any    `json:"set,omitempty"`  // state key -> literal value
+	Copy map[string]string `json:"copy,omitempty"` // state key -> source state path
 }
 
 // GraphEdgeConfig describes a directed edge between nodes.
@@ -62,3 +179,21 @@ type GraphEdgeConfig struct {
 	From string `json:"from"`
 	To   string `json:"to"`
 }
+
+// GraphConditionalEdgeConfig describes a branch out of a node. The
+// condition yields a route key which is looked up in Routes; unmatched keys
+// go to Default.
+type GraphConditionalEdgeConfig struct {
+	From      string               `json:"from"`
+	Condition GraphConditionConfig `json:"condition"`
+	Routes    map[string]string    `json:"routes"`            // route key -> target node
+	Default   string               `json:"default,omitempty"` // target when no route matches
+}
+
+// GraphConditionConfig selects how a conditional edge picks its route.
+type GraphConditionConfig struct {
+	Type        string `json:"type"`                  // "state_key", "llm" or "expression"
+	Key         string `json:"key,omitempty"`         // state_key: state path, e.g. "route" or "metadata.tier"
+	Instruction string `json:"instruction,omitempty"` // llm: classification prompt; route keys are the labels
+	Expression  string `json:"expression,omitempty"`  // expression: e.g. `route == "billing"`
+}
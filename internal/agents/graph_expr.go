@@ -0,0 +1,318 @@
+package agents
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+// stateExpr is a minimal boolean expression over graph state, either a
+// single operand (truthiness) or "<operand> <op> <operand>".
+//
+// Operands are state paths (route, node_responses.triage), quoted strings,
+// numbers or true/false. Supported operators: == != contains > >= < <=;
+// the spaces around them are optional except for contains. == and != never
+// convert between types, so "7" == 7 is false; the ordering operators also
+// accept numeric strings.
+type stateExpr struct {
+	left  exprOperand
+	op    string
+	right exprOperand
+}
+
+type exprOperand struct {
+	path    string
+	literal any
+}
+
+var exprOperators = map[string]bool{
+	"==": true, "!=": true, "contains": true,
+	">": true, ">=": true, "<": true, "<=": true,
+}
+
+// parseStateExpr parses an expression string.
+func parseStateExpr(src string) (*stateExpr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(tokens) {
+	case 1:
+		return &stateExpr{left: parseOperand(tokens[0])}, nil
+	case 3:
+		op := tokens[1]
+		if !exprOperators[op] {
+			return nil, fmt.Errorf("unsupported operator %q", op)
+		}
+		return &stateExpr{
+			left:  parseOperand(tokens[0]),
+			op:    op,
+			right: parseOperand(tokens[2]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("expression %q must be <operand> or <operand> <op> <operand>", src)
+	}
+}
+
+// eval evaluates the expression against the graph state.
+func (e *stateExpr) eval(state graph.State) (bool, error) {
+	left := e.left.resolve(state)
+	if e.op == "" {
+		return truthy(left), nil
+	}
+	right := e.right.resolve(state)
+
+	switch e.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "contains":
+		return contains(left, right), nil
+	}
+
+	l, lok := toFloat(left)
+	r, rok := toFloat(right)
+	if !lok || !rok {
+		return false, fmt.Errorf("operator %s needs numeric operands, got %v and %v", e.op, left, right)
+	}
+	switch e.op {
+	case ">":
+		return l > r, nil
+	case ">=":
+		return l >= r, nil
+	case "<":
+		return l < r, nil
+	default: // "<="
+		return l <= r, nil
+	}
+}
+
+func tokenizeExpr(src string) ([]string, error) {
+	var (
+		tokens []string
+		cur    strings.Builder
+		quote  rune
+	)
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	runes := []rune(src)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			cur.WriteRune(r)
+			if r == quote {
+				quote = 0
+				flush()
+			}
+		case r == '"' || r == '\'':
+			flush()
+			quote = r
+			cur.WriteRune(r)
+		case r == ' ' || r == '\t':
+			flush()
+		case strings.ContainsRune("=!<>", r):
+			// Symbolic operators end the operand before them, so that
+			// route=="x" splits like route == "x".
+			flush()
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+				i++
+			}
+			tokens = append(tokens, op)
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in expression %q", src)
+	}
+	flush()
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return tokens, nil
+}
+
+func parseOperand(tok string) exprOperand {
+	if len(tok) >= 2 && (tok[0] == '"' || tok[0] == '\'') && tok[len(tok)-1] == tok[0] {
+		return exprOperand{literal: tok[1 : len(tok)-1]}
+	}
+	switch tok {
+	case "true":
+		return exprOperand{literal: true}
+	case "false":
+		return exprOperand{literal: false}
+	case "null", "nil":
+		return exprOperand{}
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return exprOperand{literal: f}
+	}
+	return exprOperand{path: tok}
+}
+
+func (o exprOperand) resolve(state graph.State) any {
+	if o.path == "" {
+		return o.literal
+	}
+	v, _ := lookupStatePath(state, o.path)
+	return v
+}
+
+// lookupStatePath resolves a dotted path such as "node_responses.triage".
+func lookupStatePath(state graph.State, path string) (any, bool) {
+	return lookupPath(map[string]any(state), path)
+}
+
+// lookupPath resolves a dotted path in a decoded JSON value. Numeric parts
+// index arrays, e.g. "items.0.id".
+func lookupPath(v any, path string) (any, bool) {
+	cur := v
+	for _, part := range strings.Split(path, ".") {
+		switch m := cur.(type) {
+		case map[string]any:
+			v, ok := m[part]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case map[string]string:
+			v, ok := m[part]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(m) {
+				return nil, false
+			}
+			cur = m[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// stringify renders a state value as a route key.
+func stringify(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []byte:
+		return string(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	}
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() > 0
+	}
+	return true
+}
+
+// valuesEqual compares two values without converting between strings,
+// numbers and bools. Numbers of any type compare by value and []byte
+// compares like a string.
+func valuesEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	af, aNum := toNumber(a)
+	bf, bNum := toNumber(b)
+	if aNum || bNum {
+		return aNum && bNum && af == bf
+	}
+	as, aStr := toText(a)
+	bs, bStr := toText(b)
+	if aStr || bStr {
+		return aStr && bStr && as == bs
+	}
+	if ab, ok := a.(bool); ok {
+		bb, ok := b.(bool)
+		return ok && ab == bb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toText(v any) (string, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case []byte:
+		return string(t), true
+	}
+	return "", false
+}
+
+func contains(haystack, needle any) bool {
+	if s, ok := haystack.(string); ok {
+		return strings.Contains(s, stringify(needle))
+	}
+	rv := reflect.ValueOf(haystack)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			if valuesEqual(rv.Index(i).Interface(), needle) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toFloat converts numbers and numeric strings.
+func toFloat(v any) (float64, bool) {
+	if s, ok := v.(string); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return f, err == nil
+	}
+	return toNumber(v)
+}
+
+// toNumber converts numbers only.
+func toNumber(v any) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case float32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int64:
+		return float64(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package agents
+
+import (
+	"strings"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+func TestParseStateExprErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{`route == "billing`, "unterminated string"},
+		{"route = 'x'", `unsupported operator "="`},
+		{"route ! 'x'", `unsupported operator "!"`},
+		{"route matches 'x'", `unsupported operator "matches"`},
+		{"a == b == c", "must be <operand> or <operand> <op> <operand>"},
+		{"a b", "must be <operand> or <operand> <op> <operand>"},
+		{"== 'x'", "must be <operand> or <operand> <op> <operand>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parseStateExpr(tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseStateExpr(%q) error = %v, want %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{`route == "billing"`, []string{"route", "==", `"billing"`}},
+		{`route=="billing"`, []string{"route", "==", `"billing"`}},
+		{`count>=3`, []string{"count", ">=", "3"}},
+		{`count<3`, []string{"count", "<", "3"}},
+		{`a!='x y'`, []string{"a", "!=", "'x y'"}},
+		{`"a==b" == a`, []string{`"a==b"`, "==", "a"}},
+		{"tags contains 'vip'", []string{"tags", "contains", "'vip'"}},
+		{"\tflag ", []string{"flag"}},
+	}
+	for _, tt := range tests {
+		got, err := tokenizeExpr(tt.src)
+		if err != nil {
+			t.Errorf("tokenizeExpr(%q): %v", tt.src, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("tokenizeExpr(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestStateExprEval(t *testing.T) {
+	state := graph.State{
+		"route":   "billing",
+		"code":    "007",
+		"count":   float64(3),
+		"retries": 2,
+		"ok":      true,
+		"empty":   "",
+		"tags":    []any{"vip", float64(7)},
+		"raw":     []byte("bytes"),
+		"node_responses": map[string]any{
+			"triage": "refund please",
+		},
+		"items": []any{map[string]any{"id": "first"}},
+	}
+
+	tests := []struct {
+		src     string
+		want    bool
+		wantErr bool
+	}{
+		{`route == "billing"`, true, false},
+		{`route=="billing"`, true, false},
+		{`route == 'support'`, false, false},
+		{`route != "support"`, true, false},
+		{`node_responses.triage contains "refund"`, true, false},
+		{`items.0.id == "first"`, true, false},
+		{`raw == "bytes"`, true, false},
+		{`missing == null`, true, false},
+		{`route == null`, false, false},
+
+		// Equality never converts between types.
+		{`code == 7`, false, false},
+		{`code == "007"`, true, false},
+		{`count == "3"`, false, false},
+		{`count == 3`, true, false},
+		{`retries == 2`, true, false},
+		{`ok == true`, true, false},
+		{`ok == "true"`, false, false},
+		{`tags contains 7`, true, false},
+		{`tags contains "7"`, false, false},
+
+		// Ordering accepts numeric strings.
+		{`count > 2`, true, false},
+		{`count<=2`, false, false},
+		{`code >= 7`, true, false},
+		{`route > 1`, false, true},
+
+		// Truthiness.
+		{`ok`, true, false},
+		{`empty`, false, false},
+		{`missing`, false, false},
+		{`tags`, true, false},
+		{`count`, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			expr, err := parseStateExpr(tt.src)
+			if err != nil {
+				t.Fatalf("parseStateExpr: %v", err)
+			}
+			got, err := expr.eval(state)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("eval error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("eval = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+)
+
+// reservedStateKeys are owned by the graph executor and may not be written
+// by transform nodes.
+var reservedStateKeys = map[string]bool{
+	graph.StateKeyMessages:       true,
+	graph.StateKeySession:        true,
+	graph.StateKeyExecContext:    true,
+	graph.StateKeyCurrentNodeID:  true,
+	graph.StateKeyToolCallbacks:  true,
+	graph.StateKeyModelCallbacks: true,
+	graph.StateKeyAgentCallbacks: true,
+	graph.StateKeyParentAgent:    true,
+}
+
+func isReservedStateKey(key string) bool {
+	return reservedStateKeys[key] || strings.HasPrefix(key, "__")
+}
+
+// transformNodeFunc returns a node that writes literal values (set) and
+// copies values between state keys (copy). Copies read the state as it was
+// before the node ran.
+func transformNodeFunc(set map[string]any, copies map[string]string) graph.NodeFunc {
+	return func(ctx context.Context, s graph.State) (any, error) {
+		update := make(graph.State, len(set)+len(copies))
+		for k, v := range set {
+			update[k] = v
+		}
+		for dst, src := range copies {
+			if v, ok := lookupStatePath(s, src); ok {
+				update[dst] = v
+			}
+		}
+		return update, nil
+	}
+}
+
+// toolsByName indexes the built agent tools by their declared name.
+func toolsByName(tools []tool.Tool) map[string]tool.Tool {
+	out := make(map[string]tool.Tool, len(tools))
+	for _, t := range tools {
+		out[t.Declaration().Name] = t
+	}
+	return out
+}
+
+// selectTools returns the subset of all named by names, or all when names
+// is empty.
+func selectTools(all map[string]tool.Tool, names []string) (map[string]tool.Tool, error) {
+	if len(names) == 0 {
+		return all, nil
+	}
+	out := make(map[string]tool.Tool, len(names))
+	for _, n := range names {
+		t, ok := all[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %q", n)
+		}
+		out[n] = t
+	}
+	return out, nil
+}
+
+// interruptBeforeFunc pauses the run before fn until it is resumed from the
+// interrupt checkpoint. Resuming with false fails the run without running
+// fn; any other value runs it. The resume is forgotten once used, so a node
+// in a loop pauses again on its next turn.
+func interruptBeforeFunc(nodeID string, fn graph.NodeFunc) graph.NodeFunc {
+	key := "interrupt_before:" + nodeID
+	return func(ctx context.Context, s graph.State) (any, error) {
+		// The interrupt error must reach the executor unwrapped.
+		resume, err := graph.Interrupt(ctx, s, key, map[string]any{"interrupt_before": nodeID})
+		if err != nil {
+			return nil, err
+		}
+		if used, ok := s[graph.StateKeyUsedInterrupts].(map[string]any); ok {
+			delete(used, key)
+		}
+		if ok, isBool := resume.(bool); isBool && !ok {
+			return nil, fmt.Errorf("node %s was not run: resumed with false", nodeID)
+		}
+		return fn(ctx, s)
+	}
+}
@@ -0,0 +1,203 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	appmodel "helixrun/internal/model"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// defaultRouteKey is the path map key used for GraphConditionalEdgeConfig.Default.
+const defaultRouteKey = "__default__"
+
+// routerNodeFunc classifies the latest user input into one of labels and
+// stores the label under outputKey.
+func routerNodeFunc(llmModel model.Model, genCfg model.GenerationConfig, instruction string, labels []string, outputKey string) graph.NodeFunc {
+	if outputKey == "" {
+		outputKey = defaultRouterOutputKey
+	}
+	return func(ctx context.Context, s graph.State) (any, error) {
+		label, err := classify(ctx, llmModel, genCfg, instruction, labels, classificationInput(s))
+		if err != nil {
+			return nil, err
+		}
+		return graph.State{outputKey: label}, nil
+	}
+}
+
+// addConditionalEdge registers a configured conditional edge on sg.
+func addConditionalEdge(sg *graph.StateGraph, llmModel model.Model, genCfg model.GenerationConfig, ce GraphConditionalEdgeConfig) error {
+	cond, pathMap, err := edgeCondition(llmModel, genCfg, ce)
+	if err != nil {
+		return err
+	}
+	sg.AddConditionalEdges(ce.From, cond, pathMap)
+	return nil
+}
+
+// edgeCondition builds the condition of a conditional edge and the path map
+// from its results to node IDs.
+func edgeCondition(llmModel model.Model, genCfg model.GenerationConfig, ce GraphConditionalEdgeConfig) (graph.ConditionalFunc, map[string]string, error) {
+	pathMap := make(map[string]string, len(ce.Routes)+1)
+	for k, to := range ce.Routes {
+		pathMap[k] = to
+	}
+	if ce.Default != "" {
+		pathMap[defaultRouteKey] = ce.Default
+	}
+
+	// pick maps a raw condition value onto a path map key.
+	pick := func(value string) (string, error) {
+		if key, ok := matchRoute(ce.Routes, value); ok {
+			return key, nil
+		}
+		if ce.Default != "" {
+			return defaultRouteKey, nil
+		}
+		return "", fmt.Errorf("conditional edge from %q: no route for %q and no default", ce.From, value)
+	}
+
+	var cond graph.ConditionalFunc
+	switch ce.Condition.Type {
+	case GraphConditionStateKey:
+		key := ce.Condition.Key
+		cond = func(ctx context.Context, s graph.State) (string, error) {
+			v, _ := lookupStatePath(s, key)
+			return pick(stringify(v))
+		}
+
+	case GraphConditionLLM:
+		labels := routeLabels(ce.Routes)
+		instruction := ce.Condition.Instruction
+		cond = func(ctx context.Context, s graph.State) (string, error) {
+			label, err := classify(ctx, llmModel, genCfg, instruction, labels, classificationInput(s))
+			if err != nil {
+				return "", err
+			}
+			return pick(label)
+		}
+
+	case GraphConditionExpression:
+		expr, err := parseStateExpr(ce.Condition.Expression)
+		if err != nil {
+			return nil, nil, fmt.Errorf("conditional edge from %q: %w", ce.From, err)
+		}
+		cond = func(ctx context.Context, s graph.State) (string, error) {
+			ok, err := expr.eval(s)
+			if err != nil {
+				return "", fmt.Errorf("conditional edge from %q: %w", ce.From, err)
+			}
+			if ok {
+				return pick("true")
+			}
+			return pick("false")
+		}
+
+	default:
+		return nil, nil, fmt.Errorf("unsupported condition type: %s", ce.Condition.Type)
+	}
+	return cond, pathMap, nil
+}
+
+// matchRoute finds the route key for value, first exactly and then
+// case-insensitively.
+func matchRoute(routes map[string]string, value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if _, ok := routes[value]; ok {
+		return value, true
+	}
+	for k := range routes {
+		if strings.EqualFold(k, value) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func routeLabels(routes map[string]string) []string {
+	labels := make([]string, 0, len(routes))
+	for k := range routes {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// classificationInput returns the text to classify: the pending user input
+// or, once consumed, the latest user message.
+func classificationInput(s graph.State) string {
+	if in, ok := s[graph.StateKeyUserInput].(string); ok && in != "" {
+		return in
+	}
+	if msgs, ok := s[graph.StateKeyMessages].([]model.Message); ok {
+		for i := len(msgs) - 1; i >= 0; i-- {
+			if msgs[i].Role == model.RoleUser {
+				return msgs[i].Content
+			}
+		}
+	}
+	return ""
+}
+
+// classify asks the model to pick one of labels for input. It returns ""
+// when the answer matches none of them. The call never streams.
+func classify(ctx context.Context, llmModel model.Model, genCfg model.GenerationConfig, instruction string, labels []string, input string) (string, error) {
+	genCfg.Stream = false
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sys := strings.TrimSpace(instruction + "\n\nRespond with exactly one of the following labels and nothing else: " +
+		strings.Join(labels, ", ") + ".")
+
+	respCh, err := llmModel.GenerateContent(ctx, &model.Request{
+		Messages: []model.Message{
+			model.NewSystemMessage(sys),
+			model.NewUserMessage(input),
+		},
+		GenerationConfig: genCfg,
+	})
+	if err != nil {
+		return "", fmt.Errorf("classify: %w", err)
+	}
+
+	// The channel is drained even after an error so that the producer can
+	// finish; cancelling ctx makes it stop early.
+	var (
+		answer  string
+		respErr *model.ResponseError
+	)
+	for resp := range respCh {
+		// Classification calls produce no events; their usage is reported
+		// to the run directly.
+		appmodel.ReportUsage(ctx, resp)
+		switch {
+		case resp == nil || respErr != nil:
+		case resp.Error != nil:
+			respErr = resp.Error
+			cancel()
+		case !resp.IsPartial && len(resp.Choices) > 0:
+			answer = resp.Choices[0].Message.Content
+		}
+	}
+	if respErr != nil {
+		return "", fmt.Errorf("classify: %s", respErr.Message)
+	}
+
+	answer = strings.Trim(strings.ToLower(strings.TrimSpace(answer)), " .\"'`")
+	for _, l := range labels {
+		if strings.ToLower(l) == answer {
+			return l, nil
+		}
+	}
+	for _, l := range labels {
+		if strings.Contains(answer, strings.ToLower(l)) {
+			return l, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,262 @@
+package agents
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appmodel "helixrun/internal/model"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// stubModel answers every request with responses, sent one by one on an
+// unbuffered channel. done is closed when a producer goroutine finished.
+type stubModel struct {
+	responses []*model.Response
+
+	mu       sync.Mutex
+	requests []*model.Request
+	done     chan struct{}
+}
+
+func replyModel(content string) *stubModel {
+	return &stubModel{responses: []*model.Response{{
+		Choices: []model.Choice{{Message: model.NewAssistantMessage(content)}},
+		Done:    true,
+	}}}
+}
+
+func (m *stubModel) GenerateContent(ctx context.Context, req *model.Request) (<-chan *model.Response, error) {
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	m.done = make(chan struct{})
+	done := m.done
+	m.mu.Unlock()
+
+	ch := make(chan *model.Response)
+	go func() {
+		defer close(done)
+		defer close(ch)
+		for _, r := range m.responses {
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (m *stubModel) Info() model.Info { return model.Info{Name: "stub"} }
+
+func TestEdgeConditionRoutes(t *testing.T) {
+	routes := map[string]string{"billing": "billing_node", "support": "support_node"}
+	boolRoutes := map[string]string{"true": "yes_node", "false": "no_node"}
+	userInput := func(text string) graph.State { return graph.State{graph.StateKeyUserInput: text} }
+
+	tests := []struct {
+		name    string
+		cond    GraphConditionConfig
+		routes  map[string]string
+		def     string
+		model   *stubModel
+		state   graph.State
+		want    string // node ID
+		wantErr string
+	}{
+		{
+			name:  "state key",
+			cond:  GraphConditionConfig{Type: GraphConditionStateKey, Key: "route"},
+			state: graph.State{"route": "billing"},
+			want:  "billing_node",
+		},
+		{
+			name:  "state key matches case-insensitively",
+			cond:  GraphConditionConfig{Type: GraphConditionStateKey, Key: "route"},
+			state: graph.State{"route": " Support "},
+			want:  "support_node",
+		},
+		{
+			name:  "nested state key",
+			cond:  GraphConditionConfig{Type: GraphConditionStateKey, Key: "metadata.tier"},
+			state: graph.State{"metadata": map[string]any{"tier": "billing"}},
+			want:  "billing_node",
+		},
+		{
+			name:  "state key falls back to the default",
+			cond:  GraphConditionConfig{Type: GraphConditionStateKey, Key: "route"},
+			def:   "fallback_node",
+			state: graph.State{"route": "sales"},
+			want:  "fallback_node",
+		},
+		{
+			name:    "state key without a match or default",
+			cond:    GraphConditionConfig{Type: GraphConditionStateKey, Key: "route"},
+			state:   graph.State{},
+			wantErr: `no route for ""`,
+		},
+		{
+			name:   "expression true",
+			cond:   GraphConditionConfig{Type: GraphConditionExpression, Expression: `count>=3`},
+			routes: boolRoutes,
+			state:  graph.State{"count": 3},
+			want:   "yes_node",
+		},
+		{
+			name:   "expression false",
+			cond:   GraphConditionConfig{Type: GraphConditionExpression, Expression: `code == 7`},
+			routes: boolRoutes,
+			state:  graph.State{"code": "7"},
+			want:   "no_node",
+		},
+		{
+			name:    "expression error",
+			cond:    GraphConditionConfig{Type: GraphConditionExpression, Expression: `route > 1`},
+			routes:  boolRoutes,
+			state:   graph.State{"route": "x"},
+			wantErr: "needs numeric operands",
+		},
+		{
+			name:  "llm label",
+			cond:  GraphConditionConfig{Type: GraphConditionLLM, Instruction: "Pick a team."},
+			model: replyModel("Billing."),
+			state: userInput("my invoice is wrong"),
+			want:  "billing_node",
+		},
+		{
+			name:  "llm label inside a sentence",
+			cond:  GraphConditionConfig{Type: GraphConditionLLM},
+			model: replyModel("I would say support"),
+			state: userInput("it crashes"),
+			want:  "support_node",
+		},
+		{
+			name:  "llm without a label uses the default",
+			cond:  GraphConditionConfig{Type: GraphConditionLLM},
+			def:   "fallback_node",
+			model: replyModel("no idea"),
+			state: userInput("hello"),
+			want:  "fallback_node",
+		},
+		{
+			name: "llm error",
+			cond: GraphConditionConfig{Type: GraphConditionLLM},
+			model: &stubModel{responses: []*model.Response{
+				{Error: &model.ResponseError{Message: "rate limited"}},
+				{Choices: []model.Choice{{Message: model.NewAssistantMessage("billing")}}},
+			}},
+			state:   userInput("hello"),
+			wantErr: "classify: rate limited",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ce := GraphConditionalEdgeConfig{From: "start", Condition: tt.cond, Routes: routes, Default: tt.def}
+			if tt.routes != nil {
+				ce.Routes = tt.routes
+			}
+			var m model.Model
+			if tt.model != nil {
+				m = tt.model
+			}
+			cond, pathMap, err := edgeCondition(m, model.GenerationConfig{Stream: true}, ce)
+			if err != nil {
+				t.Fatalf("edgeCondition: %v", err)
+			}
+
+			key, err := cond(context.Background(), tt.state)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("condition error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("condition: %v", err)
+			} else if got := pathMap[key]; got != tt.want {
+				t.Errorf("routed to %q (key %q), want %q", got, key, tt.want)
+			}
+
+			if tt.model != nil {
+				select {
+				case <-tt.model.done:
+				case <-time.After(time.Second):
+					t.Fatal("the model's producer goroutine did not finish")
+				}
+				req := tt.model.requests[0]
+				if req.Stream {
+					t.Error("classification request streams")
+				}
+				if got := req.Messages[1].Content; got != tt.state[graph.StateKeyUserInput] {
+					t.Errorf("classified %q, want the user input", got)
+				}
+				if sys := req.Messages[0].Content; !strings.Contains(sys, "billing, support") {
+					t.Errorf("system prompt %q does not list the labels", sys)
+				}
+			}
+		})
+	}
+}
+
+func TestEdgeConditionInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cond GraphConditionConfig
+		want string
+	}{
+		{"unknown type", GraphConditionConfig{Type: "random"}, "unsupported condition type"},
+		{"bad expression", GraphConditionConfig{Type: GraphConditionExpression, Expression: "a = b"}, `conditional edge from "start"`},
+	}
+	for _, tt := range tests {
+		_, _, err := edgeCondition(nil, model.GenerationConfig{}, GraphConditionalEdgeConfig{From: "start", Condition: tt.cond})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestRouterNodeWritesLabel(t *testing.T) {
+	m := replyModel("support")
+	fn := routerNodeFunc(m, model.GenerationConfig{}, "", []string{"billing", "support"}, "")
+	out, err := fn(context.Background(), graph.State{
+		graph.StateKeyMessages: []model.Message{model.NewUserMessage("it crashes"), model.NewAssistantMessage("sorry")},
+	})
+	if err != nil {
+		t.Fatalf("router: %v", err)
+	}
+	if got := out.(graph.State)[defaultRouterOutputKey]; got != "support" {
+		t.Errorf("route = %v, want support", got)
+	}
+	if got := m.requests[0].Messages[1].Content; got != "it crashes" {
+		t.Errorf("classified %q, want the latest user message", got)
+	}
+}
+
+func TestClassifyReportsUsage(t *testing.T) {
+	m := &stubModel{responses: []*model.Response{
+		{IsPartial: true, Usage: &model.Usage{TotalTokens: 1}},
+		{
+			Choices: []model.Choice{{Message: model.NewAssistantMessage("billing")}},
+			Usage:   &model.Usage{PromptTokens: 40, CompletionTokens: 2, TotalTokens: 42},
+			Done:    true,
+		},
+	}}
+	var reported []*model.Response
+	ctx := appmodel.ContextWithUsage(context.Background(), func(_ context.Context, resp *model.Response) {
+		reported = append(reported, resp)
+	})
+
+	label, err := classify(ctx, m, model.GenerationConfig{}, "", []string{"billing", "support"}, "my invoice")
+	if err != nil {
+		t.Fatalf("classify: %v", err)
+	}
+	if label != "billing" {
+		t.Errorf("label = %q, want billing", label)
+	}
+	if len(reported) != 1 || reported[0].Usage.TotalTokens != 42 {
+		t.Fatalf("reported %d responses, want the final one with 42 tokens", len(reported))
+	}
+}
@@ -0,0 +1,357 @@
+package agents
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	appmodel "helixrun/internal/model"
+
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+	"trpc.group/trpc-go/trpc-agent-go/tool/function"
+)
+
+const (
+	// defaultHTTPToolTimeout bounds calls of http tools without timeout.
+	defaultHTTPToolTimeout = 10 * time.Second
+	// maxHTTPToolResponse bounds the response body an http tool reads.
+	maxHTTPToolResponse = 1 << 20
+	// maxHTTPToolErrorBody bounds the body returned for error statuses.
+	maxHTTPToolErrorBody = 2 << 10
+	// maxHTTPToolRedirects bounds the redirects an http tool follows.
+	maxHTTPToolRedirects = 5
+)
+
+var (
+	urlPlaceholder    = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	secretPlaceholder = regexp.MustCompile(`\{secret:([^{}]+)\}`)
+)
+
+// httpTool sends the request described by an HTTPToolConfig.
+type httpTool struct {
+	cfg    HTTPToolConfig
+	method string
+	client *http.Client
+}
+
+func newHTTPTool(name string, cfg HTTPToolConfig) tool.Tool {
+	t := &httpTool{cfg: cfg, method: cfg.method()}
+	t.client = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxHTTPToolRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxHTTPToolRedirects)
+			}
+			if !hostAllowed(cfg.AllowedHosts, req.URL) {
+				return fmt.Errorf("redirect to host %s is not allowed", req.URL.Host)
+			}
+			return nil
+		},
+	}
+
+	schema := cfg.Parameters
+	if schema == nil {
+		schema = &tool.Schema{Type: "object", Properties: map[string]*tool.Schema{}}
+	}
+	// The result type is any, which has no schema to derive.
+	output := &tool.Schema{Description: "The JSON response"}
+	if len(cfg.Response) > 0 {
+		output = &tool.Schema{Type: "object", Properties: make(map[string]*tool.Schema, len(cfg.Response))}
+		for field, path := range cfg.Response {
+			output.Properties[field] = &tool.Schema{Description: "Response field " + path}
+		}
+	}
+	desc := cfg.Description
+	if desc == "" {
+		desc = fmt.Sprintf("Calls %s %s.", t.method, cfg.URL)
+	}
+	return function.NewFunctionTool(
+		t.call,
+		function.WithName(name),
+		function.WithDescription(desc),
+		function.WithInputSchema(schema),
+		function.WithOutputSchema(output),
+	)
+}
+
+// call sends the request. Error statuses are returned as a result so the
+// model can react to them; invalid arguments and failed requests are errors.
+func (t *httpTool) call(ctx context.Context, args map[string]any) (any, error) {
+	if err := checkArgs(t.cfg.Parameters, args); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	u, rest, err := t.buildURL(args)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if t.method == http.MethodGet || t.method == http.MethodDelete {
+		q := u.Query()
+		for k, v := range rest {
+			q.Set(k, argString(v))
+		}
+		u.RawQuery = q.Encode()
+	} else {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return nil, fmt.Errorf("encode request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	timeout := time.Duration(t.cfg.Timeout)
+	if timeout == 0 {
+		timeout = defaultHTTPToolTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, t.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range t.cfg.Headers {
+		v, err := resolveHeader(ctx, value)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, v)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// The URL may hold arguments but never secrets.
+		return nil, fmt.Errorf("%s %s: %w", t.method, u.Redacted(), unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPToolResponse+1))
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if len(data) > maxHTTPToolResponse {
+		return nil, fmt.Errorf("response exceeds %d bytes", maxHTTPToolResponse)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(data) > maxHTTPToolErrorBody {
+			data = data[:maxHTTPToolErrorBody]
+		}
+		return map[string]any{
+			"status":      "error",
+			"status_code": resp.StatusCode,
+			"body":        string(data),
+		}, nil
+	}
+
+	var v any
+	if len(bytes.TrimSpace(data)) > 0 && json.Unmarshal(data, &v) != nil {
+		v = string(data)
+	}
+	if len(t.cfg.Response) == 0 {
+		return v, nil
+	}
+	out := make(map[string]any, len(t.cfg.Response))
+	for field, path := range t.cfg.Response {
+		out[field], _ = lookupPath(v, path)
+	}
+	return out, nil
+}
+
+// buildURL fills the URL placeholders and returns the arguments left over.
+// Placeholders in the query are query-escaped, the others path-escaped.
+func (t *httpTool) buildURL(args map[string]any) (*url.URL, map[string]any, error) {
+	rest := make(map[string]any, len(args))
+	for k, v := range args {
+		rest[k] = v
+	}
+
+	queryAt := strings.Index(t.cfg.URL, "?")
+	var missing []string
+	var b strings.Builder
+	last := 0
+	for _, m := range urlPlaceholder.FindAllStringSubmatchIndex(t.cfg.URL, -1) {
+		b.WriteString(t.cfg.URL[last:m[0]])
+		last = m[1]
+
+		name := t.cfg.URL[m[2]:m[3]]
+		v, ok := args[name]
+		if !ok || v == nil {
+			missing = append(missing, name)
+			continue
+		}
+		delete(rest, name)
+		s := argString(v)
+		if queryAt >= 0 && m[0] > queryAt {
+			b.WriteString(url.QueryEscape(s))
+			continue
+		}
+		// PathEscape keeps dots, so "." and ".." would move the request to
+		// another path of the host.
+		if s == "." || s == ".." {
+			return nil, nil, fmt.Errorf("invalid arguments: %s must not be %q", name, s)
+		}
+		b.WriteString(url.PathEscape(s))
+	}
+	b.WriteString(t.cfg.URL[last:])
+	if len(missing) > 0 {
+		return nil, nil, fmt.Errorf("invalid arguments: missing %s for the URL", strings.Join(missing, ", "))
+	}
+
+	u, err := url.Parse(b.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("build URL: %w", unwrapURLError(err))
+	}
+	if !hostAllowed(t.cfg.AllowedHosts, u) {
+		return nil, nil, fmt.Errorf("host %s is not allowed", u.Host)
+	}
+	return u, rest, nil
+}
+
+// resolveHeader replaces the {secret:<ref>} placeholders of a header value.
+// Errors name the secret source, never the secret.
+func resolveHeader(ctx context.Context, value string) (string, error) {
+	var firstErr error
+	out := secretPlaceholder.ReplaceAllStringFunc(value, func(m string) string {
+		ref := secretPlaceholder.FindStringSubmatch(m)[1]
+		secret, source, err := appmodel.ResolveSecret(ctx, ref)
+		switch {
+		case err != nil:
+			firstErr = cmp.Or(firstErr, err)
+		case secret == "":
+			firstErr = cmp.Or(firstErr, fmt.Errorf("secret from %s is empty", source))
+		}
+		return secret
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return out, nil
+}
+
+// hostAllowed reports whether the host of u is in allowed. Entries with a
+// port match host:port, "*.example.com" matches subdomains of example.com.
+func hostAllowed(allowed []string, u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		switch {
+		case strings.HasPrefix(a, "*."):
+			if strings.HasSuffix(host, a[1:]) {
+				return true
+			}
+		case strings.Contains(a, ":"):
+			if strings.ToLower(u.Host) == a {
+				return true
+			}
+		case host == a:
+			return true
+		}
+	}
+	return false
+}
+
+// argString renders an argument for a URL: scalars as text, objects and
+// arrays as JSON.
+func argString(v any) string {
+	switch v.(type) {
+	case map[string]any, []any:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return stringify(v)
+}
+
+// unwrapURLError drops the *url.Error wrapper, whose message repeats the
+// full URL.
+func unwrapURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
+
+// checkArgs checks args against the parts of schema models most often get
+// wrong: required properties and the type and enum of top-level properties.
+func checkArgs(schema *tool.Schema, args map[string]any) error {
+	if schema == nil {
+		return nil
+	}
+	var errs []error
+	for _, name := range schema.Required {
+		if v, ok := args[name]; !ok || v == nil {
+			errs = append(errs, fmt.Errorf("%s is required", name))
+		}
+	}
+
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := args[name]
+		prop, ok := schema.Properties[name]
+		if !ok || prop == nil {
+			if open, ok := schema.AdditionalProperties.(bool); ok && !open {
+				errs = append(errs, fmt.Errorf("unknown argument %s", name))
+			}
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		if prop.Type != "" && !schemaTypeMatches(prop.Type, v) {
+			errs = append(errs, fmt.Errorf("%s must be of type %s", name, prop.Type))
+			continue
+		}
+		if len(prop.Enum) > 0 && !slices.ContainsFunc(prop.Enum, func(e any) bool { return valuesEqual(e, v) }) {
+			errs = append(errs, fmt.Errorf("%s must be one of %v", name, prop.Enum))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func schemaTypeMatches(typ string, v any) bool {
+	switch typ {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	default:
+		return true
+	}
+}
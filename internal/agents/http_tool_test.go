@@ -0,0 +1,378 @@
+package agents
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+)
+
+// httpToolFromJSON decodes an http tool config and builds the tool.
+func httpToolFromJSON(t *testing.T, cfg string) (*httpTool, tool.CallableTool) {
+	t.Helper()
+	var c HTTPToolConfig
+	if err := json.Unmarshal([]byte(cfg), &c); err != nil {
+		t.Fatalf("decode %s: %v", cfg, err)
+	}
+	return &httpTool{cfg: c, method: c.method()}, newHTTPTool("http_test", c).(tool.CallableTool)
+}
+
+func TestHTTPToolBuildURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		args     string
+		want     string
+		wantRest string
+		wantErr  string
+	}{
+		{
+			name: "path placeholders are path-escaped",
+			url:  "https://api.example.com/customers/{id}/orders/{n}",
+			args: `{"id":"a/b c?","n":42}`,
+			want: "https://api.example.com/customers/a%2Fb%20c%3F/orders/42",
+		},
+		{
+			name: "query placeholders are query-escaped",
+			url:  "https://api.example.com/search?q={q}&page={page}",
+			args: `{"q":"a&b=c d","page":2.5}`,
+			want: "https://api.example.com/search?q=a%26b%3Dc+d&page=2.5",
+		},
+		{
+			name: "objects are JSON",
+			url:  "https://api.example.com/find?filter={filter}",
+			args: `{"filter":{"a":[1,2]}}`,
+			want: "https://api.example.com/find?filter=%7B%22a%22%3A%5B1%2C2%5D%7D",
+		},
+		{
+			name:     "other arguments are left over",
+			url:      "https://api.example.com/customers/{id}",
+			args:     `{"id":"7","limit":10,"sort":"name"}`,
+			want:     "https://api.example.com/customers/7",
+			wantRest: "[limit sort]",
+		},
+		{
+			name: "dots inside a segment",
+			url:  "https://api.example.com/files/{name}",
+			args: `{"name":"..."}`,
+			want: "https://api.example.com/files/...",
+		},
+		{
+			name: "dots in the query",
+			url:  "https://api.example.com/files?dir={dir}",
+			args: `{"dir":".."}`,
+			want: "https://api.example.com/files?dir=..",
+		},
+		{
+			name:    "dot-dot path argument",
+			url:     "https://api.example.com/customers/{id}/orders",
+			args:    `{"id":".."}`,
+			wantErr: `id must not be ".."`,
+		},
+		{
+			name:    "dot path argument",
+			url:     "https://api.example.com/customers/{id}",
+			args:    `{"id":"."}`,
+			wantErr: `id must not be "."`,
+		},
+		{
+			name:    "missing placeholders",
+			url:     "https://api.example.com/{a}/{b}",
+			args:    `{"b":null}`,
+			wantErr: "missing a, b for the URL",
+		},
+		{
+			name:    "host from an argument",
+			url:     "https://{host}/x",
+			args:    `{"host":"evil.com"}`,
+			wantErr: "host evil.com is not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ht, _ := httpToolFromJSON(t, fmt.Sprintf(`{"url":%q,"allowed_hosts":["api.example.com"]}`, tt.url))
+			var args map[string]any
+			if err := json.Unmarshal([]byte(tt.args), &args); err != nil {
+				t.Fatal(err)
+			}
+			u, rest, err := ht.buildURL(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildURL error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("buildURL: %v", err)
+			}
+			if u.String() != tt.want {
+				t.Errorf("URL = %s, want %s", u, tt.want)
+			}
+			keys := make([]string, 0, len(rest))
+			for k := range rest {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if want := cmp.Or(tt.wantRest, "[]"); fmt.Sprint(keys) != want {
+				t.Errorf("left over arguments = %v, want %s", keys, want)
+			}
+		})
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"api.example.com", "*.corp.example.com", "localhost:8080", "UPPER.example.com"}
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://api.example.com/x", true},
+		{"https://API.Example.com/x", true},
+		{"https://api.example.com:8443/x", true}, // entries without port match any port
+		{"https://upper.example.com", true},
+		{"https://a.corp.example.com", true},
+		{"https://a.b.corp.example.com", true},
+		{"https://corp.example.com", false}, // *. matches subdomains only
+		{"https://evilcorp.example.com", false},
+		{"https://corp.example.com.evil.com", false},
+		{"http://localhost:8080/x", true},
+		{"http://localhost:8081/x", false},
+		{"http://localhost/x", false},
+		{"https://api.example.com.evil.com", false},
+		{"https://evil.com/api.example.com", false},
+		{"https://api.example.com@evil.com/", false},
+		{"/relative", false},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hostAllowed(allowed, u); got != tt.ok {
+			t.Errorf("hostAllowed(%s) = %v, want %v", tt.url, got, tt.ok)
+		}
+	}
+	if hostAllowed(nil, &url.URL{Scheme: "https", Host: "api.example.com"}) {
+		t.Error("empty allowlist allows a host")
+	}
+}
+
+// echoServer answers every request with the JSON description of it.
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.Error(w, "no such customer", http.StatusNotFound)
+			return
+		case "/redirect":
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"method": r.Method,
+			"path":   r.URL.EscapedPath(),
+			"query":  r.URL.RawQuery,
+			"auth":   r.Header.Get("Authorization"),
+			"body":   string(body),
+			"data":   map[string]any{"items": []any{map[string]any{"id": 7}}},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func callHTTPTool(t *testing.T, ct tool.CallableTool, args string) (map[string]any, error) {
+	t.Helper()
+	out, err := ct.Call(context.Background(), []byte(args))
+	if err != nil {
+		return nil, err
+	}
+	m, ok := out.(map[string]any)
+	if !ok {
+		t.Fatalf("result = %#v, want an object", out)
+	}
+	return m, nil
+}
+
+func TestHTTPToolCall(t *testing.T) {
+	srv := echoServer(t)
+	t.Setenv("TEST_HTTP_TOKEN", "s3cret")
+
+	_, get := httpToolFromJSON(t, fmt.Sprintf(`{"url":"%s/customers/{id}","allowed_hosts":["127.0.0.1"],
+		"headers":{"Authorization":"Bearer {secret:env:TEST_HTTP_TOKEN}"},
+		"parameters":{"type":"object","properties":{"id":{"type":"string"},"limit":{"type":"integer"}},"required":["id"]}}`, srv.URL))
+	res, err := callHTTPTool(t, get, `{"id":"a b","limit":5}`)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	if res["method"] != "GET" || res["path"] != "/customers/a%20b" || res["query"] != "limit=5" || res["auth"] != "Bearer s3cret" {
+		t.Errorf("GET request = %v", res)
+	}
+
+	_, post := httpToolFromJSON(t, fmt.Sprintf(`{"method":"post","url":"%s/customers","allowed_hosts":["127.0.0.1"],
+		"response":{"first_id":"data.items.0.id","method":"method","body":"body","none":"x.y"}}`, srv.URL))
+	res, err = callHTTPTool(t, post, `{"name":"Ann","tags":["a"]}`)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	if res["first_id"] != 7.0 || res["method"] != "POST" || res["none"] != nil || len(res) != 4 {
+		t.Errorf("POST result = %v, want the mapped response", res)
+	}
+	if res["body"] != `{"name":"Ann","tags":["a"]}` {
+		t.Errorf("POST body = %v, want the arguments as JSON", res["body"])
+	}
+
+	_, missing := httpToolFromJSON(t, fmt.Sprintf(`{"url":"%s/missing","allowed_hosts":["127.0.0.1"]}`, srv.URL))
+	res, err = callHTTPTool(t, missing, `{}`)
+	if err != nil {
+		t.Fatalf("error status: %v", err)
+	}
+	if res["status"] != "error" || res["status_code"] != http.StatusNotFound || !strings.Contains(res["body"].(string), "no such customer") {
+		t.Errorf("error status result = %v", res)
+	}
+
+	if _, err := callHTTPTool(t, get, `{"limit":5}`); err == nil || !strings.Contains(err.Error(), "id is required") {
+		t.Errorf("call without id error = %v", err)
+	}
+	if _, err := callHTTPTool(t, get, `{"id":".."}`); err == nil || !strings.Contains(err.Error(), `must not be ".."`) {
+		t.Errorf("call with id .. error = %v", err)
+	}
+}
+
+func TestHTTPToolRedirects(t *testing.T) {
+	srv := echoServer(t)
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	_, ct := httpToolFromJSON(t, fmt.Sprintf(`{"url":"%s/redirect?to={to}","allowed_hosts":["127.0.0.1"]}`, srv.URL))
+
+	// Same host: followed.
+	res, err := callHTTPTool(t, ct, fmt.Sprintf(`{"to":"%s/target"}`, srv.URL))
+	if err != nil {
+		t.Fatalf("redirect to an allowed host: %v", err)
+	}
+	if res["path"] != "/target" {
+		t.Errorf("redirect ended at %v, want /target", res["path"])
+	}
+
+	// The same server under another name is not allowed.
+	_, err = callHTTPTool(t, ct, fmt.Sprintf(`{"to":"http://localhost:%d/target"}`, port))
+	if err == nil || !strings.Contains(err.Error(), "redirect to host localhost:") {
+		t.Errorf("redirect to a foreign host error = %v", err)
+	}
+
+	// Too many redirects.
+	to := srv.URL + "/target"
+	for i := 0; i <= maxHTTPToolRedirects; i++ {
+		to = srv.URL + "/redirect?to=" + url.QueryEscape(to)
+	}
+	if _, err := callHTTPTool(t, ct, fmt.Sprintf(`{"to":%q}`, to)); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("redirect loop error = %v", err)
+	}
+}
+
+func TestResolveHeader(t *testing.T) {
+	t.Setenv("TEST_HTTP_TOKEN", "s3cret")
+	t.Setenv("TEST_HTTP_USER", "ann")
+	t.Setenv("TEST_HTTP_EMPTY", "")
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value, want, wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"Bearer {secret:env:TEST_HTTP_TOKEN}", "Bearer s3cret", ""},
+		{"{secret:env:TEST_HTTP_USER}:{secret:env:TEST_HTTP_TOKEN}", "ann:s3cret", ""},
+		{"Bearer {secret:file:" + file + "}", "Bearer from-file", ""},
+		{"Bearer {secret:env:TEST_HTTP_UNSET}", "", "unset environment variable"},
+		{"Bearer {secret:env:TEST_HTTP_EMPTY}", "", "is empty"},
+		{"Bearer {secret:file:" + file + ".missing}", "", "read secret file"},
+		{"Bearer {secret:pool:openai}", "", "resolved per model call"},
+		{"{secret:env:TEST_HTTP_TOKEN} {secret:env:TEST_HTTP_UNSET}", "", "unset environment variable"},
+	}
+	for _, tt := range tests {
+		got, err := resolveHeader(context.Background(), tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("resolveHeader(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("resolveHeader(%q) error %q reveals the secret", tt.value, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveHeader(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckArgs(t *testing.T) {
+	var schema tool.Schema
+	if err := json.Unmarshal([]byte(`{"type":"object","additionalProperties":false,"required":["kind"],"properties":{
+		"kind":{"type":"string","enum":["a","b"]},
+		"level":{"type":"integer","enum":[1,2]},
+		"ratio":{"enum":[0.5,1]},
+		"codes":{"enum":["1","2"]},
+		"flag":{"type":"boolean"},
+		"tags":{"type":"array"},
+		"opts":{"type":"object"}}}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args    string
+		wantErr string
+	}{
+		{`{"kind":"a"}`, ""},
+		{`{"kind":"b","level":2,"ratio":0.5,"codes":"1","flag":false,"tags":[],"opts":{}}`, ""},
+		{`{"kind":"a","level":2.0,"ratio":1}`, ""}, // JSON numbers are float64
+		{`{"kind":"a","level":null}`, ""},
+		{`{}`, "kind is required"},
+		{`{"kind":null}`, "kind is required"},
+		{`{"kind":"c"}`, "kind must be one of [a b]"},
+		{`{"kind":"A"}`, "kind must be one of"},
+		{`{"kind":"a","level":3}`, "level must be one of [1 2]"},
+		{`{"kind":"a","level":"1"}`, "level must be of type integer"},
+		{`{"kind":"a","level":1.5}`, "level must be of type integer"},
+		{`{"kind":"a","ratio":"0.5"}`, "ratio must be one of"}, // no string to number coercion
+		{`{"kind":"a","codes":1}`, "codes must be one of"},     // nor number to string
+		{`{"kind":"a","flag":"true"}`, "flag must be of type boolean"},
+		{`{"kind":"a","tags":"x"}`, "tags must be of type array"},
+		{`{"kind":"a","opts":[]}`, "opts must be of type object"},
+		{`{"kind":"a","extra":1}`, "unknown argument extra"},
+	}
+	for _, tt := range tests {
+		var args map[string]any
+		if err := json.Unmarshal([]byte(tt.args), &args); err != nil {
+			t.Fatal(err)
+		}
+		err := checkArgs(&schema, args)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("checkArgs(%s): %v", tt.args, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("checkArgs(%s) error = %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+
+	if err := checkArgs(nil, map[string]any{"x": 1}); err != nil {
+		t.Errorf("checkArgs without schema: %v", err)
+	}
+}
@@ -1,39 +1,71 @@
 package agents
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	appmodel "helixrun/internal/model"
 
 	"trpc.group/trpc-go/trpc-agent-go/agent"
 	"trpc.group/trpc-go/trpc-agent-go/agent/chainagent"
+	"trpc.group/trpc-go/trpc-agent-go/agent/cycleagent"
 	"trpc.group/trpc-go/trpc-agent-go/agent/graphagent"
 	"trpc.group/trpc-go/trpc-agent-go/agent/llmagent"
+	"trpc.group/trpc-go/trpc-agent-go/agent/parallelagent"
+	"trpc.group/trpc-go/trpc-agent-go/event"
 	"trpc.group/trpc-go/trpc-agent-go/graph"
 	"trpc.group/trpc-go/trpc-agent-go/model"
 	"trpc.group/trpc-go/trpc-agent-go/tool"
 )
 
-// Registry holds JSON-based agent configs.
+// Registry holds JSON-based agent configs. The config set can be swapped
+// at runtime via Reload, so all access goes through mu.
 type Registry struct {
-	configs map[string]AgentConfig
+	dir string
+
+	mu          sync.RWMutex
+	configs     map[string]AgentConfig
+	fingerprint string // of dir, taken before configs were read
+	checkpoints graph.CheckpointSaver
 }
 
 // LoadRegistry loads all *.json configs from a directory.
 func LoadRegistry(dir string) (*Registry, error) {
+	fp, _ := dirFingerprint(dir)
+	configs, err := loadConfigs(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		dir:         dir,
+		configs:     configs,
+		fingerprint: fp,
+	}, nil
+}
+
+// literalKeysEnv selects what happens when a config contains a literal API
+// key: "warn" (default) logs a warning, "error" rejects the config.
+const literalKeysEnv = "HELIXRUN_LITERAL_KEYS"
+
+// loadConfigs reads, strictly decodes and validates every *.json file in
+// dir. Problems in all files are collected and returned together.
+func loadConfigs(dir string) (map[string]AgentConfig, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return nil, fmt.Errorf("read config dir: %w", err)
 	}
 
-	reg := &Registry{
-		configs: make(map[string]AgentConfig),
-	}
+	configs := make(map[string]AgentConfig)
+	sources := make(map[string]string)
+	var errs []error
 
 	for _, e := range entries {
 		if e.IsDir() {
@@ -44,32 +76,80 @@ func LoadRegistry(dir string) (*Registry, error) {
 		}
 
 		path := filepath.Join(dir, e.Name())
-		data, err := os.ReadFile(path)
+		cfg, err := decodeConfigFile(path)
 		if err != nil {
-			return nil, fmt.Errorf("read %s: %w", path, err)
-		}
-
-		var cfg AgentConfig
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			return nil, fmt.Errorf("unmarshal %s: %w", path, err)
+			errs = append(errs, err)
+			continue
 		}
 
 		if cfg.ID == "" {
 			cfg.ID = strings.TrimSuffix(e.Name(), ".json")
 		}
 
-		reg.configs[cfg.ID] = cfg
+		if prev, dup := sources[cfg.ID]; dup {
+			errs = append(errs, fmt.Errorf("%s: duplicate agent ID %q (already defined in %s)", path, cfg.ID, prev))
+			continue
+		}
+		sources[cfg.ID] = path
+
+		if err := cfg.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid agent %q: %w", path, cfg.ID, err))
+			continue
+		}
+		if paths := cfg.literalKeyPaths(); len(paths) > 0 {
+			// Only the location is reported, never the key.
+			msg := fmt.Sprintf("%s: agent %q has a literal API key in %s; use env:NAME, file:/path, cliproxy:<key-id> or pool:<provider>",
+				path, cfg.ID, strings.Join(paths, ", "))
+			if os.Getenv(literalKeysEnv) == "error" {
+				errs = append(errs, errors.New(msg))
+				continue
+			}
+			log.Printf("warning: %s", msg)
+		}
+
+		configs[cfg.ID] = cfg
 	}
 
-	if len(reg.configs) == 0 {
+	if len(errs) == 0 {
+		if err := validateAgentRefs(configs); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(configs) == 0 {
 		return nil, fmt.Errorf("no agent configs found in %s", dir)
 	}
 
-	return reg, nil
+	return configs, nil
+}
+
+// decodeConfigFile decodes a single config file, rejecting unknown fields.
+func decodeConfigFile(path string) (AgentConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AgentConfig{}, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var cfg AgentConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return AgentConfig{}, fmt.Errorf("unmarshal %s: %w", path, err)
+	}
+	if dec.More() {
+		return AgentConfig{}, fmt.Errorf("unmarshal %s: unexpected data after agent config", path)
+	}
+	return cfg, nil
 }
 
 // ListAgentIDs returns all known agent IDs.
 func (r *Registry) ListAgentIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	out := make([]string, 0, len(r.configs))
 	for id := range r.configs {
 		out = append(out, id)
@@ -77,83 +157,273 @@ func (r *Registry) ListAgentIDs() []string {
 	return out
 }
 
+// HasAgent reports whether id is a known agent ID.
+func (r *Registry) HasAgent(id string) bool {
+	_, ok := r.config(id)
+	return ok
+}
+
+// RequiresApproval reports whether runs of agent id may pause for a tool
+// approval: the agent, one of its sub-agents or an agent it calls has a
+// tool with requires_approval.
+func (r *Registry) RequiresApproval(id string) bool {
+	r.mu.RLock()
+	configs := r.configs
+	r.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var check func(id string) bool
+	check = func(id string) bool {
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+		cfg, ok := configs[id]
+		if !ok {
+			return false
+		}
+		if hasApprovalTool(cfg.Tools) {
+			return true
+		}
+		if cfg.Multi != nil {
+			for _, sub := range cfg.Multi.Agents {
+				if hasApprovalTool(sub.Tools) {
+					return true
+				}
+			}
+		}
+		for _, ref := range cfg.agentRefs() {
+			if check(ref.to) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(id)
+}
+
+func hasApprovalTool(tools []ToolConfig) bool {
+	for _, tc := range tools {
+		if tc.RequiresApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// WithCheckpointSaver makes graph agents save a checkpoint after every step,
+// which interrupt_before needs to resume a run.
+func (r *Registry) WithCheckpointSaver(saver graph.CheckpointSaver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoints = saver
+}
+
+// config returns the config for id from the current config set.
+func (r *Registry) config(id string) (AgentConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cfg, ok := r.configs[id]
+	return cfg, ok
+}
+
 // BuildAgent builds a fresh agent.Agent instance from config.
 func (r *Registry) BuildAgent(ctx context.Context, id string) (agent.Agent, error) {
-	cfg, ok := r.configs[id]
-	if !ok {
-		return nil, fmt.Errorf("unknown agent ID: %s", id)
+	r.mu.RLock()
+	configs, saver := r.configs, r.checkpoints
+	r.mu.RUnlock()
+
+	// Referenced agents are built from the same config set, which
+	// validateAgentRefs checked to be free of cycles.
+	var build agentBuilder
+	build = func(id string) (agent.Agent, error) {
+		cfg, ok := configs[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown agent ID: %s", id)
+		}
+		return buildAgent(cfg, build, saver)
 	}
+	return build(id)
+}
 
+// agentBuilder builds a fresh instance of a registry agent by ID.
+type agentBuilder func(id string) (agent.Agent, error)
+
+func buildAgent(cfg AgentConfig, build agentBuilder, saver graph.CheckpointSaver) (agent.Agent, error) {
 	llm, genCfg, err := appmodel.NewModelFromConfig(cfg.Model, cfg.Stream)
 	if err != nil {
 		return nil, fmt.Errorf("build model: %w", err)
 	}
 
-	tools, err := buildTools(cfg)
+	tools, err := buildTools(cfg.Tools, build)
 	if err != nil {
 		return nil, fmt.Errorf("build tools: %w", err)
 	}
 
 	switch cfg.Type {
 	case AgentTypeSingle:
-		return buildSingleAgent(cfg, llm, genCfg, tools)
+		subs := make([]agent.Agent, 0, len(cfg.SubAgents))
+		for _, id := range cfg.SubAgents {
+			sub, err := build(id)
+			if err != nil {
+				return nil, fmt.Errorf("sub-agent %q: %w", id, err)
+			}
+			subs = append(subs, sub)
+		}
+		return buildSingleAgent(cfg, llm, genCfg, tools, subs)
 	case AgentTypeMultiChain:
-		return buildMultiChainAgent(cfg, llm, genCfg, tools)
+		return buildMultiAgent(cfg, llm, genCfg, tools, build)
 	case AgentTypeGraph:
-		return buildGraphAgent(cfg, llm, tools)
+		return buildGraphAgent(cfg, llm, genCfg, tools, saver)
 	default:
 		return nil, fmt.Errorf("unsupported agent type: %s", cfg.Type)
 	}
 }
 
-func buildSingleAgent(cfg AgentConfig, llmModel model.Model, genCfg model.GenerationConfig, tools []tool.Tool) (agent.Agent, error) {
-	agt := llmagent.New(
-		cfg.ID,
+// buildSingleAgent builds an LLM agent. With subs it also gets the
+// transfer_to_agent tool to hand the conversation to one of them.
+func buildSingleAgent(cfg AgentConfig, llmModel model.Model, genCfg model.GenerationConfig, tools []tool.Tool, subs []agent.Agent) (agent.Agent, error) {
+	opts := []llmagent.Option{
 		llmagent.WithModel(llmModel),
 		llmagent.WithDescription(cfg.Description),
 		llmagent.WithInstruction(cfg.Instruction),
 		llmagent.WithGenerationConfig(genCfg),
 		llmagent.WithTools(tools),
-	)
-	return agt, nil
+	}
+	if len(subs) > 0 {
+		opts = append(opts, llmagent.WithSubAgents(subs))
+	}
+	return llmagent.New(cfg.ID, opts...), nil
 }
 
-func buildMultiChainAgent(
+func buildMultiAgent(
 	cfg AgentConfig,
 	llmModel model.Model,
 	genCfg model.GenerationConfig,
 	tools []tool.Tool,
+	build agentBuilder,
 ) (agent.Agent, error) {
-	if cfg.Multi == nil || strings.ToLower(cfg.Multi.Mode) != "chain" {
-		return nil, fmt.Errorf("multi-agent config must have mode=chain")
+	if cfg.Multi == nil {
+		return nil, fmt.Errorf("multi config is required for type=%s", cfg.Type)
 	}
 	if len(cfg.Multi.Agents) == 0 {
-		return nil, fmt.Errorf("multi-agent chain must define at least one sub-agent")
+		return nil, fmt.Errorf("multi-agent flow must define at least one sub-agent")
 	}
 
 	// hier de subagents uit JSON bouwen
 	subs := make([]agent.Agent, 0, len(cfg.Multi.Agents))
 	for _, subCfg := range cfg.Multi.Agents {
+		subModel, subGen, subTools, err := resolveSubAgent(cfg, subCfg, llmModel, genCfg, tools, build)
+		if err != nil {
+			return nil, fmt.Errorf("sub-agent %q: %w", subCfg.ID, err)
+		}
 		subAgent := llmagent.New(
 			subCfg.ID,
-			llmagent.WithModel(llmModel),
+			llmagent.WithModel(subModel),
 			llmagent.WithDescription(subCfg.Description),
 			llmagent.WithInstruction(subCfg.Instruction),
-			llmagent.WithGenerationConfig(genCfg),
-			llmagent.WithTools(tools),
+			llmagent.WithGenerationConfig(subGen),
+			llmagent.WithTools(subTools),
 		)
 		subs = append(subs, subAgent)
 	}
 
 	// BELANGRIJK: geen ... gebruiken, WithSubAgents verwacht []agent.Agent
-	chain := chainagent.New(
-		cfg.ID,
-		chainagent.WithSubAgents(subs),
-	)
-	return chain, nil
+	switch strings.ToLower(cfg.Multi.Mode) {
+	case MultiModeChain:
+		return chainagent.New(
+			cfg.ID,
+			chainagent.WithSubAgents(subs),
+		), nil
+	case MultiModeParallel:
+		return parallelagent.New(
+			cfg.ID,
+			parallelagent.WithSubAgents(subs),
+		), nil
+	case MultiModeCycle:
+		maxIter := cfg.Multi.MaxIterations
+		if maxIter <= 0 {
+			maxIter = defaultCycleMaxIterations
+		}
+		return cycleagent.New(
+			cfg.ID,
+			cycleagent.WithSubAgents(subs),
+			cycleagent.WithMaxIterations(maxIter),
+			cycleagent.WithEscalationFunc(stopPhraseEscalation(cfg.Multi.StopPhrase, cfg.Multi.stopAgent())),
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported multi-agent mode: %s", cfg.Multi.Mode)
+	}
+}
+
+// stopAgent returns the sub-agent whose answers are checked for StopPhrase.
+func (m *MultiConfig) stopAgent() string {
+	if m.StopAgent != "" || len(m.Agents) == 0 {
+		return m.StopAgent
+	}
+	return m.Agents[len(m.Agents)-1].ID
+}
+
+// stopPhraseEscalation stops a cycle on errors or, when phrase is set, on
+// the first final assistant message of the sub-agent author containing it.
+// Other sub-agents may quote the phrase without ending the cycle.
+func stopPhraseEscalation(phrase, author string) cycleagent.EscalationFunc {
+	return func(evt *event.Event) bool {
+		if evt.Error != nil {
+			return true
+		}
+		if phrase == "" || evt.Response == nil || evt.IsPartial || evt.Author != author {
+			return false
+		}
+		for _, c := range evt.Choices {
+			if c.Message.Role == model.RoleAssistant && strings.Contains(c.Message.Content, phrase) {
+				return true
+			}
+		}
+		return false
+	}
 }
 
-func buildGraphAgent(cfg AgentConfig, llmModel model.Model, _ []tool.Tool) (agent.Agent, error) {
+// resolveSubAgent returns the model, generation config and tools for a
+// sub-agent, falling back to the parent's values for anything it does not
+// override.
+func resolveSubAgent(
+	cfg AgentConfig,
+	subCfg SubAgentConfig,
+	llmModel model.Model,
+	genCfg model.GenerationConfig,
+	tools []tool.Tool,
+	build agentBuilder,
+) (model.Model, model.GenerationConfig, []tool.Tool, error) {
+	stream := cfg.Stream
+	if subCfg.Stream != nil {
+		stream = *subCfg.Stream
+	}
+
+	if subCfg.Model != nil {
+		m, gen, err := appmodel.NewModelFromConfig(subCfg.Model.Inherit(cfg.Model), stream)
+		if err != nil {
+			return nil, model.GenerationConfig{}, nil, fmt.Errorf("build model: %w", err)
+		}
+		llmModel, genCfg = m, gen
+	} else {
+		genCfg.Stream = stream
+	}
+	genCfg = subCfg.Generation.Apply(genCfg)
+
+	if subCfg.Tools != nil {
+		t, err := buildTools(subCfg.Tools, build)
+		if err != nil {
+			return nil, model.GenerationConfig{}, nil, fmt.Errorf("build tools: %w", err)
+		}
+		tools = t
+	}
+
+	return llmModel, genCfg, tools, nil
+}
+
+func buildGraphAgent(cfg AgentConfig, llmModel model.Model, genCfg model.GenerationConfig, tools []tool.Tool, saver graph.CheckpointSaver) (agent.Agent, error) {
 	if cfg.Graph == nil {
 		return nil, fmt.Errorf("graph config is required for type=graph")
 	}
@@ -161,16 +431,52 @@ func buildGraphAgent(cfg AgentConfig, llmModel model.Model, _ []tool.Tool) (agen
 	schema := graph.MessagesStateSchema()
 	sg := graph.NewStateGraph(schema)
 
+	// Tools nodes bind their tool set to the LLM node they serve.
+	allTools := toolsByName(tools)
+	llmTools := make(map[string]map[string]tool.Tool)
+	toolLoops := make(map[string]bool)
+	for _, node := range cfg.Graph.Nodes {
+		if node.Type != GraphNodeTypeTools {
+			continue
+		}
+		selected, err := selectTools(allTools, node.Tools)
+		if err != nil {
+			return nil, fmt.Errorf("graph node %q: %w", node.ID, err)
+		}
+		llmTools[node.LLMNode] = selected
+		toolLoops[node.LLMNode] = true
+	}
+
+	interrupts := make(map[string]bool, len(cfg.Graph.InterruptBefore))
+	for _, id := range cfg.Graph.InterruptBefore {
+		interrupts[id] = true
+	}
+	addNode := func(id string, fn graph.NodeFunc, opts ...graph.Option) {
+		if interrupts[id] {
+			fn = interruptBeforeFunc(id, fn)
+		}
+		sg.AddNode(id, fn, opts...)
+	}
+
 	for _, node := range cfg.Graph.Nodes {
 		switch node.Type {
-		case "entry":
+		case GraphNodeTypeEntry:
 			// Simple passthrough node.
-			sg.AddNode(node.ID, func(ctx context.Context, s graph.State) (any, error) {
+			addNode(node.ID, func(ctx context.Context, s graph.State) (any, error) {
 				return graph.State{}, nil
 			})
-		case "llm":
-
-			sg.AddLLMNode(node.ID, llmModel, node.Instruction, nil)
+		case GraphNodeTypeLLM:
+			addNode(node.ID, graph.NewLLMNodeFunc(llmModel, node.Instruction, llmTools[node.ID],
+				graph.WithLLMNodeID(node.ID),
+				graph.WithLLMGenerationConfig(node.Generation.Apply(genCfg))),
+				graph.WithNodeType(graph.NodeTypeLLM))
+		case GraphNodeTypeRouter:
+			addNode(node.ID, routerNodeFunc(llmModel, node.Generation.Apply(genCfg), node.Instruction, node.Labels, node.OutputKey))
+		case GraphNodeTypeTools:
+			addNode(node.ID, graph.NewToolsNodeFunc(llmTools[node.LLMNode]),
+				graph.WithNodeType(graph.NodeTypeTool))
+		case GraphNodeTypeTransform:
+			addNode(node.ID, transformNodeFunc(node.Set, node.Copy))
 		default:
 			return nil, fmt.Errorf("unsupported graph node type: %s", node.Type)
 		}
@@ -180,19 +486,48 @@ func buildGraphAgent(cfg AgentConfig, llmModel model.Model, _ []tool.Tool) (agen
 		sg.AddEdge(edge.From, edge.To)
 	}
 
-	sg.SetEntryPoint(cfg.Graph.Entry).SetFinishPoint(cfg.Graph.Finish)
+	for _, ce := range cfg.Graph.ConditionalEdges {
+		if err := addConditionalEdge(sg, llmModel, genCfg, ce); err != nil {
+			return nil, err
+		}
+	}
+
+	// LLM -> tools while the model emits tool calls, tools -> LLM to
+	// continue, and on to Next (or the graph end) once it answers.
+	for _, node := range cfg.Graph.Nodes {
+		if node.Type != GraphNodeTypeTools {
+			continue
+		}
+		next := node.Next
+		if next == "" {
+			next = graph.End
+		}
+		sg.AddToolsConditionalEdges(node.LLMNode, node.ID, next)
+		sg.AddEdge(node.ID, node.LLMNode)
+	}
+
+	sg.SetEntryPoint(cfg.Graph.Entry)
+	for _, finish := range cfg.Graph.finishNodes() {
+		if toolLoops[finish] {
+			// Finishing is handled by the tools conditional edge.
+			continue
+		}
+		sg.SetFinishPoint(finish)
+	}
 
 	compiled, err := sg.Compile()
 	if err != nil {
 		return nil, fmt.Errorf("compile graph: %w", err)
 	}
 
-	ga, err := graphagent.New(
-		cfg.ID,
-		compiled,
+	opts := []graphagent.Option{
 		graphagent.WithDescription(cfg.Description),
 		graphagent.WithInitialState(graph.State{}),
-	)
+	}
+	if saver != nil {
+		opts = append(opts, graphagent.WithCheckpointSaver(saver))
+	}
+	ga, err := graphagent.New(cfg.ID, compiled, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("new graph agent: %w", err)
 	}
@@ -0,0 +1,135 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ReloadResult lists the agent IDs that changed during a Reload.
+type ReloadResult struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// Empty reports whether the reload did not change anything.
+func (r ReloadResult) Empty() bool {
+	return len(r.Added) == 0 && len(r.Changed) == 0 && len(r.Removed) == 0
+}
+
+// String formats the result for logging.
+func (r ReloadResult) String() string {
+	return fmt.Sprintf("added=%v changed=%v removed=%v", r.Added, r.Changed, r.Removed)
+}
+
+// Reload re-reads the config directory and atomically swaps in the new
+// config set. If any file fails to load, the current set is kept and the
+// error is returned.
+func (r *Registry) Reload() (ReloadResult, error) {
+	fp, _ := dirFingerprint(r.dir)
+	configs, err := loadConfigs(r.dir)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := diffConfigs(r.configs, configs)
+	r.configs = configs
+	r.fingerprint = fp
+	return res, nil
+}
+
+// Watch polls the config directory every interval and reloads the registry
+// when a *.json file is added, removed or modified since the configs were
+// last loaded. It blocks until ctx is cancelled.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	r.mu.RLock()
+	last := r.fingerprint
+	r.mu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fp, err := dirFingerprint(r.dir)
+		if err != nil {
+			log.Printf("agent config watch: %v", err)
+			continue
+		}
+		if fp == last {
+			continue
+		}
+		last = fp
+
+		res, err := r.Reload()
+		if err != nil {
+			log.Printf("agent config reload failed, keeping previous configs: %v", err)
+			continue
+		}
+		if !res.Empty() {
+			log.Printf("agent configs reloaded: %s", res)
+		}
+	}
+}
+
+// dirFingerprint summarizes name, size and mtime of all *.json files so
+// that Watch can cheaply detect changes.
+func dirFingerprint(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("read config dir: %w", err)
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", fmt.Errorf("stat %s: %w", e.Name(), err)
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+func diffConfigs(oldSet, newSet map[string]AgentConfig) ReloadResult {
+	var res ReloadResult
+	for id, cfg := range newSet {
+		prev, ok := oldSet[id]
+		switch {
+		case !ok:
+			res.Added = append(res.Added, id)
+		case !reflect.DeepEqual(prev, cfg):
+			res.Changed = append(res.Changed, id)
+		}
+	}
+	for id := range oldSet {
+		if _, ok := newSet[id]; !ok {
+			res.Removed = append(res.Removed, id)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Changed)
+	sort.Strings(res.Removed)
+	return res
+}
@@ -0,0 +1,175 @@
+package agents
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testModel is a model config that passes validation without network access.
+const testModel = `{"provider":"openai","model":"m","base_url":"http://127.0.0.1:1","api_key_env":"env:TEST_AGENT_KEY"}`
+
+// singleAgent returns a single agent config with extra fields appended.
+func singleAgent(id, extra string) string {
+	return fmt.Sprintf(`{"id":%q,"type":"single","model":%s%s}`, id, testModel, extra)
+}
+
+// writeConfigs writes files (name without .json -> content) into dir, or
+// into a new temporary directory when dir is empty, and returns the dir.
+func writeConfigs(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	t.Setenv("TEST_AGENT_KEY", "test-key")
+	if dir == "" {
+		dir = t.TempDir()
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// agentIDs returns the sorted agent IDs of reg.
+func agentIDs(reg *Registry) string {
+	ids := reg.ListAgentIDs()
+	sort.Strings(ids)
+	return fmt.Sprint(ids)
+}
+
+func TestReload(t *testing.T) {
+	dir := writeConfigs(t, "", map[string]string{
+		"a": singleAgent("a", ""),
+		"b": singleAgent("b", ""),
+		"c": singleAgent("c", ""),
+	})
+	reg, err := LoadRegistry(dir)
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		write   map[string]string
+		remove  []string
+		want    string
+		wantErr string
+		wantIDs string
+	}{
+		{
+			name: "unchanged",
+			want: "added=[] changed=[] removed=[]", wantIDs: "[a b c]",
+		},
+		{
+			name:   "add, change and remove",
+			write:  map[string]string{"b": singleAgent("b", `,"instruction":"new"`), "d": singleAgent("d", "")},
+			remove: []string{"c"},
+			want:   "added=[d] changed=[b] removed=[c]", wantIDs: "[a b d]",
+		},
+		{
+			name:    "invalid file keeps the current set",
+			write:   map[string]string{"e": `{"id":"e","type":"single"`},
+			wantErr: "e.json", wantIDs: "[a b d]",
+		},
+		{
+			name:    "unknown reference keeps the current set",
+			write:   map[string]string{"e": singleAgent("e", `,"sub_agents":["missing"]`)},
+			wantErr: `unknown agent "missing"`, wantIDs: "[a b d]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigs(t, dir, tt.write)
+			for _, name := range tt.remove {
+				if err := os.Remove(filepath.Join(dir, name+".json")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			res, err := reg.Reload()
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Reload error = %v, want it to mention %s", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("Reload: %v", err)
+			case res.String() != tt.want:
+				t.Errorf("Reload = %s, want %s", res, tt.want)
+			}
+			if got := agentIDs(reg); got != tt.wantIDs {
+				t.Errorf("agents = %s, want %s", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := writeConfigs(t, "", map[string]string{"a": singleAgent("a", "")})
+	reg, err := LoadRegistry(dir)
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		reg.Watch(ctx, 5*time.Millisecond)
+		close(stopped)
+	}()
+
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for agentIDs(reg) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("agents = %s, want %s", agentIDs(reg), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	writeConfigs(t, dir, map[string]string{"b": singleAgent("b", "")})
+	waitFor("[a b]")
+
+	// A broken file is not picked up; fixing it is.
+	writeConfigs(t, dir, map[string]string{"c": `{"id":"c"`})
+	time.Sleep(50 * time.Millisecond)
+	waitFor("[a b]")
+	writeConfigs(t, dir, map[string]string{"c": singleAgent("c", "")})
+	waitFor("[a b c]")
+
+	// Files other than *.json are ignored.
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.json")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("[b c]")
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after cancel")
+	}
+}
+
+func TestWatchDisabled(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		(&Registry{dir: t.TempDir()}).Watch(context.Background(), 0)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch with a zero interval did not return")
+	}
+}
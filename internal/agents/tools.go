@@ -2,9 +2,16 @@ package agents
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"helixrun/internal/approval"
 
 	"trpc.group/trpc-go/trpc-agent-go/tool"
+	agenttool "trpc.group/trpc-go/trpc-agent-go/tool/agent"
 	"trpc.group/trpc-go/trpc-agent-go/tool/function"
 )
 
@@ -17,7 +24,10 @@ type CalculatorArgs struct {
 }
 
 // calculatorTool returns a robust arithmetic tool.
-func calculatorTool() tool.Tool {
+func calculatorTool(name string) tool.Tool {
+	if name == "" {
+		name = ToolTypeCalculator
+	}
 	// 2. Gebruik de getypeerde struct in de functie-signatuur
 	fn := func(ctx context.Context, args CalculatorArgs) (map[string]any, error) {
 		switch args.Operation {
@@ -40,21 +50,130 @@ func calculatorTool() tool.Tool {
 	// 3. Registreer de tool met een duidelijke beschrijving
 	return function.NewFunctionTool(
 		fn,
-		function.WithName("calculator"),
+		function.WithName(name),
 		function.WithDescription("Perform basic arithmetic operations. Use this tool for each step of a calculation."),
 	)
 }
 
-// buildTools instantiates tools defined in the agent config.
-func buildTools(cfg AgentConfig) ([]tool.Tool, error) {
+// defaultApprovalTimeout is how long a call waits for approval before it is
+// denied.
+const defaultApprovalTimeout = 5 * time.Minute
+
+// approvalTool asks the approval gate of the run before every call. Denied
+// calls return a "denied" result so the model can react to it.
+type approvalTool struct {
+	tool.CallableTool
+	timeout time.Duration
+}
+
+func (t *approvalTool) Call(ctx context.Context, args []byte) (any, error) {
+	gate := approval.GateFromContext(ctx)
+	if gate == nil {
+		return deniedResult("no approver available for this run"), nil
+	}
+	id, _ := tool.ToolCallIDFromContext(ctx)
+	if id == "" {
+		// Graph tools nodes do not pass the tool call ID.
+		id = uuid.NewString()
+	}
+	if len(args) == 0 {
+		args = []byte("{}")
+	}
+
+	d := gate.Await(ctx, approval.Request{
+		ToolCallID: id,
+		ToolName:   t.Declaration().Name,
+		Arguments:  json.RawMessage(args),
+	}, t.timeout, t.checkArgs)
+	switch d.Decision {
+	case approval.DecisionApprove:
+		return t.CallableTool.Call(ctx, args)
+	case approval.DecisionEdit:
+		return t.CallableTool.Call(ctx, d.Arguments)
+	default:
+		reason := d.Reason
+		if reason == "" {
+			reason = "denied by the user"
+		}
+		return deniedResult(reason), nil
+	}
+}
+
+// checkArgs validates edited arguments against the tool's input schema.
+func (t *approvalTool) checkArgs(args json.RawMessage) error {
+	var m map[string]any
+	if err := json.Unmarshal(args, &m); err != nil {
+		return fmt.Errorf("arguments must be a JSON object: %w", err)
+	}
+	var schema *tool.Schema
+	if decl := t.Declaration(); decl != nil {
+		schema = decl.InputSchema
+	}
+	return checkArgs(schema, m)
+}
+
+func deniedResult(reason string) map[string]any {
+	return map[string]any{"status": "denied", "reason": reason}
+}
+
+// agentTool calls a registry agent. The agent runs in the session of the
+// calling run; its events are streamed with the run's events and its final
+// answer is the tool result.
+type agentTool struct {
+	*agenttool.Tool
+	name string
+}
+
+func newAgentTool(tc ToolConfig, build agentBuilder) (tool.Tool, error) {
+	agt, err := build(tc.Agent)
+	if err != nil {
+		return nil, fmt.Errorf("agent tool %s: %w", tc.toolName(), err)
+	}
+	return &agentTool{Tool: agenttool.NewTool(agt, agenttool.WithStreamInner(true)), name: tc.toolName()}, nil
+}
+
+// Declaration names the tool after the config instead of the agent.
+func (t *agentTool) Declaration() *tool.Declaration {
+	decl := t.Tool.Declaration()
+	decl.Name = t.name
+	return decl
+}
+
+// buildTools instantiates the configured tools. build resolves the agents
+// of "agent" tools.
+func buildTools(configs []ToolConfig, build agentBuilder) ([]tool.Tool, error) {
 	var tools []tool.Tool
-	for _, tc := range cfg.Tools {
+	for _, tc := range configs {
+		var t tool.Tool
 		switch tc.Type {
-		case "calculator":
-			tools = append(tools, calculatorTool())
+		case ToolTypeCalculator:
+			t = calculatorTool(tc.Name)
+		case ToolTypeAgent:
+			var err error
+			if t, err = newAgentTool(tc, build); err != nil {
+				return nil, err
+			}
+		case ToolTypeHTTP:
+			if tc.HTTP == nil {
+				return nil, fmt.Errorf("tool %s: http config is required", tc.toolName())
+			}
+			t = newHTTPTool(tc.Name, *tc.HTTP)
 		default:
 			return nil, fmt.Errorf("unsupported tool type: %s", tc.Type)
 		}
+
+		if tc.RequiresApproval {
+			callable, ok := t.(tool.CallableTool)
+			if !ok {
+				return nil, fmt.Errorf("tool %s does not support requires_approval", tc.toolName())
+			}
+			timeout := time.Duration(tc.ApprovalTimeout)
+			if timeout == 0 {
+				timeout = defaultApprovalTimeout
+			}
+			t = &approvalTool{CallableTool: callable, timeout: timeout}
+		}
+		tools = append(tools, t)
 	}
 	return tools, nil
 }
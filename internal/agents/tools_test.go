@@ -0,0 +1,86 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"helixrun/internal/approval"
+
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+)
+
+func TestApprovalToolValidatesEdits(t *testing.T) {
+	reqs := make(chan approval.Request, 4)
+	gate := approval.NewGate(func(r approval.Request) { reqs <- r })
+	ctx := approval.ContextWithGate(context.Background(), gate)
+	ctx = context.WithValue(ctx, tool.ContextKeyToolCallID{}, "call-1")
+	at := &approvalTool{CallableTool: calculatorTool("").(tool.CallableTool), timeout: time.Minute}
+
+	type result struct {
+		out any
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		out, err := at.Call(ctx, []byte(`{"operation":"add","a":1,"b":2}`))
+		done <- result{out, err}
+	}()
+	<-reqs
+
+	for _, args := range []string{
+		`{"operation":"add","a":"1","b":2}`,
+		`{"operation":"add","a":1}`,
+		`[1,2]`,
+		`null`,
+	} {
+		err := gate.Resolve("call-1", approval.Decision{Decision: approval.DecisionEdit, Arguments: json.RawMessage(args)})
+		if !errors.Is(err, approval.ErrInvalidDecision) {
+			t.Errorf("edit %s: error = %v, want ErrInvalidDecision", args, err)
+		}
+	}
+
+	edit := approval.Decision{Decision: approval.DecisionEdit, Arguments: json.RawMessage(`{"operation":"multiply","a":3,"b":4}`)}
+	if err := gate.Resolve("call-1", edit); err != nil {
+		t.Fatalf("valid edit: %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("Call: %v", res.err)
+	}
+	if out, _ := res.out.(map[string]any); out["result"] != 12.0 {
+		t.Errorf("Call = %v, want the edited call's result 12", res.out)
+	}
+}
+
+func TestRequiresApproval(t *testing.T) {
+	const approvalTools = `,"tools":[{"type":"calculator","requires_approval":true}]`
+	dir := writeConfigs(t, "", map[string]string{
+		"plain":    singleAgent("plain", `,"tools":[{"type":"calculator"}]`),
+		"approval": singleAgent("approval", approvalTools),
+		"parent":   singleAgent("parent", `,"sub_agents":["approval"]`),
+		"caller":   singleAgent("caller", `,"tools":[{"type":"agent","agent":"parent"}]`),
+		"multi": `{"id":"multi","type":"multi_chain","model":` + testModel + `,"multi":{"mode":"chain","agents":[
+			{"id":"one","instruction":"a"},
+			{"id":"two","instruction":"b"` + approvalTools + `}]}}`,
+	})
+	reg, err := LoadRegistry(dir)
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+
+	for id, want := range map[string]bool{
+		"plain":    false,
+		"approval": true,
+		"parent":   true,
+		"caller":   true,
+		"multi":    true,
+		"missing":  false,
+	} {
+		if got := reg.RequiresApproval(id); got != want {
+			t.Errorf("RequiresApproval(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
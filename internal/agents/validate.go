@@ -0,0 +1,591 @@
+package agents
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"helixrun/internal/model"
+)
+
+// Validate checks an AgentConfig for problems that would otherwise only
+// surface when the agent is built at request time. All problems are
+// reported together.
+func (c AgentConfig) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.ID == "" {
+		add("id is required")
+	}
+	if err := c.Model.Validate(); err != nil {
+		add("model: %w", err)
+	}
+
+	for i, tc := range c.Tools {
+		if err := tc.validate(); err != nil {
+			add("tools[%d]: %w", i, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(c.SubAgents))
+	for i, id := range c.SubAgents {
+		switch {
+		case id == "":
+			add("sub_agents[%d]: agent ID is required", i)
+		case id == c.ID:
+			add("sub_agents[%d]: agent cannot transfer to itself", i)
+		case seen[id]:
+			add("sub_agents[%d]: duplicate agent %q", i, id)
+		}
+		seen[id] = true
+	}
+
+	switch c.Type {
+	case AgentTypeSingle:
+	case AgentTypeMultiChain:
+		if c.Multi == nil {
+			add("multi is required for type=%s", c.Type)
+		} else if err := c.Multi.validate(c.Model); err != nil {
+			add("multi: %w", err)
+		}
+	case AgentTypeGraph:
+		if c.Graph == nil {
+			add("graph is required for type=%s", c.Type)
+		} else if err := c.Graph.validate(c.toolNames(), c.Model.Providers()); err != nil {
+			add("graph: %w", err)
+		}
+	case "":
+		add("type is required")
+	default:
+		add("unsupported agent type %q (want %s, %s or %s)",
+			c.Type, AgentTypeSingle, AgentTypeMultiChain, AgentTypeGraph)
+	}
+	if len(c.SubAgents) > 0 && c.Type != AgentTypeSingle {
+		add("sub_agents is only valid for type=%s", AgentTypeSingle)
+	}
+
+	return errors.Join(errs...)
+}
+
+// literalKeyPaths returns the JSON paths of model configs whose api_key_env,
+// and of http tool headers whose {secret:...} placeholders, hold a literal
+// key instead of a secret reference.
+func (c AgentConfig) literalKeyPaths() []string {
+	var paths []string
+	check := func(path string, mc model.Config) {
+		if model.IsLiteralSecret(mc.APIKeyEnv) {
+			paths = append(paths, path+".api_key_env")
+		}
+		for i, fb := range mc.Fallbacks {
+			if model.IsLiteralSecret(fb.APIKeyEnv) {
+				paths = append(paths, fmt.Sprintf("%s.fallbacks[%d].api_key_env", path, i))
+			}
+		}
+	}
+	checkTools := func(path string, tools []ToolConfig) {
+		for i, tc := range tools {
+			if tc.HTTP == nil {
+				continue
+			}
+			for name, value := range tc.HTTP.Headers {
+				for _, m := range secretPlaceholder.FindAllStringSubmatch(value, -1) {
+					if model.IsLiteralSecret(m[1]) {
+						paths = append(paths, fmt.Sprintf("%stools[%d].http.headers.%s", path, i, name))
+					}
+				}
+			}
+		}
+	}
+	check("model", c.Model)
+	checkTools("", c.Tools)
+	if c.Multi != nil {
+		for i, sub := range c.Multi.Agents {
+			if sub.Model != nil {
+				check(fmt.Sprintf("multi.agents[%d].model", i), *sub.Model)
+			}
+			checkTools(fmt.Sprintf("multi.agents[%d].", i), sub.Tools)
+		}
+	}
+	return paths
+}
+
+// toolNames returns the declared names of the agent's tools.
+func (c AgentConfig) toolNames() map[string]bool {
+	names := make(map[string]bool, len(c.Tools))
+	for _, tc := range c.Tools {
+		names[tc.toolName()] = true
+	}
+	return names
+}
+
+// toolName is the name the tool is declared with towards the model.
+func (tc ToolConfig) toolName() string {
+	if tc.Name != "" {
+		return tc.Name
+	}
+	if tc.Type == ToolTypeAgent && tc.Agent != "" {
+		return tc.Agent
+	}
+	return tc.Type
+}
+
+func (tc ToolConfig) validate() error {
+	if tc.ApprovalTimeout < 0 {
+		return fmt.Errorf("approval_timeout must not be negative")
+	}
+	if tc.ApprovalTimeout != 0 && !tc.RequiresApproval {
+		return fmt.Errorf("approval_timeout requires requires_approval")
+	}
+	if tc.Agent != "" && tc.Type != ToolTypeAgent {
+		return fmt.Errorf("agent is only valid for type=%s", ToolTypeAgent)
+	}
+	if tc.HTTP != nil && tc.Type != ToolTypeHTTP {
+		return fmt.Errorf("http is only valid for type=%s", ToolTypeHTTP)
+	}
+	switch tc.Type {
+	case ToolTypeCalculator:
+		return nil
+	case ToolTypeAgent:
+		if tc.Agent == "" {
+			return fmt.Errorf("agent is required for type=%s", ToolTypeAgent)
+		}
+		return nil
+	case ToolTypeHTTP:
+		if tc.Name == "" {
+			return fmt.Errorf("name is required for type=%s", ToolTypeHTTP)
+		}
+		if tc.HTTP == nil {
+			return fmt.Errorf("http is required for type=%s", ToolTypeHTTP)
+		}
+		if err := tc.HTTP.validate(); err != nil {
+			return fmt.Errorf("http: %w", err)
+		}
+		return nil
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unsupported tool type %q", tc.Type)
+	}
+}
+
+// method returns the upper-cased request method, GET by default.
+func (h *HTTPToolConfig) method() string {
+	if h.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(h.Method)
+}
+
+func (h *HTTPToolConfig) validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch h.method() {
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		add("unsupported method %q (want GET, POST, PUT, PATCH or DELETE)", h.Method)
+	}
+	if h.Timeout < 0 {
+		add("timeout must not be negative")
+	}
+	if len(h.AllowedHosts) == 0 {
+		add("allowed_hosts is required")
+	}
+
+	if h.Parameters != nil && h.Parameters.Type != "object" {
+		add("parameters: type must be object")
+	}
+	if h.URL == "" {
+		add("url is required")
+	} else if u, err := url.Parse(h.URL); err != nil {
+		add("url: %w", unwrapURLError(err))
+	} else {
+		switch {
+		case u.Scheme != "http" && u.Scheme != "https":
+			add("url: scheme must be http or https")
+		case u.Host == "":
+			add("url: host is required")
+		case len(h.AllowedHosts) > 0 && !hostAllowed(h.AllowedHosts, u):
+			add("url: host %s is not in allowed_hosts", u.Host)
+		}
+		for _, m := range urlPlaceholder.FindAllStringSubmatch(h.URL, -1) {
+			if h.Parameters == nil || h.Parameters.Properties[m[1]] == nil {
+				add("url: placeholder {%s} is not a property in parameters", m[1])
+			}
+		}
+	}
+
+	for name, value := range h.Headers {
+		if name == "" || strings.ContainsAny(name, " \t:\r\n") {
+			add("headers: invalid header name %q", name)
+		}
+		for _, m := range secretPlaceholder.FindAllStringSubmatch(value, -1) {
+			if strings.HasPrefix(m[1], model.SecretSchemePool+":") {
+				add("headers.%s: pool: references are only valid for models", name)
+			} else if err := model.ValidateSecretRef(m[1]); err != nil {
+				add("headers.%s: %w", name, err)
+			}
+		}
+	}
+	for field, path := range h.Response {
+		if path == "" {
+			add("response.%s: path is required", field)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *MultiConfig) validate(parentModel model.Config) error {
+	var errs []error
+	mode := strings.ToLower(m.Mode)
+	switch mode {
+	case MultiModeChain, MultiModeParallel, MultiModeCycle:
+	default:
+		errs = append(errs, fmt.Errorf("unsupported mode %q (want %s, %s or %s)",
+			m.Mode, MultiModeChain, MultiModeParallel, MultiModeCycle))
+	}
+	if m.MaxIterations < 0 {
+		errs = append(errs, fmt.Errorf("max_iterations must be >= 0"))
+	}
+	if mode != MultiModeCycle && (m.MaxIterations != 0 || m.StopPhrase != "" || m.StopAgent != "") {
+		errs = append(errs, fmt.Errorf("max_iterations, stop_phrase and stop_agent are only valid for mode=%s", MultiModeCycle))
+	}
+	if m.StopAgent != "" && m.StopPhrase == "" {
+		errs = append(errs, fmt.Errorf("stop_agent requires stop_phrase"))
+	}
+	if m.StopAgent != "" && !slices.ContainsFunc(m.Agents, func(sub SubAgentConfig) bool { return sub.ID == m.StopAgent }) {
+		errs = append(errs, fmt.Errorf("stop_agent: unknown sub-agent %q", m.StopAgent))
+	}
+	if len(m.Agents) == 0 {
+		errs = append(errs, fmt.Errorf("at least one sub-agent is required"))
+	}
+	seen := make(map[string]bool, len(m.Agents))
+	for i, sub := range m.Agents {
+		switch {
+		case sub.ID == "":
+			errs = append(errs, fmt.Errorf("agents[%d]: id is required", i))
+		case seen[sub.ID]:
+			errs = append(errs, fmt.Errorf("agents[%d]: duplicate sub-agent id %q", i, sub.ID))
+		}
+		seen[sub.ID] = true
+
+		subModel := parentModel
+		if sub.Model != nil {
+			subModel = sub.Model.Inherit(parentModel)
+			if err := subModel.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("agents[%d].model: %w", i, err))
+			}
+		}
+		if err := sub.Generation.ValidateFor(subModel.Providers()...); err != nil {
+			errs = append(errs, fmt.Errorf("agents[%d].generation: %w", i, err))
+		}
+		for j, tc := range sub.Tools {
+			if err := tc.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("agents[%d].tools[%d]: %w", i, j, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (g *GraphConfig) validate(toolNames map[string]bool, providers []string) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(g.Nodes) == 0 {
+		add("at least one node is required")
+	}
+	nodes := make(map[string]bool, len(g.Nodes))
+	nodeTypes := make(map[string]string, len(g.Nodes))
+	for _, n := range g.Nodes {
+		nodeTypes[n.ID] = n.Type
+	}
+	for i, n := range g.Nodes {
+		switch {
+		case n.ID == "":
+			add("nodes[%d]: id is required", i)
+		case nodes[n.ID]:
+			add("nodes[%d]: duplicate node id %q", i, n.ID)
+		}
+		nodes[n.ID] = true
+
+		if err := n.Generation.ValidateFor(providers...); err != nil {
+			add("nodes[%d].generation: %w", i, err)
+		}
+
+		switch n.Type {
+		case GraphNodeTypeEntry, GraphNodeTypeLLM:
+		case GraphNodeTypeRouter:
+			if len(n.Labels) == 0 {
+				add("nodes[%d]: router node %q needs at least one label", i, n.ID)
+			}
+		case GraphNodeTypeTools:
+			if err := g.validateToolsNode(n, nodeTypes, toolNames); err != nil {
+				add("nodes[%d]: %w", i, err)
+			}
+		case GraphNodeTypeTransform:
+			if len(n.Set) == 0 && len(n.Copy) == 0 {
+				add("nodes[%d]: transform node %q needs set or copy", i, n.ID)
+			}
+			for k := range n.Set {
+				if isReservedStateKey(k) {
+					add("nodes[%d]: transform node %q may not set reserved key %q", i, n.ID, k)
+				}
+			}
+			for k := range n.Copy {
+				if isReservedStateKey(k) {
+					add("nodes[%d]: transform node %q may not copy into reserved key %q", i, n.ID, k)
+				}
+			}
+		default:
+			add("nodes[%d]: unsupported node type %q", i, n.Type)
+		}
+	}
+
+	for i, e := range g.Edges {
+		if !nodes[e.From] {
+			add("edges[%d]: unknown from node %q", i, e.From)
+		}
+		if !nodes[e.To] {
+			add("edges[%d]: unknown to node %q", i, e.To)
+		}
+	}
+
+	for i, ce := range g.ConditionalEdges {
+		if err := ce.validate(nodes); err != nil {
+			add("conditional_edges[%d]: %w", i, err)
+		}
+	}
+
+	if g.Entry == "" {
+		add("entry is required")
+	} else if !nodes[g.Entry] {
+		add("entry references unknown node %q", g.Entry)
+	}
+	finishes := g.finishNodes()
+	if len(finishes) == 0 {
+		add("finish or finish_nodes is required")
+	}
+	for _, f := range finishes {
+		if !nodes[f] {
+			add("finish references unknown node %q", f)
+		}
+	}
+	for i, id := range g.InterruptBefore {
+		if !nodes[id] {
+			add("interrupt_before[%d]: unknown node %q", i, id)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateToolsNode checks that a tools node is attached to an LLM node
+// whose outgoing routing it fully owns.
+func (g *GraphConfig) validateToolsNode(n GraphNodeConfig, nodeTypes map[string]string, toolNames map[string]bool) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(toolNames) == 0 {
+		add("tools node %q requires the agent to define tools", n.ID)
+	}
+	for _, name := range n.Tools {
+		if !toolNames[name] {
+			add("tools node %q references unknown tool %q", n.ID, name)
+		}
+	}
+
+	switch {
+	case n.LLMNode == "":
+		add("tools node %q: llm_node is required", n.ID)
+	case nodeTypes[n.LLMNode] != GraphNodeTypeLLM:
+		add("tools node %q: llm_node %q is not an llm node", n.ID, n.LLMNode)
+	}
+	for _, other := range g.Nodes {
+		if other.Type == GraphNodeTypeTools && other.ID != n.ID && other.LLMNode == n.LLMNode {
+			add("tools node %q: llm node %q already has tools node %q", n.ID, n.LLMNode, other.ID)
+		}
+	}
+	for _, e := range g.Edges {
+		if e.From == n.LLMNode || e.From == n.ID {
+			add("tools node %q: edge %s -> %s conflicts with the tools loop; use next instead", n.ID, e.From, e.To)
+		}
+	}
+	for _, ce := range g.ConditionalEdges {
+		if ce.From == n.LLMNode || ce.From == n.ID {
+			add("tools node %q: conditional edge from %q conflicts with the tools loop", n.ID, ce.From)
+		}
+	}
+
+	if n.Next != "" {
+		if _, ok := nodeTypes[n.Next]; !ok {
+			add("tools node %q: next references unknown node %q", n.ID, n.Next)
+		}
+	} else {
+		finishes := false
+		for _, f := range g.finishNodes() {
+			finishes = finishes || f == n.LLMNode
+		}
+		if !finishes {
+			add("tools node %q: next is required unless llm_node %q is a finish node", n.ID, n.LLMNode)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (ce GraphConditionalEdgeConfig) validate(nodes map[string]bool) error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if !nodes[ce.From] {
+		add("unknown from node %q", ce.From)
+	}
+	if len(ce.Routes) == 0 {
+		add("at least one route is required")
+	}
+	for _, key := range routeLabels(ce.Routes) {
+		if to := ce.Routes[key]; !nodes[to] {
+			add("route %q targets unknown node %q", key, to)
+		}
+	}
+	if ce.Default != "" && !nodes[ce.Default] {
+		add("default targets unknown node %q", ce.Default)
+	}
+
+	switch ce.Condition.Type {
+	case GraphConditionStateKey:
+		if ce.Condition.Key == "" {
+			add("condition.key is required for type=%s", ce.Condition.Type)
+		}
+	case GraphConditionLLM:
+	case GraphConditionExpression:
+		if _, err := parseStateExpr(ce.Condition.Expression); err != nil {
+			add("condition.expression: %w", err)
+		}
+	default:
+		add("unsupported condition type %q", ce.Condition.Type)
+	}
+
+	return errors.Join(errs...)
+}
+
+// finishNodes returns Finish followed by FinishNodes.
+func (g *GraphConfig) finishNodes() []string {
+	var out []string
+	if g.Finish != "" {
+		out = append(out, g.Finish)
+	}
+	return append(out, g.FinishNodes...)
+}
+
+// ValidateDir loads and validates every config in dir without building a
+// Registry. It is used by the validate command.
+func ValidateDir(dir string) ([]string, error) {
+	configs, err := loadConfigs(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(configs))
+	for id := range configs {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// agentRef is a reference from one registry agent to another.
+type agentRef struct {
+	path string // JSON path of the reference, e.g. "tools[0].agent"
+	to   string
+}
+
+// agentRefs returns the registry agents c calls as tools or may transfer to.
+func (c AgentConfig) agentRefs() []agentRef {
+	var refs []agentRef
+	tools := func(prefix string, configs []ToolConfig) {
+		for i, tc := range configs {
+			if tc.Type == ToolTypeAgent {
+				refs = append(refs, agentRef{path: fmt.Sprintf("%stools[%d].agent", prefix, i), to: tc.Agent})
+			}
+		}
+	}
+	tools("", c.Tools)
+	if c.Multi != nil {
+		for i, sub := range c.Multi.Agents {
+			tools(fmt.Sprintf("multi.agents[%d].", i), sub.Tools)
+		}
+	}
+	for i, id := range c.SubAgents {
+		refs = append(refs, agentRef{path: fmt.Sprintf("sub_agents[%d]", i), to: id})
+	}
+	return refs
+}
+
+// validateAgentRefs checks the references between agents of a config set:
+// every referenced agent must exist and no agent may reach itself, since
+// building it would never end.
+func validateAgentRefs(configs map[string]AgentConfig) error {
+	ids := make([]string, 0, len(configs))
+	for id := range configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs []error
+	for _, id := range ids {
+		for _, ref := range configs[id].agentRefs() {
+			if _, ok := configs[ref.to]; !ok {
+				errs = append(errs, fmt.Errorf("agent %q: %s: unknown agent %q", id, ref.path, ref.to))
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(configs))
+	var stack []string
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		stack = append(stack, id)
+		for _, ref := range configs[id].agentRefs() {
+			if _, ok := configs[ref.to]; !ok {
+				continue
+			}
+			switch state[ref.to] {
+			case unvisited:
+				visit(ref.to)
+			case visiting:
+				start := slices.Index(stack, ref.to)
+				cycle := append(slices.Clone(stack[start:]), ref.to)
+				errs = append(errs, fmt.Errorf("agent reference cycle: %s", strings.Join(cycle, " -> ")))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = done
+	}
+	for _, id := range ids {
+		if state[id] == unvisited {
+			visit(id)
+		}
+	}
+	return errors.Join(errs...)
+}
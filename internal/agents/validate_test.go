@@ -0,0 +1,255 @@
+package agents
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string // substrings of the error
+	}{
+		{
+			name:  "unknown field",
+			files: map[string]string{"a": singleAgent("a", `,"instructions":"typo"`)},
+			want:  []string{"a.json", `unknown field "instructions"`},
+		},
+		{
+			name:  "unknown nested field",
+			files: map[string]string{"a": `{"id":"a","type":"single","model":{"provider":"openai","model":"m","temprature":1}}`},
+			want:  []string{`unknown field "temprature"`},
+		},
+		{
+			name:  "trailing data",
+			files: map[string]string{"a": singleAgent("a", "") + `{}`},
+			want:  []string{"unexpected data after agent config"},
+		},
+		{
+			name: "duplicate ID",
+			files: map[string]string{
+				"a":     singleAgent("a", ""),
+				"other": singleAgent("a", ""),
+			},
+			want: []string{`other.json: duplicate agent ID "a" (already defined in`, "a.json)"},
+		},
+		{
+			name: "ID from the file name is a duplicate",
+			files: map[string]string{
+				"a": `{"type":"single","model":` + testModel + `}`,
+				"b": singleAgent("a", ""),
+			},
+			want: []string{`duplicate agent ID "a"`},
+		},
+		{
+			name:  "missing type",
+			files: map[string]string{"a": `{"id":"a","model":` + testModel + `}`},
+			want:  []string{`invalid agent "a": type is required`},
+		},
+		{
+			name:  "unsupported type",
+			files: map[string]string{"a": `{"id":"a","type":"swarm","model":` + testModel + `}`},
+			want:  []string{`unsupported agent type "swarm"`},
+		},
+		{
+			name:  "transfer to itself",
+			files: map[string]string{"a": singleAgent("a", `,"sub_agents":["a"]`)},
+			want:  []string{"sub_agents[0]: agent cannot transfer to itself"},
+		},
+		{
+			name: "duplicate sub-agent",
+			files: map[string]string{
+				"a": singleAgent("a", `,"sub_agents":["b","b"]`),
+				"b": singleAgent("b", ""),
+			},
+			want: []string{`sub_agents[1]: duplicate agent "b"`},
+		},
+		{
+			name:  "all files are reported",
+			files: map[string]string{"a": `{"id":"a"`, "b": `{"id":"b","type":"single","model":` + testModel + `,"x":1}`},
+			want:  []string{"a.json", "b.json"},
+		},
+		{
+			name:  "no configs",
+			files: map[string]string{},
+			want:  []string{"no agent configs found"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigs(t, "", tt.files)
+			_, err := loadConfigs(dir)
+			if err == nil {
+				t.Fatal("loadConfigs succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigsLiteralKeys(t *testing.T) {
+	const key = "sk-test-0123456789abcdef"
+	files := map[string]string{
+		"a": `{"id":"a","type":"single","model":{"provider":"openai","model":"m","api_key_env":"` + key + `"}}`,
+	}
+
+	t.Setenv(literalKeysEnv, "")
+	if _, err := loadConfigs(writeConfigs(t, "", files)); err != nil {
+		t.Fatalf("loadConfigs with literal keys allowed: %v", err)
+	}
+
+	t.Setenv(literalKeysEnv, "error")
+	_, err := loadConfigs(writeConfigs(t, "", files))
+	if err == nil || !strings.Contains(err.Error(), "literal API key in model.api_key_env") {
+		t.Fatalf("loadConfigs error = %v, want a literal key error", err)
+	}
+	if strings.Contains(err.Error(), key) {
+		t.Errorf("error %q contains the key", err)
+	}
+}
+
+func TestLoadConfigsDefaultsIDToFileName(t *testing.T) {
+	dir := writeConfigs(t, "", map[string]string{
+		"helper": `{"type":"single","model":` + testModel + `}`,
+	})
+	configs, err := loadConfigs(dir)
+	if err != nil {
+		t.Fatalf("loadConfigs: %v", err)
+	}
+	if _, ok := configs["helper"]; !ok {
+		t.Errorf("configs = %v, want agent helper", configs)
+	}
+}
+
+func TestAgentRefs(t *testing.T) {
+	cfg := AgentConfig{
+		Tools: []ToolConfig{{Type: ToolTypeCalculator}, {Type: ToolTypeAgent, Agent: "b"}},
+		Multi: &MultiConfig{Agents: []SubAgentConfig{
+			{ID: "one"},
+			{ID: "two", Tools: []ToolConfig{{Type: ToolTypeAgent, Agent: "c"}}},
+		}},
+		SubAgents: []string{"d", "e"},
+	}
+	var got []string
+	for _, ref := range cfg.agentRefs() {
+		got = append(got, ref.path+"="+ref.to)
+	}
+	want := "tools[1].agent=b multi.agents[1].tools[0].agent=c sub_agents[0]=d sub_agents[1]=e"
+	if strings.Join(got, " ") != want {
+		t.Errorf("agentRefs = %v, want %s", got, want)
+	}
+}
+
+func TestValidateAgentRefs(t *testing.T) {
+	callAgent := func(to string) []ToolConfig { return []ToolConfig{{Type: ToolTypeAgent, Agent: to}} }
+	tests := []struct {
+		name    string
+		configs map[string]AgentConfig
+		want    []string // substrings of the error, none for a valid set
+		cycles  int      // cycles the error reports
+	}{
+		{
+			name: "no references",
+			configs: map[string]AgentConfig{
+				"a": {}, "b": {Tools: []ToolConfig{{Type: ToolTypeCalculator}}},
+			},
+		},
+		{
+			name: "diamond",
+			configs: map[string]AgentConfig{
+				"a": {SubAgents: []string{"b", "c"}},
+				"b": {Tools: callAgent("d")},
+				"c": {SubAgents: []string{"d"}},
+				"d": {},
+			},
+		},
+		{
+			name:    "unknown agent",
+			configs: map[string]AgentConfig{"a": {Tools: callAgent("missing")}},
+			want:    []string{`agent "a": tools[0].agent: unknown agent "missing"`},
+		},
+		{
+			name:    "agent calls itself",
+			configs: map[string]AgentConfig{"a": {Tools: callAgent("a")}},
+			want:    []string{"agent reference cycle: a -> a"},
+			cycles:  1,
+		},
+		{
+			name: "transfer cycle",
+			configs: map[string]AgentConfig{
+				"a": {SubAgents: []string{"b"}},
+				"b": {SubAgents: []string{"a"}},
+			},
+			want:   []string{"agent reference cycle: a -> b -> a"},
+			cycles: 1,
+		},
+		{
+			name: "cycle through tools, sub-agents and multi-agent tools",
+			configs: map[string]AgentConfig{
+				"a": {Tools: callAgent("b")},
+				"b": {SubAgents: []string{"c"}},
+				"c": {Multi: &MultiConfig{Agents: []SubAgentConfig{{ID: "step", Tools: callAgent("a")}}}},
+			},
+			want:   []string{"agent reference cycle: a -> b -> c -> a"},
+			cycles: 1,
+		},
+		{
+			name: "cycle below an acyclic agent",
+			configs: map[string]AgentConfig{
+				"a": {SubAgents: []string{"b"}},
+				"b": {Tools: callAgent("c")},
+				"c": {Tools: callAgent("b")},
+			},
+			want:   []string{"agent reference cycle: b -> c -> b"},
+			cycles: 1,
+		},
+		{
+			name: "unknown agents and cycles are all reported",
+			configs: map[string]AgentConfig{
+				"a": {SubAgents: []string{"a", "missing"}},
+				"b": {Tools: callAgent("c")},
+				"c": {Tools: callAgent("b")},
+			},
+			want:   []string{`unknown agent "missing"`, "a -> a", "b -> c -> b"},
+			cycles: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAgentRefs(tt.configs)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateAgentRefs: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("validateAgentRefs succeeded, want an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+			if n := strings.Count(err.Error(), "agent reference cycle"); n != tt.cycles {
+				t.Errorf("error %q reports %d cycles, want %d", err, n, tt.cycles)
+			}
+		})
+	}
+}
+
+func TestLoadConfigsRejectsCycles(t *testing.T) {
+	dir := writeConfigs(t, "", map[string]string{
+		"a": singleAgent("a", `,"tools":[{"type":"agent","agent":"b"}]`),
+		"b": singleAgent("b", `,"sub_agents":["a"]`),
+	})
+	_, err := loadConfigs(dir)
+	if err == nil || !strings.Contains(err.Error(), "agent reference cycle: a -> b -> a") {
+		t.Fatalf("loadConfigs error = %v, want the cycle", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package approval pauses tool calls that need a human decision. The runner
+// installs a Gate in the context of every run; tools configured with
+// requires_approval wait on it until a client approves, denies or edits the
+// call, or until their timeout denies it.
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event objects of the approval flow. Their StateDelta holds the Request as
+// JSON under MetadataKey.
+const (
+	ObjectTypeRequired = "tool.approval_required"
+	ObjectTypeResolved = "tool.approval_resolved"
+
+	MetadataKey = "_approval"
+)
+
+// Decision values.
+const (
+	DecisionApprove = "approve"
+	DecisionDeny    = "deny"
+	DecisionEdit    = "edit" // approve with replaced arguments
+)
+
+var (
+	// ErrNotPending is returned by Resolve for tool calls that are not
+	// waiting for a decision.
+	ErrNotPending = errors.New("approval: no pending approval for this tool call")
+	// ErrInvalidDecision is returned for malformed decisions.
+	ErrInvalidDecision = errors.New("approval: invalid decision")
+)
+
+// Request is a tool call waiting for a decision. In resolved events
+// Decision and Reason are set and Arguments are the ones the tool ran with.
+type Request struct {
+	ToolCallID string          `json:"tool_call_id"`
+	ToolName   string          `json:"tool_name"`
+	Arguments  json.RawMessage `json:"arguments"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	Decision   string          `json:"decision,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+}
+
+// Decision answers a Request. Arguments are only used with DecisionEdit.
+type Decision struct {
+	Decision  string          `json:"decision"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// Validate checks the decision value and, for edits, that the arguments
+// are a JSON object.
+func (d Decision) Validate() error {
+	switch d.Decision {
+	case DecisionApprove, DecisionDeny:
+		return nil
+	case DecisionEdit:
+		args := bytes.TrimSpace(d.Arguments)
+		if len(args) == 0 || args[0] != '{' || !json.Valid(args) {
+			return fmt.Errorf("%w: edit requires arguments as a JSON object", ErrInvalidDecision)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: decision must be %s, %s or %s",
+			ErrInvalidDecision, DecisionApprove, DecisionDeny, DecisionEdit)
+	}
+}
+
+// Gate holds the pending approvals of one run. notify is called when a
+// request opens and again when it is resolved.
+type Gate struct {
+	notify func(Request)
+
+	mu      sync.Mutex
+	pending map[string]*pendingCall
+}
+
+type pendingCall struct {
+	req     Request
+	check   ArgsCheck
+	decided chan Decision // buffered; written once by Resolve
+}
+
+// ArgsCheck validates the arguments of an edit decision, e.g. against the
+// input schema of the tool.
+type ArgsCheck func(args json.RawMessage) error
+
+// NewGate creates a Gate that reports requests to notify.
+func NewGate(notify func(Request)) *Gate {
+	return &Gate{notify: notify, pending: make(map[string]*pendingCall)}
+}
+
+// Await opens req and blocks until it is resolved. When timeout passes or
+// ctx ends first, the call is denied. Edits whose arguments fail check are
+// rejected by Resolve and leave the call pending; check may be nil.
+func (g *Gate) Await(ctx context.Context, req Request, timeout time.Duration, check ArgsCheck) Decision {
+	req.ExpiresAt = time.Now().Add(timeout)
+	p := &pendingCall{req: req, check: check, decided: make(chan Decision, 1)}
+	g.mu.Lock()
+	g.pending[req.ToolCallID] = p
+	g.mu.Unlock()
+	g.notify(req)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var d Decision
+	select {
+	case d = <-p.decided:
+	case <-timer.C:
+		d = g.expire(p, "approval timed out")
+	case <-ctx.Done():
+		d = g.expire(p, "run ended")
+	}
+
+	req.Decision, req.Reason = d.Decision, d.Reason
+	if d.Decision == DecisionEdit {
+		req.Arguments = d.Arguments
+	}
+	g.notify(req)
+	return d
+}
+
+// expire denies p unless Resolve got to it first.
+func (g *Gate) expire(p *pendingCall, reason string) Decision {
+	g.mu.Lock()
+	_, open := g.pending[p.req.ToolCallID]
+	delete(g.pending, p.req.ToolCallID)
+	g.mu.Unlock()
+	if !open {
+		return <-p.decided
+	}
+	return Decision{Decision: DecisionDeny, Reason: reason}
+}
+
+// Resolve decides a pending tool call. An edit with invalid arguments
+// returns ErrInvalidDecision and leaves the call pending.
+func (g *Gate) Resolve(toolCallID string, d Decision) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	p, ok := g.pending[toolCallID]
+	if !ok {
+		g.mu.Unlock()
+		return ErrNotPending
+	}
+	if d.Decision == DecisionEdit && p.check != nil {
+		if err := p.check(d.Arguments); err != nil {
+			g.mu.Unlock()
+			return fmt.Errorf("%w: %v", ErrInvalidDecision, err)
+		}
+	}
+	delete(g.pending, toolCallID)
+	g.mu.Unlock()
+	p.decided <- d
+	return nil
+}
+
+// Pending lists the open requests.
+func (g *Gate) Pending() []Request {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	out := make([]Request, 0, len(g.pending))
+	for _, p := range g.pending {
+		out = append(out, p.req)
+	}
+	return out
+}
+
+type gateKey struct{}
+
+// ContextWithGate returns a context whose approval tools wait on g.
+func ContextWithGate(ctx context.Context, g *Gate) context.Context {
+	return context.WithValue(ctx, gateKey{}, g)
+}
+
+// GateFromContext returns the gate of the run, or nil.
+func GateFromContext(ctx context.Context) *Gate {
+	g, _ := ctx.Value(gateKey{}).(*Gate)
+	return g
+}
@@ -0,0 +1,200 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingGate returns a gate that collects the requests it reports.
+func recordingGate() (*Gate, func() []Request) {
+	var (
+		mu   sync.Mutex
+		seen []Request
+	)
+	g := NewGate(func(r Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, r)
+	})
+	return g, func() []Request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Request(nil), seen...)
+	}
+}
+
+// waitPending waits until id is pending on g.
+func waitPending(t *testing.T, g *Gate, id string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, r := range g.Pending() {
+			if r.ToolCallID == id {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("%s never became pending", id)
+}
+
+func TestGateResolve(t *testing.T) {
+	g, seen := recordingGate()
+	done := make(chan Decision)
+	go func() {
+		done <- g.Await(context.Background(), Request{ToolCallID: "c1", ToolName: "calc", Arguments: json.RawMessage(`{"a":1}`)}, time.Minute, nil)
+	}()
+	waitPending(t, g, "c1")
+
+	edit := Decision{Decision: DecisionEdit, Arguments: json.RawMessage(`{"a":2}`), Reason: "fixed"}
+	if err := g.Resolve("c1", edit); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if d := <-done; d.Decision != DecisionEdit || string(d.Arguments) != `{"a":2}` {
+		t.Errorf("Await = %+v, want the edit", d)
+	}
+	if err := g.Resolve("c1", Decision{Decision: DecisionApprove}); !errors.Is(err, ErrNotPending) {
+		t.Errorf("second Resolve error = %v, want ErrNotPending", err)
+	}
+	if len(g.Pending()) != 0 {
+		t.Errorf("pending after Resolve: %v", g.Pending())
+	}
+
+	reqs := seen()
+	if len(reqs) != 2 {
+		t.Fatalf("notified %d times, want opened and resolved", len(reqs))
+	}
+	if reqs[0].Decision != "" || reqs[0].ExpiresAt.IsZero() {
+		t.Errorf("opened request = %+v", reqs[0])
+	}
+	if r := reqs[1]; r.Decision != DecisionEdit || r.Reason != "fixed" || string(r.Arguments) != `{"a":2}` {
+		t.Errorf("resolved request = %+v, want the edit with its arguments", r)
+	}
+}
+
+func TestGateTimeoutAndCancel(t *testing.T) {
+	g, _ := recordingGate()
+	d := g.Await(context.Background(), Request{ToolCallID: "c1"}, time.Millisecond, nil)
+	if d.Decision != DecisionDeny || d.Reason != "approval timed out" {
+		t.Errorf("timed out Await = %+v", d)
+	}
+	if err := g.Resolve("c1", Decision{Decision: DecisionApprove}); !errors.Is(err, ErrNotPending) {
+		t.Errorf("Resolve after timeout error = %v, want ErrNotPending", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d = g.Await(ctx, Request{ToolCallID: "c2"}, time.Minute, nil)
+	if d.Decision != DecisionDeny || d.Reason != "run ended" {
+		t.Errorf("cancelled Await = %+v", d)
+	}
+	if len(g.Pending()) != 0 {
+		t.Errorf("pending after expiry: %v", g.Pending())
+	}
+}
+
+// TestGateTimeoutRacesResolve resolves calls right when they time out. A
+// Resolve that succeeded must decide the call; one that lost must get
+// ErrNotPending and the call is denied.
+func TestGateTimeoutRacesResolve(t *testing.T) {
+	g, _ := recordingGate()
+	const calls = 200
+
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		id := fmt.Sprintf("c%d", i)
+		timeout := time.Duration(i%5) * 100 * time.Microsecond
+		if timeout == 0 {
+			timeout = time.Microsecond
+		}
+
+		decided := make(chan Decision, 1)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			decided <- g.Await(context.Background(), Request{ToolCallID: id}, timeout, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			var err error
+			for {
+				err = g.Resolve(id, Decision{Decision: DecisionApprove})
+				if err == nil {
+					break
+				}
+				select {
+				case d := <-decided:
+					// The timeout won; Resolve never saw the call or lost.
+					if d.Decision != DecisionDeny {
+						t.Errorf("%s: Resolve failed (%v) but Await returned %+v", id, err, d)
+					}
+					return
+				default:
+				}
+			}
+			if d := <-decided; d.Decision != DecisionApprove {
+				t.Errorf("%s: Resolve succeeded but Await returned %+v", id, d)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(g.Pending()) != 0 {
+		t.Errorf("%d calls still pending", len(g.Pending()))
+	}
+}
+
+func TestGateRejectsInvalidEdits(t *testing.T) {
+	g, _ := recordingGate()
+	check := func(args json.RawMessage) error {
+		var m map[string]any
+		if err := json.Unmarshal(args, &m); err != nil {
+			return err
+		}
+		if _, ok := m["a"].(float64); !ok {
+			return errors.New("a must be a number")
+		}
+		return nil
+	}
+	done := make(chan Decision)
+	go func() {
+		done <- g.Await(context.Background(), Request{ToolCallID: "c1"}, time.Minute, check)
+	}()
+	waitPending(t, g, "c1")
+
+	for _, d := range []Decision{
+		{Decision: DecisionEdit, Arguments: json.RawMessage(`{"a":"one"}`)},
+		{Decision: DecisionEdit, Arguments: json.RawMessage(`[1]`)},
+		{Decision: DecisionEdit},
+		{Decision: "maybe"},
+	} {
+		if err := g.Resolve("c1", d); !errors.Is(err, ErrInvalidDecision) {
+			t.Errorf("Resolve(%+v) error = %v, want ErrInvalidDecision", d, err)
+		}
+	}
+	if len(g.Pending()) != 1 {
+		t.Fatal("rejected decisions closed the call")
+	}
+
+	// Approve and deny carry no arguments and are not checked.
+	if err := g.Resolve("c1", Decision{Decision: DecisionDeny}); err != nil {
+		t.Fatalf("Resolve deny: %v", err)
+	}
+	if d := <-done; d.Decision != DecisionDeny {
+		t.Errorf("Await = %+v, want deny", d)
+	}
+}
+
+func TestGateContext(t *testing.T) {
+	if GateFromContext(context.Background()) != nil {
+		t.Error("background context has a gate")
+	}
+	g := NewGate(func(Request) {})
+	if GateFromContext(ContextWithGate(context.Background(), g)) != g {
+		t.Error("gate not found in its context")
+	}
+}
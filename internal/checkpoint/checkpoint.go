@@ -0,0 +1,136 @@
+// Package checkpoint persists the checkpoints of graph runs so a run that
+// stopped at an interrupt_before node can be inspected, edited and resumed
+// by a later request. MemoryStore keeps checkpoints in process memory;
+// pgstore.CheckpointStore keeps them in PostgreSQL.
+//
+// Every graph run starts a lineage named after its run ID. Runs resumed from
+// one of its checkpoints continue the same lineage.
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+	"trpc.group/trpc-go/trpc-agent-go/graph/checkpoint/inmemory"
+)
+
+// Event object of a graph run that stopped at an interrupt. Its StateDelta
+// holds the Interrupt as JSON under MetadataKey.
+const (
+	ObjectTypeInterrupted = "graph.interrupted"
+
+	MetadataKey = "_checkpoint"
+)
+
+var (
+	// ErrNotFound is returned for unknown lineages and checkpoints.
+	ErrNotFound = errors.New("checkpoint: not found")
+	// ErrReservedKey is returned for state edits of executor-owned keys.
+	ErrReservedKey = errors.New("checkpoint: reserved state key")
+)
+
+// Lineage records the run a lineage belongs to, so it can be resumed with
+// the same agent, user and session.
+type Lineage struct {
+	ID        string    `json:"id"`
+	AgentID   string    `json:"agent_id"`
+	UserID    string    `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Interrupt describes where a graph run stopped.
+type Interrupt struct {
+	LineageID    string `json:"lineage_id"`
+	CheckpointID string `json:"checkpoint_id"`
+	NodeID       string `json:"node_id"`
+	Value        any    `json:"value,omitempty"`
+}
+
+// Store is a graph.CheckpointSaver that also records lineages.
+type Store interface {
+	graph.CheckpointSaver
+	PutLineage(ctx context.Context, l Lineage) error
+	// GetLineage returns a lineage or ErrNotFound.
+	GetLineage(ctx context.Context, id string) (Lineage, error)
+}
+
+// reservedKeys are state keys that may not be edited: executor internals,
+// checkpoint config and values that only exist while a run is going.
+var reservedKeys = map[string]bool{
+	graph.CfgKeyLineageID:        true,
+	graph.CfgKeyCheckpointID:     true,
+	graph.CfgKeyCheckpointNS:     true,
+	graph.StateKeySession:        true,
+	graph.StateKeyExecContext:    true,
+	graph.StateKeyCurrentNodeID:  true,
+	graph.StateKeyToolCallbacks:  true,
+	graph.StateKeyModelCallbacks: true,
+	graph.StateKeyAgentCallbacks: true,
+	graph.StateKeyParentAgent:    true,
+}
+
+// IsReservedKey reports whether a state key may not be edited.
+func IsReservedKey(key string) bool {
+	return reservedKeys[key] || strings.HasPrefix(key, "_")
+}
+
+// defaultRetention is how long MemoryStore keeps lineages.
+const defaultRetention = 24 * time.Hour
+
+// MemoryStore is a Store that lives in process memory. Lineages and their
+// checkpoints are dropped after the retention period.
+type MemoryStore struct {
+	*inmemory.Saver
+	retention time.Duration
+
+	mu       sync.Mutex
+	lineages map[string]Lineage
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Saver:     inmemory.NewSaver(),
+		retention: defaultRetention,
+		lineages:  make(map[string]Lineage),
+	}
+}
+
+// WithRetention sets how long lineages are kept.
+func (s *MemoryStore) WithRetention(d time.Duration) *MemoryStore {
+	s.retention = d
+	return s
+}
+
+// PutLineage stores a lineage and prunes expired ones.
+func (s *MemoryStore) PutLineage(ctx context.Context, l Lineage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, old := range s.lineages {
+		if now.Sub(old.CreatedAt) > s.retention {
+			delete(s.lineages, id)
+			_ = s.Saver.DeleteLineage(ctx, id)
+		}
+	}
+	s.lineages[l.ID] = l
+	return nil
+}
+
+// GetLineage returns a lineage or ErrNotFound.
+func (s *MemoryStore) GetLineage(_ context.Context, id string) (Lineage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.lineages[id]
+	if !ok {
+		return Lineage{}, ErrNotFound
+	}
+	return l, nil
+}
@@ -0,0 +1,76 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+func TestIsReservedKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"messages":                false,
+		"draft":                   false,
+		"user_input":              false,
+		graph.CfgKeyLineageID:     true,
+		graph.CfgKeyCheckpointID:  true,
+		graph.StateKeySession:     true,
+		graph.StateKeyExecContext: true,
+		"_private":                true,
+		"__interrupt__":           true,
+	} {
+		if got := IsReservedKey(key); got != want {
+			t.Errorf("IsReservedKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestMemoryStoreLineages(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	if _, err := s.GetLineage(ctx, "l1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetLineage of an unknown lineage error = %v, want ErrNotFound", err)
+	}
+	l := Lineage{ID: "l1", AgentID: "g", UserID: "u", SessionID: "s", CreatedAt: time.Now()}
+	if err := s.PutLineage(ctx, l); err != nil {
+		t.Fatalf("PutLineage: %v", err)
+	}
+	if got, err := s.GetLineage(ctx, "l1"); err != nil || got != l {
+		t.Errorf("GetLineage = %+v, %v, want %+v", got, err, l)
+	}
+}
+
+func TestMemoryStoreRetention(t *testing.T) {
+	s := NewMemoryStore().WithRetention(time.Hour)
+	ctx := context.Background()
+
+	old := Lineage{ID: "old", AgentID: "g", CreatedAt: time.Now().Add(-2 * time.Hour)}
+	if err := s.PutLineage(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := s.Put(ctx, graph.PutRequest{
+		Config:     graph.CreateCheckpointConfig("old", "", "g"),
+		Checkpoint: graph.NewCheckpoint(map[string]any{"n": 1}, nil, nil),
+		Metadata:   graph.NewCheckpointMetadata(graph.CheckpointSourceLoop, 0),
+	})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Storing the next lineage prunes the expired one with its checkpoints.
+	if err := s.PutLineage(ctx, Lineage{ID: "new", AgentID: "g", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetLineage(ctx, "old"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired lineage error = %v, want ErrNotFound", err)
+	}
+	if tuple, err := s.GetTuple(ctx, cfg); err != nil || tuple != nil {
+		t.Errorf("checkpoint of the expired lineage = %v, %v, want none", tuple, err)
+	}
+	if _, err := s.GetLineage(ctx, "new"); err != nil {
+		t.Errorf("GetLineage new: %v", err)
+	}
+}
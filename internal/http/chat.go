@@ -1,15 +1,21 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"time"
+
+	"github.com/google/uuid"
 
-	"helixrun/internal/agents"
 	runnersvc "helixrun/internal/runner"
+	"helixrun/internal/usage"
 
+	"trpc.group/trpc-go/trpc-agent-go/agent"
 	"trpc.group/trpc-go/trpc-agent-go/event"
 	"trpc.group/trpc-go/trpc-agent-go/model"
 )
@@ -17,12 +23,13 @@ import (
 // ChatServer handles /chat SSE requests.
 type ChatServer struct {
 	runnerService *runnersvc.Service
+	buffers       sseBuffers
 }
 
-// NewChatServer creates a ChatServer.
-func NewChatServer(reg *agents.Registry) *ChatServer {
+// NewChatServer creates a ChatServer on top of a configured runner service.
+func NewChatServer(svc *runnersvc.Service) *ChatServer {
 	return &ChatServer{
-		runnerService: runnersvc.NewService(reg),
+		runnerService: svc,
 	}
 }
 
@@ -41,12 +48,23 @@ type sseEnvelope struct {
 }
 
 // ChatHandler is an HTTP handler for POST /chat that streams SSE.
+//
+// Every frame has an id "<run-id>:<seq>". A client that loses the stream can
+// POST /chat again with that id in the Last-Event-ID header and the
+// user_id and session_id of the original request to replay the frames it
+// missed and continue the live stream. A run without a connected client is
+// cancelled after sseResumeGrace.
 func (s *ChatServer) ChatHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		s.resume(w, r, lastID)
+		return
+	}
+
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
@@ -70,27 +88,72 @@ func (s *ChatServer) ChatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setSSEHeaders(w)
 
 	ctx := r.Context()
 
 	msg := model.NewUserMessage(req.Message)
 
-	eventCh, err := s.runnerService.Run(ctx, req.AgentID, req.UserID, req.SessionID, msg)
+	// The run ID lets another client cancel this run via /runs/{id}/cancel.
+	runID := uuid.NewString()
+	w.Header().Set("X-Run-ID", runID)
+
+	// De run overleeft een weggevallen verbinding zodat de client kan hervatten.
+	eventCh, err := s.runnerService.Run(context.WithoutCancel(ctx), req.AgentID, req.UserID, req.SessionID, msg, agent.WithRequestID(runID))
 	if err != nil {
 		log.Printf("runner service failed: %v", err)
-		if errors.Is(err, runnersvc.ErrBuildAgent) {
+		var budgetErr *usage.BudgetExceededError
+		switch {
+		case errors.As(err, &budgetErr):
+			writeBudgetExceeded(w, flusher, budgetErr)
+		case errors.Is(err, runnersvc.ErrBuildAgent):
 			writeSSEError(w, flusher, err)
-		} else {
+		default:
 			handleSSEError(w, flusher, err)
 		}
 		return
 	}
 
-	// Zorg dat headers en flusher al gezet zijn vóór deze loop.
+	buf := s.buffers.create(runID, sseOwner{userID: req.UserID, sessionID: req.SessionID})
+	go s.pump(runID, buf, eventCh)
+	s.follow(w, r, flusher, runID, buf, 0)
+}
+
+// resume reattaches a client to the run named in its Last-Event-ID. The
+// body names the user and session of the run; agent_id and message are
+// ignored. Runs of other users or sessions are reported as not found.
+func (s *ChatServer) resume(w http.ResponseWriter, r *http.Request, lastID string) {
+	runID, seq, err := parseSSEEventID(lastID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid Last-Event-ID: %v", err), http.StatusBadRequest)
+		return
+	}
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		req.UserID = "anonymous"
+	}
+	buf := s.buffers.get(runID, sseOwner{userID: req.UserID, sessionID: req.SessionID})
+	if buf == nil {
+		http.Error(w, "run not found or no longer buffered", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	setSSEHeaders(w)
+	w.Header().Set("X-Run-ID", runID)
+	s.follow(w, r, flusher, runID, buf, seq)
+}
+
+// pump projects the events of a run to UI frames in its buffer.
+func (s *ChatServer) pump(runID string, buf *sseBuffer, eventCh <-chan *event.Event) {
 	for ev := range eventCh {
 		if ev == nil {
 			continue
@@ -103,30 +166,68 @@ func (s *ChatServer) ChatHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 2) Bouw payload voor de frontend.
-		//    - "ui": samengevatte node/graph/model info (voor nette UI)
-		//    - "raw": volledige event voor debug panel rechts
-
 		data, marshalErr := json.Marshal(uiEv)
 		if marshalErr != nil {
 			log.Printf("marshal error event failed: %v", marshalErr)
 			continue
 		}
+		buf.add(data)
+	}
+	buf.finish()
+	time.AfterFunc(sseBufferRetention, func() { s.buffers.remove(runID) })
+}
+
+// follow writes the frames of a run after seq to the client, then the live
+// frames until the run ends or the client goes away.
+func (s *ChatServer) follow(w http.ResponseWriter, r *http.Request, flusher http.Flusher, runID string, buf *sseBuffer, seq int64) {
+	buf.attach()
+	defer func() {
+		if buf.detach() {
+			time.AfterFunc(sseResumeGrace, func() {
+				if buf.orphanedFor(sseResumeGrace) {
+					log.Printf("run %s has no client for %s, cancelling", runID, sseResumeGrace)
+					_ = s.runnerService.Cancel(runID)
+				}
+			})
+		}
+	}()
+
+	ctx := r.Context()
+	for {
+		frames, missed, done, wake := buf.since(seq)
+		if missed > 0 {
+			data, _ := json.Marshal(map[string]any{"type": "stream.gap", "missed": missed})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
 
 		// 3) Stuur als SSE
 		//    Frontend luistert op 'message' en parse't JSON.
-		if _, err := fmt.Fprintf(w, "data: %s\n\n", string(data)); err != nil {
-			log.Printf("write sse event failed: %v", err)
-			return
+		for _, f := range frames {
+			if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", sseEventID(runID, f.seq), f.data); err != nil {
+				log.Printf("write sse event failed: %v", err)
+				return
+			}
+			seq = f.seq
 		}
 		flusher.Flush()
 
-		// 4) Optioneel: als je alleen 1 run wil, kun je op RunnerCompletion breken.
-		if uiEv.RunnerCompletion {
-			// laatste event voor deze run
-			break
+		// 4) Laatste event voor deze run geschreven.
+		if done {
+			return
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return
 		}
 	}
+}
 
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 }
 
 func writeSSEError(w http.ResponseWriter, flusher http.Flusher, err error) {
@@ -163,3 +264,28 @@ func handleSSEError(w http.ResponseWriter, flusher http.Flusher, err error) {
 	fmt.Fprintf(w, "data: %s\n\n", string(data))
 	flusher.Flush()
 }
+
+// writeBudgetExceeded reports a run rejected by a budget as a
+// "budget.exceeded" frame with status 429.
+func writeBudgetExceeded(w http.ResponseWriter, flusher http.Flusher, err *usage.BudgetExceededError) {
+	env := map[string]any{
+		"type": "budget.exceeded",
+		"error": map[string]any{
+			"message": err.Error(),
+		},
+		"budget":   err.Budget,
+		"subject":  err.Subject,
+		"cost_usd": err.CostUSD,
+		"tokens":   err.Tokens,
+	}
+
+	data, marshalErr := json.Marshal(env)
+	if marshalErr != nil {
+		log.Printf("marshal error env failed: %v", marshalErr)
+		return
+	}
+
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "data: %s\n\n", string(data))
+	flusher.Flush()
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+
+	"helixrun/internal/checkpoint"
+	"helixrun/internal/runstore"
+
+	"trpc.group/trpc-go/trpc-agent-go/agent"
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+// latestCheckpoint selects the newest checkpoint of a lineage in URLs.
+const latestCheckpoint = "latest"
+
+// checkpointView is the JSON form of a graph checkpoint. State holds the
+// editable state keys and is only set for single checkpoints.
+type checkpointView struct {
+	ID        string               `json:"id"`
+	ParentID  string               `json:"parent_id,omitempty"`
+	Source    string               `json:"source,omitempty"`
+	Step      int                  `json:"step"`
+	CreatedAt time.Time            `json:"created_at"`
+	NextNodes []string             `json:"next_nodes,omitempty"`
+	Interrupt *checkpointInterrupt `json:"interrupt,omitempty"`
+	State     map[string]any       `json:"state,omitempty"`
+}
+
+type checkpointInterrupt struct {
+	NodeID string `json:"node_id"`
+	Value  any    `json:"value,omitempty"`
+}
+
+func newCheckpointView(t *graph.CheckpointTuple, withState bool) checkpointView {
+	c := t.Checkpoint
+	v := checkpointView{
+		ID:        c.ID,
+		ParentID:  c.ParentCheckpointID,
+		CreatedAt: c.Timestamp,
+		NextNodes: c.NextNodes,
+	}
+	if t.Metadata != nil {
+		v.Source, v.Step = t.Metadata.Source, t.Metadata.Step
+	}
+	if is := c.InterruptState; is != nil {
+		v.Interrupt = &checkpointInterrupt{NodeID: is.NodeID, Value: is.InterruptValue}
+	}
+	if withState {
+		v.State = make(map[string]any, len(c.ChannelValues))
+		for k, val := range c.ChannelValues {
+			if !checkpoint.IsReservedKey(k) {
+				v.State[k] = val
+			}
+		}
+	}
+	return v
+}
+
+func (s *RunsServer) listCheckpoints(w http.ResponseWriter, r *http.Request, lineageID string) {
+	tuples, err := s.runnerService.Checkpoints(r.Context(), lineageID)
+	if err != nil {
+		writeCheckpointError(w, err)
+		return
+	}
+	views := make([]checkpointView, 0, len(tuples))
+	for _, t := range tuples {
+		if t.Checkpoint != nil {
+			views = append(views, newCheckpointView(t, false))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"lineage_id": lineageID, "checkpoints": views})
+}
+
+func (s *RunsServer) getCheckpoint(w http.ResponseWriter, r *http.Request, lineageID, checkpointID string) {
+	if checkpointID == latestCheckpoint {
+		checkpointID = ""
+	}
+	tuple, err := s.runnerService.Checkpoint(r.Context(), lineageID, checkpointID)
+	if err != nil {
+		writeCheckpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newCheckpointView(tuple, true))
+}
+
+// updateCheckpoint copies a checkpoint with state keys replaced:
+//
+//	{"state": {"draft": "...", ...}}
+//
+// The copy becomes the latest checkpoint, so a resume without checkpoint_id
+// continues from it.
+func (s *RunsServer) updateCheckpoint(w http.ResponseWriter, r *http.Request, lineageID, checkpointID string) {
+	var req struct {
+		State map[string]any `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.State) == 0 {
+		http.Error(w, "state is required", http.StatusBadRequest)
+		return
+	}
+	if checkpointID == latestCheckpoint {
+		checkpointID = ""
+	}
+	tuple, err := s.runnerService.UpdateCheckpoint(r.Context(), lineageID, checkpointID, req.State)
+	if err != nil {
+		writeCheckpointError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newCheckpointView(tuple, true))
+}
+
+// resumeRun continues a graph run as a new detached run:
+//
+//	{"checkpoint_id": "...", "resume": <any JSON>}
+//
+// Without checkpoint_id the latest checkpoint is used. resume is the value
+// handed to the interrupted node; it defaults to true.
+func (s *RunsServer) resumeRun(w http.ResponseWriter, r *http.Request, lineageID string) {
+	var req struct {
+		CheckpointID string `json:"checkpoint_id"`
+		Resume       any    `json:"resume"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	// Check the checkpoint up front so an unknown one is a 404, not a failed run.
+	if _, err := s.runnerService.Checkpoint(ctx, lineageID, req.CheckpointID); err != nil {
+		writeCheckpointError(w, err)
+		return
+	}
+	lineage, err := s.runnerService.Lineage(ctx, lineageID)
+	if err != nil {
+		writeCheckpointError(w, err)
+		return
+	}
+
+	run := runstore.Run{
+		ID:        uuid.NewString(),
+		AgentID:   lineage.AgentID,
+		UserID:    lineage.UserID,
+		SessionID: lineage.SessionID,
+		Status:    runstore.StatusRunning,
+		CreatedAt: time.Now(),
+	}
+	s.detach(ctx, w, run, func() (<-chan *event.Event, error) {
+		return s.runnerService.Resume(ctx, lineageID, req.CheckpointID, req.Resume, agent.WithRequestID(run.ID))
+	})
+}
+
+func writeCheckpointError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, checkpoint.ErrNotFound):
+		http.Error(w, "checkpoint not found", http.StatusNotFound)
+	case errors.Is(err, checkpoint.ErrReservedKey):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("checkpoint store failed: %v", err)
+		http.Error(w, "checkpoint store failed", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,266 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"helixrun/internal/agents"
+	"helixrun/internal/checkpoint"
+	runnersvc "helixrun/internal/runner"
+	"helixrun/internal/runstore"
+)
+
+// graphUpstream serves the model of the draft/answer graph of
+// newCheckpointServer. It answers "draft" to the draft node and "answer" to
+// the answer node, and records the messages the answer node was sent.
+type graphUpstream struct {
+	mu      sync.Mutex
+	answers [][]map[string]any
+}
+
+func (u *graphUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Messages []map[string]any `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := "draft"
+	if len(req.Messages) > 0 && req.Messages[0]["content"] == "ANSWER" {
+		out = "answer"
+		u.mu.Lock()
+		u.answers = append(u.answers, req.Messages)
+		u.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"c1","object":"chat.completion","created":1,"model":"m",`+
+		`"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, out)
+}
+
+// answerRequests returns the message lists the answer node was sent.
+func (u *graphUpstream) answerRequests() [][]map[string]any {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([][]map[string]any(nil), u.answers...)
+}
+
+// newCheckpointServer returns a RunsServer for the graph agent "g", which
+// drafts and then answers, and stops before the answer node.
+func newCheckpointServer(t *testing.T) (*RunsServer, *graphUpstream) {
+	t.Helper()
+	up := &graphUpstream{}
+	srv := httptest.NewServer(up)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("TEST_AGENT_KEY", "test-key")
+	dir := t.TempDir()
+	cfg := fmt.Sprintf(`{"id":"g","type":"graph","stream":false,
+		"model":{"provider":"openai","model":"m","base_url":%q,"api_key_env":"env:TEST_AGENT_KEY"},
+		"graph":{
+			"nodes":[{"id":"start","type":"entry"},{"id":"draft","type":"llm","instruction":"DRAFT"},{"id":"answer","type":"llm","instruction":"ANSWER"}],
+			"edges":[{"from":"start","to":"draft"},{"from":"draft","to":"answer"}],
+			"entry":"start","finish":"answer","interrupt_before":["answer"]}}`, srv.URL)
+	if err := os.WriteFile(filepath.Join(dir, "g.json"), []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := agents.LoadRegistry(dir)
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	svc := runnersvc.NewService(reg)
+	svc.WithCheckpointStore(checkpoint.NewMemoryStore())
+	return NewRunsServer(svc, runstore.NewMemoryStore()), up
+}
+
+// serveRuns sends a request to s and returns the response.
+func serveRuns(s *RunsServer, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if path == "/runs" {
+		s.RunsHandler(rec, req)
+	} else {
+		s.RunHandler(rec, req)
+	}
+	return rec
+}
+
+// decodeBody decodes the JSON body of rec into v after checking its status.
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, status int, v any) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, status, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+}
+
+// finishedRun waits for a detached run to finish and returns its events;
+// the last one is run.finished.
+func finishedRun(t *testing.T, s *RunsServer, rec *httptest.ResponseRecorder) []runFrame {
+	t.Helper()
+	var started struct {
+		ID string `json:"id"`
+	}
+	decodeBody(t, rec, http.StatusAccepted, &started)
+	events := serveRuns(s, http.MethodGet, "/runs/"+started.ID+"/events", "")
+	frames := readRunFrames(t, bufio.NewScanner(events.Body), 1000)
+	if len(frames) == 0 || frames[len(frames)-1].data["type"] != "run.finished" {
+		t.Fatalf("run %s did not finish: %+v", started.ID, frames)
+	}
+	return frames
+}
+
+// frameOfType returns the first frame of type typ, or nil.
+func frameOfType(frames []runFrame, typ string) map[string]any {
+	for _, f := range frames {
+		if f.data["type"] == typ {
+			return f.data
+		}
+	}
+	return nil
+}
+
+// runStatus returns the status of a finished run's run.finished frame.
+func runStatus(frames []runFrame) any {
+	return frames[len(frames)-1].data["run"].(map[string]any)["status"]
+}
+
+// interruptedRun starts a run of "g" and returns its lineage and the
+// checkpoint it stopped at.
+func interruptedRun(t *testing.T, s *RunsServer) (lineageID, checkpointID string) {
+	t.Helper()
+	frames := finishedRun(t, s, serveRuns(s, http.MethodPost, "/runs", `{"agent_id":"g","message":"hello","session_id":"s1"}`))
+	ev := frameOfType(frames, checkpoint.ObjectTypeInterrupted)
+	if ev == nil {
+		t.Fatalf("run was not interrupted: %+v", frames)
+	}
+	in := ev["interrupt"].(map[string]any)
+	if in["node_id"] != "answer" || in["lineage_id"] == "" || in["checkpoint_id"] == "" {
+		t.Fatalf("interrupt = %v, want one before answer", in)
+	}
+	if status := runStatus(frames); status != runstore.StatusCompleted {
+		t.Errorf("interrupted run status = %v, want completed", status)
+	}
+	return in["lineage_id"].(string), in["checkpoint_id"].(string)
+}
+
+func TestCheckpointInterruptAndResume(t *testing.T) {
+	s, up := newCheckpointServer(t)
+	lineage, interruptID := interruptedRun(t, s)
+	if n := len(up.answerRequests()); n != 0 {
+		t.Fatalf("answer node ran %d times before the resume", n)
+	}
+
+	var list struct {
+		LineageID   string           `json:"lineage_id"`
+		Checkpoints []checkpointView `json:"checkpoints"`
+	}
+	decodeBody(t, serveRuns(s, http.MethodGet, "/runs/"+lineage+"/checkpoints", ""), http.StatusOK, &list)
+	if list.LineageID != lineage || len(list.Checkpoints) == 0 || list.Checkpoints[0].ID != interruptID {
+		t.Fatalf("checkpoints = %+v, want the interrupt first", list)
+	}
+
+	var latest checkpointView
+	decodeBody(t, serveRuns(s, http.MethodGet, "/runs/"+lineage+"/checkpoints/latest", ""), http.StatusOK, &latest)
+	if latest.ID != interruptID || latest.Interrupt == nil || latest.Interrupt.NodeID != "answer" {
+		t.Errorf("latest checkpoint = %+v, want the interrupt before answer", latest)
+	}
+	if latest.State["last_response"] != "draft" {
+		t.Errorf("state = %v, want the draft", latest.State)
+	}
+	for k := range latest.State {
+		if checkpoint.IsReservedKey(k) {
+			t.Errorf("state shows reserved key %q", k)
+		}
+	}
+
+	// Replace the draft the answer node will see.
+	var edited checkpointView
+	decodeBody(t, serveRuns(s, http.MethodPatch, "/runs/"+lineage+"/checkpoints/latest",
+		`{"state":{"messages":[{"role":"user","content":"hello"},{"role":"assistant","content":"edited draft"}]}}`),
+		http.StatusCreated, &edited)
+	if edited.ID == interruptID || edited.ParentID != interruptID || edited.Source != "update" || edited.Interrupt == nil {
+		t.Errorf("edited checkpoint = %+v, want an update of %s", edited, interruptID)
+	}
+
+	frames := finishedRun(t, s, serveRuns(s, http.MethodPost, "/runs/"+lineage+"/resume", ""))
+	if status := runStatus(frames); status != runstore.StatusCompleted {
+		t.Fatalf("resumed run status = %v: %+v", status, frames)
+	}
+	if frameOfType(frames, checkpoint.ObjectTypeInterrupted) != nil {
+		t.Error("resumed run was interrupted again")
+	}
+	answers := up.answerRequests()
+	if len(answers) != 1 {
+		t.Fatalf("answer node ran %d times, want once", len(answers))
+	}
+	if msgs := answers[0]; len(msgs) != 3 || msgs[1]["content"] != "hello" || msgs[2]["content"] != "edited draft" {
+		t.Errorf("answer node got %v, want the edited draft", msgs)
+	}
+
+	var final checkpointView
+	decodeBody(t, serveRuns(s, http.MethodGet, "/runs/"+lineage+"/checkpoints/latest", ""), http.StatusOK, &final)
+	if final.ParentID != edited.ID || final.Interrupt != nil || final.State["last_response"] != "answer" {
+		t.Errorf("latest checkpoint after resume = %+v, want the answer on top of the edit", final)
+	}
+}
+
+func TestCheckpointResumeFalse(t *testing.T) {
+	s, up := newCheckpointServer(t)
+	lineage, interruptID := interruptedRun(t, s)
+
+	frames := finishedRun(t, s, serveRuns(s, http.MethodPost, "/runs/"+lineage+"/resume", `{"resume":false}`))
+	if status := runStatus(frames); status != runstore.StatusFailed {
+		t.Errorf("run resumed with false has status %v, want failed", status)
+	}
+	if n := len(up.answerRequests()); n != 0 {
+		t.Errorf("answer node ran %d times after a false resume", n)
+	}
+
+	// The interrupt can still be resumed.
+	frames = finishedRun(t, s, serveRuns(s, http.MethodPost, "/runs/"+lineage+"/resume",
+		fmt.Sprintf(`{"checkpoint_id":%q,"resume":true}`, interruptID)))
+	if status := runStatus(frames); status != runstore.StatusCompleted {
+		t.Errorf("second resume status = %v, want completed", status)
+	}
+	if n := len(up.answerRequests()); n != 1 {
+		t.Errorf("answer node ran %d times, want once", n)
+	}
+}
+
+func TestCheckpointRejectsRequests(t *testing.T) {
+	s, _ := newCheckpointServer(t)
+	lineage, _ := interruptedRun(t, s)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+	}{
+		{"unknown lineage", http.MethodGet, "/runs/nope/checkpoints/latest", "", http.StatusNotFound},
+		{"unknown checkpoint", http.MethodGet, "/runs/" + lineage + "/checkpoints/nope", "", http.StatusNotFound},
+		{"resume unknown lineage", http.MethodPost, "/runs/nope/resume", "", http.StatusNotFound},
+		{"resume unknown checkpoint", http.MethodPost, "/runs/" + lineage + "/resume", `{"checkpoint_id":"nope"}`, http.StatusNotFound},
+		{"resume invalid body", http.MethodPost, "/runs/" + lineage + "/resume", `{`, http.StatusBadRequest},
+		{"edit reserved key", http.MethodPatch, "/runs/" + lineage + "/checkpoints/latest", `{"state":{"_checkpoint":1}}`, http.StatusBadRequest},
+		{"edit lineage key", http.MethodPatch, "/runs/" + lineage + "/checkpoints/latest", `{"state":{"lineage_id":"x"}}`, http.StatusBadRequest},
+		{"edit without state", http.MethodPatch, "/runs/" + lineage + "/checkpoints/latest", `{}`, http.StatusBadRequest},
+		{"edit unknown lineage", http.MethodPatch, "/runs/nope/checkpoints/latest", `{"state":{"x":1}}`, http.StatusNotFound},
+		{"delete", http.MethodDelete, "/runs/" + lineage + "/checkpoints/latest", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := serveRuns(s, tt.method, tt.path, tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+}
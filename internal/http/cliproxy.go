@@ -0,0 +1,274 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	pgstore "helixrun/internal/store/postgres"
+)
+
+// CLIProxyServer serves the /api/cliproxy key and usage endpoints.
+type CLIProxyServer struct {
+	keys  *pgstore.KeyRepository
+	usage *pgstore.UsageRepository
+}
+
+// NewCLIProxyServer creates a CLIProxyServer.
+func NewCLIProxyServer(keys *pgstore.KeyRepository, usage *pgstore.UsageRepository) *CLIProxyServer {
+	return &CLIProxyServer{keys: keys, usage: usage}
+}
+
+// createKeyRequest is the JSON payload accepted by POST /api/cliproxy/keys.
+type createKeyRequest struct {
+	ID             string         `json:"id,omitempty"`
+	Provider       string         `json:"provider"`
+	Secret         string         `json:"secret"`
+	Label          string         `json:"label,omitempty"`
+	Status         string         `json:"status,omitempty"`
+	LimitPerMinute *int           `json:"limit_per_minute,omitempty"`
+	LimitPerDay    *int           `json:"limit_per_day,omitempty"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
+	Attributes     map[string]any `json:"attributes,omitempty"`
+}
+
+// updateKeyRequest is the JSON payload accepted by PUT /api/cliproxy/keys/{id}.
+// A limit set to null is removed; an absent limit is left unchanged.
+type updateKeyRequest struct {
+	Label          *string        `json:"label,omitempty"`
+	Secret         *string        `json:"secret,omitempty"`
+	Status         *string        `json:"status,omitempty"`
+	LimitPerMinute nullableInt    `json:"limit_per_minute"`
+	LimitPerDay    nullableInt    `json:"limit_per_day"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
+	Attributes     map[string]any `json:"attributes,omitempty"`
+}
+
+// nullableInt tells an absent JSON field (Set is false) from an explicit
+// null (Set is true, Value is nil).
+type nullableInt struct {
+	Set   bool
+	Value *int
+}
+
+func (n *nullableInt) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	return json.Unmarshal(data, &n.Value)
+}
+
+// cleared reports whether the field was explicitly set to null.
+func (n nullableInt) cleared() bool {
+	return n.Set && n.Value == nil
+}
+
+// KeysHandler handles GET/POST /api/cliproxy/keys.
+func (s *CLIProxyServer) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.keys.List(r.Context(), r.URL.Query().Get("provider"))
+		if err != nil {
+			log.Printf("list cliproxy keys failed: %v", err)
+			http.Error(w, "list keys failed", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+
+	case http.MethodPost:
+		var req createKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Provider == "" {
+			http.Error(w, "provider is required", http.StatusBadRequest)
+			return
+		}
+		if req.Secret == "" {
+			http.Error(w, "secret is required", http.StatusBadRequest)
+			return
+		}
+		if err := validateLimits(req.LimitPerMinute, req.LimitPerDay); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status != "" && !pgstore.ValidKeyStatus(req.Status) {
+			http.Error(w, invalidStatusMessage, http.StatusBadRequest)
+			return
+		}
+
+		key, err := s.keys.Create(r.Context(), pgstore.APIKey{
+			ID:             req.ID,
+			Provider:       req.Provider,
+			Secret:         req.Secret,
+			Label:          req.Label,
+			Status:         req.Status,
+			LimitPerMinute: req.LimitPerMinute,
+			LimitPerDay:    req.LimitPerDay,
+			Metadata:       req.Metadata,
+			Attributes:     req.Attributes,
+		})
+		if err != nil {
+			writeKeyError(w, "create", err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, key)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// KeyHandler handles GET/PUT/DELETE /api/cliproxy/keys/{id}.
+func (s *CLIProxyServer) KeyHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/cliproxy/keys/"), "/")
+	if id == "" {
+		s.KeysHandler(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		key, err := s.keys.Get(r.Context(), id)
+		if err != nil {
+			writeKeyError(w, "get", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, key)
+
+	case http.MethodPut:
+		var req updateKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		if req.Secret != nil && *req.Secret == "" {
+			http.Error(w, "secret must not be empty", http.StatusBadRequest)
+			return
+		}
+		if err := validateLimits(req.LimitPerMinute.Value, req.LimitPerDay.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status != nil && !pgstore.ValidKeyStatus(*req.Status) {
+			http.Error(w, invalidStatusMessage, http.StatusBadRequest)
+			return
+		}
+
+		key, err := s.keys.Update(r.Context(), id, pgstore.KeyUpdate{
+			Label:               req.Label,
+			Secret:              req.Secret,
+			Status:              req.Status,
+			LimitPerMinute:      req.LimitPerMinute.Value,
+			LimitPerDay:         req.LimitPerDay.Value,
+			ClearLimitPerMinute: req.LimitPerMinute.cleared(),
+			ClearLimitPerDay:    req.LimitPerDay.cleared(),
+			Metadata:            req.Metadata,
+			Attributes:          req.Attributes,
+		})
+		if err != nil {
+			writeKeyError(w, "update", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, key)
+
+	case http.MethodDelete:
+		if err := s.keys.Delete(r.Context(), id); err != nil {
+			writeKeyError(w, "delete", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "GET, PUT or DELETE required", http.StatusMethodNotAllowed)
+	}
+}
+
+// UsageHandler handles GET /api/cliproxy/usage.
+func (s *CLIProxyServer) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := pgstore.UsageFilter{
+		Provider: q.Get("provider"),
+		KeyID:    q.Get("key_id"),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(q.Get("from")); err != nil {
+		http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTimeParam(q.Get("to")); err != nil {
+		http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := s.usage.List(r.Context(), filter)
+	if err != nil {
+		log.Printf("list cliproxy usage failed: %v", err)
+		http.Error(w, "list usage failed", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"events": events})
+}
+
+// invalidStatusMessage is the 400 response for an unknown key status.
+const invalidStatusMessage = "status must be active, exhausted or invalid"
+
+func validateLimits(perMinute, perDay *int) error {
+	if perMinute != nil && *perMinute < 0 {
+		return errors.New("limit_per_minute must be >= 0")
+	}
+	if perDay != nil && *perDay < 0 {
+		return errors.New("limit_per_day must be >= 0")
+	}
+	return nil
+}
+
+// parseTimeParam accepts RFC3339 timestamps or plain dates (YYYY-MM-DD).
+func parseTimeParam(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, raw)
+}
+
+func writeKeyError(w http.ResponseWriter, op string, err error) {
+	if errors.Is(err, pgstore.ErrKeyNotFound) {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, pgstore.ErrKeyExists) {
+		http.Error(w, "key already exists", http.StatusConflict)
+		return
+	}
+	log.Printf("%s cliproxy key failed: %v", op, err)
+	http.Error(w, op+" key failed", http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("encode json response failed: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestKeyHandlersRejectInvalidInput covers requests that are rejected
+// before the key repository is used.
+func TestKeyHandlersRejectInvalidInput(t *testing.T) {
+	s := NewCLIProxyServer(nil, nil)
+
+	tests := []struct {
+		name, method, path, body string
+		want                     string
+	}{
+		{"create without provider", http.MethodPost, "/api/cliproxy/keys/", `{"secret":"s"}`, "provider is required"},
+		{"create with unknown status", http.MethodPost, "/api/cliproxy/keys/", `{"provider":"openai","secret":"s","status":"paused"}`, invalidStatusMessage},
+		{"create with negative limit", http.MethodPost, "/api/cliproxy/keys/", `{"provider":"openai","secret":"s","limit_per_day":-1}`, "limit_per_day must be >= 0"},
+		{"update with unknown status", http.MethodPut, "/api/cliproxy/keys/k1", `{"status":"Active"}`, invalidStatusMessage},
+		{"update with empty status", http.MethodPut, "/api/cliproxy/keys/k1", `{"status":""}`, invalidStatusMessage},
+		{"update with negative limit", http.MethodPut, "/api/cliproxy/keys/k1", `{"limit_per_minute":-2}`, "limit_per_minute must be >= 0"},
+		{"update with empty secret", http.MethodPut, "/api/cliproxy/keys/k1", `{"secret":""}`, "secret must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.KeyHandler(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateKeyRequestLimits(t *testing.T) {
+	tests := []struct {
+		body                  string
+		minuteSet, dayCleared bool
+	}{
+		{`{}`, false, false},
+		{`{"limit_per_minute":5}`, true, false},
+		{`{"limit_per_day":null}`, false, true},
+		{`{"limit_per_minute":5,"limit_per_day":null}`, true, true},
+	}
+	for _, tt := range tests {
+		var req updateKeyRequest
+		if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+			t.Fatalf("unmarshal %s: %v", tt.body, err)
+		}
+		if got := req.LimitPerMinute.Value != nil; got != tt.minuteSet {
+			t.Errorf("%s: limit_per_minute set = %v, want %v", tt.body, got, tt.minuteSet)
+		}
+		if req.LimitPerMinute.cleared() {
+			t.Errorf("%s: limit_per_minute cleared", tt.body)
+		}
+		if got := req.LimitPerDay.cleared(); got != tt.dayCleared {
+			t.Errorf("%s: limit_per_day cleared = %v, want %v", tt.body, got, tt.dayCleared)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"helixrun/internal/agents"
+	runnersvc "helixrun/internal/runner"
+	"helixrun/internal/usage"
+
+	"trpc.group/trpc-go/trpc-agent-go/agent"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// OpenAIServer exposes the registry agents through the OpenAI Chat
+// Completions API: the request "model" is the agent ID.
+type OpenAIServer struct {
+	runnerService *runnersvc.Service
+	registry      *agents.Registry
+}
+
+// NewOpenAIServer creates an OpenAIServer.
+func NewOpenAIServer(svc *runnersvc.Service, reg *agents.Registry) *OpenAIServer {
+	return &OpenAIServer{runnerService: svc, registry: reg}
+}
+
+// chatCompletionRequest is the subset of the OpenAI request that is used.
+// Sampling parameters are ignored; agents use their own model settings.
+type chatCompletionRequest struct {
+	Model         string              `json:"model"`
+	Messages      []openAIChatMessage `json:"messages"`
+	Stream        bool                `json:"stream,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	User string `json:"user,omitempty"`
+}
+
+// openAIChatMessage accepts content as a string or as an array of text
+// parts.
+type openAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIChunkChoice struct {
+	Index        int         `json:"index"`
+	Delta        openAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type openAIChunk struct {
+	ID      string              `json:"id"`
+	Object  string              `json:"object"`
+	Created int64               `json:"created"`
+	Model   string              `json:"model"`
+	Choices []openAIChunkChoice `json:"choices"`
+	Usage   *openAIUsage        `json:"usage,omitempty"`
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIChoice struct {
+	Index        int           `json:"index"`
+	Message      openAIMessage `json:"message"`
+	FinishReason string        `json:"finish_reason"`
+}
+
+type openAICompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   openAIUsage    `json:"usage"`
+}
+
+const finishReasonStop = "stop"
+
+// ModelsHandler handles GET /v1/models.
+func (s *OpenAIServer) ModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "GET required")
+		return
+	}
+
+	ids := s.registry.ListAgentIDs()
+	sort.Strings(ids)
+	data := make([]map[string]any, 0, len(ids))
+	for _, id := range ids {
+		data = append(data, map[string]any{
+			"id":       id,
+			"object":   "model",
+			"created":  0,
+			"owned_by": "helixrun",
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+// ChatCompletionsHandler handles POST /v1/chat/completions. Each request
+// runs in a fresh session seeded with the request messages; the last
+// message must come from the user.
+func (s *OpenAIServer) ChatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "POST required")
+		return
+	}
+
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("invalid JSON: %v", err))
+		return
+	}
+	if req.Model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "model is required")
+		return
+	}
+	if !s.registry.HasAgent(req.Model) {
+		writeOpenAIError(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("the model %q does not exist", req.Model))
+		return
+	}
+	if s.registry.RequiresApproval(req.Model) {
+		// Clients of this API have no way to answer an approval, so the
+		// run would wait until the approval times out.
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error",
+			fmt.Sprintf("the model %q uses tools that require approval; use /chat, /runs or /ws instead", req.Model))
+		return
+	}
+	history, err := toModelMessages(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	userID := req.User
+	if userID == "" {
+		userID = "anonymous"
+	}
+
+	var flusher http.Flusher
+	if req.Stream {
+		var ok bool
+		if flusher, ok = w.(http.Flusher); !ok {
+			writeOpenAIError(w, http.StatusInternalServerError, "server_error", "streaming unsupported")
+			return
+		}
+	}
+
+	runID := uuid.NewString()
+	w.Header().Set("X-Run-ID", runID)
+
+	message := history[len(history)-1]
+	eventCh, err := s.runnerService.Run(r.Context(), req.Model, userID, uuid.NewString(), message,
+		agent.WithMessages(history), agent.WithRequestID(runID))
+	if err != nil {
+		log.Printf("runner service failed: %v", err)
+		var budgetErr *usage.BudgetExceededError
+		switch {
+		case errors.As(err, &budgetErr):
+			writeOpenAIError(w, http.StatusTooManyRequests, "insufficient_quota", budgetErr.Error())
+		default:
+			writeOpenAIError(w, http.StatusInternalServerError, "server_error", err.Error())
+		}
+		return
+	}
+
+	id := "chatcmpl-" + uuid.NewString()
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		var (
+			content  string
+			total    openAIUsage
+			runError *model.ResponseError
+		)
+		for ev := range eventCh {
+			uiEv := BuildUIEvent(ev)
+			if uiEv == nil {
+				continue
+			}
+			if uiEv.Error != nil && runError == nil {
+				runError = uiEv.Error
+			}
+			if uiEv.Type == runnersvc.ObjectTypeRunCancelled && runError == nil {
+				runError = &model.ResponseError{Type: runnersvc.ObjectTypeRunCancelled, Message: "run cancelled"}
+			}
+			if _, final := modelText(uiEv); final != "" {
+				content = final
+			}
+			addUsage(&total, uiEv.Usage)
+			if uiEv.RunnerCompletion {
+				break
+			}
+		}
+		if runError != nil {
+			writeOpenAIError(w, http.StatusBadGateway, runError.Type, runError.Message)
+			return
+		}
+		writeJSON(w, http.StatusOK, openAICompletion{
+			ID:      id,
+			Object:  model.ObjectTypeChatCompletion,
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChoice{{
+				Message:      openAIMessage{Role: model.RoleAssistant.String(), Content: content},
+				FinishReason: finishReasonStop,
+			}},
+			Usage: total,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	chunk := func(delta openAIDelta, finish *string) openAIChunk {
+		return openAIChunk{
+			ID:      id,
+			Object:  model.ObjectTypeChatCompletionChunk,
+			Created: created,
+			Model:   req.Model,
+			Choices: []openAIChunkChoice{{Delta: delta, FinishReason: finish}},
+		}
+	}
+	send := func(v any) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("marshal chunk failed: %v", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			log.Printf("write sse chunk failed: %v", err)
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !send(chunk(openAIDelta{Role: model.RoleAssistant.String()}, nil)) {
+		return
+	}
+	var (
+		total    openAIUsage
+		streamed bool // deltas were sent for the current model response
+	)
+	for ev := range eventCh {
+		uiEv := BuildUIEvent(ev)
+		if uiEv == nil {
+			continue
+		}
+		if uiEv.Error != nil {
+			send(map[string]any{"error": openAIErrorBody(uiEv.Error.Type, uiEv.Error.Message)})
+			return
+		}
+		if uiEv.Type == runnersvc.ObjectTypeRunCancelled {
+			send(map[string]any{"error": openAIErrorBody(runnersvc.ObjectTypeRunCancelled, "run cancelled")})
+			return
+		}
+
+		delta, final := modelText(uiEv)
+		if final != "" && !streamed {
+			// The model does not stream; send its response in one chunk.
+			delta = final
+		}
+		if delta != "" && !send(chunk(openAIDelta{Content: delta}, nil)) {
+			return
+		}
+		switch {
+		case uiEv.ContentDelta != "":
+			streamed = true
+		case final != "":
+			streamed = false
+		}
+
+		addUsage(&total, uiEv.Usage)
+		if uiEv.RunnerCompletion {
+			break
+		}
+	}
+
+	finish := finishReasonStop
+	if !send(chunk(openAIDelta{}, &finish)) {
+		return
+	}
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		usageChunk := chunk(openAIDelta{}, nil)
+		usageChunk.Choices = []openAIChunkChoice{}
+		usageChunk.Usage = &total
+		if !send(usageChunk) {
+			return
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+}
+
+// toModelMessages converts the request messages. The agent instruction is
+// the system prompt, and sessions drop everything before the first user
+// message, so system and developer messages are prepended to the first user
+// message. Tool messages are not supported since the agents run their own
+// tools.
+func toModelMessages(in []openAIChatMessage) ([]model.Message, error) {
+	if len(in) == 0 {
+		return nil, fmt.Errorf("messages must not be empty")
+	}
+	var (
+		out    = make([]model.Message, 0, len(in))
+		system []string
+	)
+	for i, m := range in {
+		content, err := messageText(m.Content)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d].content: %w", i, err)
+		}
+		switch m.Role {
+		case "system", "developer":
+			system = append(system, content)
+		case "user":
+			out = append(out, model.NewUserMessage(content))
+		case "assistant":
+			out = append(out, model.NewAssistantMessage(content))
+		default:
+			return nil, fmt.Errorf("messages[%d].role: unsupported role %q", i, m.Role)
+		}
+	}
+	if len(out) == 0 || out[len(out)-1].Role != model.RoleUser || out[len(out)-1].Content == "" {
+		return nil, fmt.Errorf("the last message must be a non-empty user message")
+	}
+	if len(system) > 0 {
+		for i := range out {
+			if out[i].Role == model.RoleUser {
+				out[i].Content = strings.Join(append(system, out[i].Content), "\n\n")
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// messageText returns string content, or the concatenated text parts of
+// array content.
+func messageText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("must be a string or an array of content parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Type != "text" {
+			return "", fmt.Errorf("unsupported content part type %q", p.Type)
+		}
+		texts = append(texts, p.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// modelText returns the streamed delta or the final content of a model
+// response event. Tool responses and other events yield nothing.
+func modelText(uiEv *UIEvent) (delta, final string) {
+	switch uiEv.Object {
+	case model.ObjectTypeChatCompletion, model.ObjectTypeChatCompletionChunk:
+		return uiEv.ContentDelta, uiEv.Content
+	}
+	return "", ""
+}
+
+func addUsage(total *openAIUsage, u *model.Usage) {
+	if u == nil {
+		return
+	}
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.TotalTokens += u.TotalTokens
+}
+
+func openAIErrorBody(typ, message string) map[string]any {
+	return map[string]any{
+		"message": message,
+		"type":    typ,
+		"param":   nil,
+		"code":    nil,
+	}
+}
+
+// writeOpenAIError writes an error in the OpenAI error format.
+func writeOpenAIError(w http.ResponseWriter, status int, typ, message string) {
+	writeJSON(w, status, map[string]any{"error": openAIErrorBody(typ, message)})
+}
@@ -0,0 +1,283 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"helixrun/internal/agents"
+	runnersvc "helixrun/internal/runner"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// upstreamOpenAI serves an OpenAI-compatible chat API that answers every
+// request with parts: one chunk per part when the request streams, their
+// concatenation otherwise. Both report 3 prompt and 2 completion tokens.
+func upstreamOpenAI(t *testing.T, parts ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		const usage = `{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}`
+		if !req.Stream {
+			content, _ := json.Marshal(strings.Join(parts, ""))
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":"c1","object":"chat.completion","created":1,"model":"m",`+
+				`"choices":[{"index":0,"message":{"role":"assistant","content":%s},"finish_reason":"stop"}],"usage":%s}`, content, usage)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, p := range parts {
+			content, _ := json.Marshal(p)
+			fmt.Fprintf(w, `data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"m",`+
+				`"choices":[{"index":0,"delta":{"content":%s}}]}`+"\n\n", content)
+		}
+		fmt.Fprintf(w, `data: {"id":"c1","object":"chat.completion.chunk","created":1,"model":"m",`+
+			`"choices":[{"index":0,"delta":{},"finish_reason":"stop"}],"usage":%s}`+"\n\n", usage)
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testRegistry loads single agents whose model is the OpenAI API at
+// baseURL. configs maps agent IDs to extra config fields, e.g. `,"stream":true`.
+func testRegistry(t *testing.T, baseURL string, configs map[string]string) *agents.Registry {
+	t.Helper()
+	t.Setenv("TEST_AGENT_KEY", "test-key")
+	dir := t.TempDir()
+	for id, extra := range configs {
+		cfg := fmt.Sprintf(`{"id":%q,"type":"single","model":{"provider":"openai","model":"m","base_url":%q,"api_key_env":"env:TEST_AGENT_KEY"}%s}`,
+			id, baseURL, extra)
+		if err := os.WriteFile(filepath.Join(dir, id+".json"), []byte(cfg), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reg, err := agents.LoadRegistry(dir)
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	return reg
+}
+
+func newTestOpenAIServer(t *testing.T, parts ...string) *OpenAIServer {
+	t.Helper()
+	up := upstreamOpenAI(t, parts...)
+	reg := testRegistry(t, up.URL, map[string]string{
+		"streaming":     `,"stream":true`,
+		"non-streaming": `,"stream":false`,
+		"approval":      `,"tools":[{"type":"calculator","requires_approval":true}]`,
+	})
+	return NewOpenAIServer(runnersvc.NewService(reg), reg)
+}
+
+// readChunks returns the data lines of an SSE body. The final [DONE] is
+// returned as is; all other lines are decoded chunks.
+func readChunks(t *testing.T, body io.Reader) (chunks []map[string]any, done bool) {
+	t.Helper()
+	sc := bufio.NewScanner(body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var c map[string]any
+		if err := json.Unmarshal([]byte(data), &c); err != nil {
+			t.Fatalf("decode chunk %s: %v", data, err)
+		}
+		chunks = append(chunks, c)
+	}
+	return chunks, done
+}
+
+func TestChatCompletionsStreamChunks(t *testing.T) {
+	tests := []struct {
+		name, agent string
+		wantDeltas  []string
+	}{
+		{"streaming model", "streaming", []string{"Hel", "lo"}},
+		{"non-streaming model sends one chunk", "non-streaming", []string{"Hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestOpenAIServer(t, "Hel", "lo")
+			body := fmt.Sprintf(`{"model":%q,"stream":true,"stream_options":{"include_usage":true},`+
+				`"messages":[{"role":"user","content":"hi"}]}`, tt.agent)
+			rec := httptest.NewRecorder()
+			srv.ChatCompletionsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+
+			chunks, done := readChunks(t, rec.Body)
+			if !done {
+				t.Error("stream does not end with [DONE]")
+			}
+			// role, deltas, finish, usage
+			if len(chunks) != len(tt.wantDeltas)+3 {
+				t.Fatalf("got %d chunks, want %d: %v", len(chunks), len(tt.wantDeltas)+3, chunks)
+			}
+			id := chunks[0]["id"]
+			for i, c := range chunks {
+				if c["id"] != id || c["object"] != model.ObjectTypeChatCompletionChunk || c["model"] != tt.agent {
+					t.Errorf("chunk %d = %v, want id %v, object chunk and model %s", i, c, id, tt.agent)
+				}
+			}
+			if d := choiceDelta(t, chunks[0]); d["role"] != "assistant" || d["content"] != nil {
+				t.Errorf("first delta = %v, want the assistant role only", d)
+			}
+			for i, want := range tt.wantDeltas {
+				if d := choiceDelta(t, chunks[i+1]); d["content"] != want {
+					t.Errorf("delta %d = %v, want %q", i, d, want)
+				}
+			}
+
+			finish := chunks[len(chunks)-2]
+			if got := finish["choices"].([]any)[0].(map[string]any)["finish_reason"]; got != finishReasonStop {
+				t.Errorf("finish_reason = %v, want stop", got)
+			}
+			if _, ok := finish["usage"]; ok {
+				t.Error("finish chunk carries usage")
+			}
+
+			last := chunks[len(chunks)-1]
+			if choices := last["choices"].([]any); len(choices) != 0 {
+				t.Errorf("usage chunk has choices %v", choices)
+			}
+			if u := last["usage"].(map[string]any); u["prompt_tokens"] != 3.0 || u["completion_tokens"] != 2.0 || u["total_tokens"] != 5.0 {
+				t.Errorf("usage = %v", u)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsStreamWithoutUsage(t *testing.T) {
+	srv := newTestOpenAIServer(t, "Hi")
+	rec := httptest.NewRecorder()
+	srv.ChatCompletionsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"streaming","stream":true,"messages":[{"role":"user","content":"hi"}]}`)))
+
+	chunks, done := readChunks(t, rec.Body)
+	if !done || len(chunks) != 3 {
+		t.Fatalf("got %d chunks (done %v), want role, delta and finish: %v", len(chunks), done, chunks)
+	}
+	for _, c := range chunks {
+		if _, ok := c["usage"]; ok {
+			t.Errorf("chunk %v carries usage without include_usage", c)
+		}
+	}
+}
+
+func TestChatCompletionsNonStream(t *testing.T) {
+	srv := newTestOpenAIServer(t, "Hel", "lo")
+	rec := httptest.NewRecorder()
+	srv.ChatCompletionsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"streaming","messages":[{"role":"user","content":"hi"}]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var got openAICompletion
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Object != model.ObjectTypeChatCompletion || got.Model != "streaming" || !strings.HasPrefix(got.ID, "chatcmpl-") {
+		t.Errorf("completion = %+v", got)
+	}
+	if len(got.Choices) != 1 || got.Choices[0].Message.Content != "Hello" ||
+		got.Choices[0].Message.Role != "assistant" || got.Choices[0].FinishReason != finishReasonStop {
+		t.Errorf("choices = %+v, want one assistant message Hello", got.Choices)
+	}
+	if got.Usage != (openAIUsage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}) {
+		t.Errorf("usage = %+v", got.Usage)
+	}
+}
+
+func TestChatCompletionsRejectsRequests(t *testing.T) {
+	srv := newTestOpenAIServer(t, "Hi")
+	tests := []struct {
+		name, body string
+		status     int
+		errType    string
+	}{
+		{"unknown model", `{"model":"nope","messages":[{"role":"user","content":"q"}]}`, http.StatusNotFound, "model_not_found"},
+		{"no model", `{"messages":[{"role":"user","content":"q"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"tool message", `{"model":"streaming","messages":[{"role":"tool","content":"q"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"last message from assistant", `{"model":"streaming","messages":[{"role":"user","content":"q"},{"role":"assistant","content":"a"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"image part", `{"model":"streaming","messages":[{"role":"user","content":[{"type":"image_url"}]}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"tool requiring approval", `{"model":"approval","messages":[{"role":"user","content":"q"}]}`, http.StatusBadRequest, "invalid_request_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.ChatCompletionsHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var body struct {
+				Error struct {
+					Type string `json:"type"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body.Error.Type != tt.errType {
+				t.Errorf("error type = %q (%v), want %q", body.Error.Type, err, tt.errType)
+			}
+		})
+	}
+}
+
+func TestToModelMessages(t *testing.T) {
+	in := []openAIChatMessage{
+		{Role: "system", Content: json.RawMessage(`"be brief"`)},
+		{Role: "user", Content: json.RawMessage(`"q1"`)},
+		{Role: "assistant", Content: json.RawMessage(`"a1"`)},
+		{Role: "developer", Content: json.RawMessage(`"no emojis"`)},
+		{Role: "user", Content: json.RawMessage(`[{"type":"text","text":"q2"},{"type":"text","text":"more"}]`)},
+	}
+	got, err := toModelMessages(in)
+	if err != nil {
+		t.Fatalf("toModelMessages: %v", err)
+	}
+	want := []model.Message{
+		model.NewUserMessage("be brief\n\nno emojis\n\nq1"),
+		model.NewAssistantMessage("a1"),
+		model.NewUserMessage("q2\nmore"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Role != want[i].Role || got[i].Content != want[i].Content {
+			t.Errorf("message %d = %s %q, want %s %q", i, got[i].Role, got[i].Content, want[i].Role, want[i].Content)
+		}
+	}
+}
+
+func choiceDelta(t *testing.T, chunk map[string]any) map[string]any {
+	t.Helper()
+	choices, _ := chunk["choices"].([]any)
+	if len(choices) != 1 {
+		t.Fatalf("chunk %v has %d choices, want 1", chunk, len(choices))
+	}
+	return choices[0].(map[string]any)["delta"].(map[string]any)
+}
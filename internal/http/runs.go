@@ -0,0 +1,385 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"helixrun/internal/approval"
+	runnersvc "helixrun/internal/runner"
+	"helixrun/internal/runstore"
+	"helixrun/internal/usage"
+
+	"trpc.group/trpc-go/trpc-agent-go/agent"
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+const (
+	// runEventsBatch is how many stored events are read per query.
+	runEventsBatch = 500
+	// runEventsPoll bounds how long a reader waits before checking the store
+	// again, for runs written by another replica.
+	runEventsPoll = time.Second
+)
+
+// RunsServer manages runs under /runs. Runs started with POST /runs are
+// detached from the request and their events are kept in the run store.
+type RunsServer struct {
+	runnerService *runnersvc.Service
+	store         runstore.Store
+	notifier      *runstore.Notifier
+}
+
+// NewRunsServer creates a RunsServer that keeps detached runs in store.
+func NewRunsServer(svc *runnersvc.Service, store runstore.Store) *RunsServer {
+	return &RunsServer{runnerService: svc, store: store, notifier: runstore.NewNotifier()}
+}
+
+// RunsHandler handles GET /runs, the list of active runs, and POST /runs,
+// which starts a detached run. The POST body is the same as for /chat.
+func (s *RunsServer) RunsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		runs := s.runnerService.ActiveRuns()
+		sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.Before(runs[j].StartedAt) })
+		writeJSON(w, http.StatusOK, map[string]any{"runs": runs})
+
+	case http.MethodPost:
+		s.startRun(w, r)
+
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// RunHandler handles the routes of one run:
+//
+//	GET  /runs/{id}                       - status of a detached run
+//	GET  /runs/{id}/events                - SSE stream of its events, from ?offset=N on
+//	POST /runs/{id}/cancel                - cancel an active run
+//	GET  /runs/{id}/approvals             - tool calls waiting for approval
+//	POST /runs/{id}/approvals/{toolCall}  - approve, deny or edit a tool call
+//	GET  /runs/{id}/checkpoints           - checkpoints of a graph run, newest first
+//	GET  /runs/{id}/checkpoints/{cp}      - one checkpoint with its state ("latest" for the newest)
+//	PATCH /runs/{id}/checkpoints/{cp}     - copy a checkpoint with an edited state
+//	POST /runs/{id}/resume                - continue a graph run from a checkpoint
+//
+// For cancel and approvals, id is the run ID (the requestId of the run's
+// events and the X-Run-ID header of /chat) or its invocation ID. For
+// checkpoints and resume it is the run ID of the graph run that started the
+// lineage; resumed runs continue that lineage.
+func (s *RunsServer) RunHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	id, action, _ := strings.Cut(rest, "/")
+	if id == "" {
+		s.RunsHandler(w, r)
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		run, err := s.store.GetRun(r.Context(), id)
+		if err != nil {
+			writeRunError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, run)
+
+	case action == "events" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, id)
+
+	case action == "cancel" && r.Method == http.MethodPost:
+		if err := s.runnerService.Cancel(id); err != nil {
+			if errors.Is(err, runnersvc.ErrRunNotFound) {
+				http.Error(w, "run not found or already finished", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]any{"id": id, "status": "cancelling"})
+
+	case action == "approvals" && r.Method == http.MethodGet:
+		pending, err := s.runnerService.PendingApprovals(id)
+		if err != nil {
+			http.Error(w, "run not found or already finished", http.StatusNotFound)
+			return
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].ExpiresAt.Before(pending[j].ExpiresAt) })
+		writeJSON(w, http.StatusOK, map[string]any{"approvals": pending})
+
+	case strings.HasPrefix(action, "approvals/") && r.Method == http.MethodPost:
+		s.resolveApproval(w, r, id, strings.TrimPrefix(action, "approvals/"))
+
+	case action == "checkpoints" && r.Method == http.MethodGet:
+		s.listCheckpoints(w, r, id)
+
+	case strings.HasPrefix(action, "checkpoints/") && r.Method == http.MethodGet:
+		s.getCheckpoint(w, r, id, strings.TrimPrefix(action, "checkpoints/"))
+
+	case strings.HasPrefix(action, "checkpoints/") && r.Method == http.MethodPatch:
+		s.updateCheckpoint(w, r, id, strings.TrimPrefix(action, "checkpoints/"))
+
+	case action == "resume" && r.Method == http.MethodPost:
+		s.resumeRun(w, r, id)
+
+	case action == "" || action == "events" || action == "cancel" ||
+		action == "approvals" || strings.HasPrefix(action, "approvals/") ||
+		action == "checkpoints" || strings.HasPrefix(action, "checkpoints/") || action == "resume":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// resolveApproval applies an approval.Decision posted as JSON:
+//
+//	{"decision": "approve" | "deny" | "edit", "arguments": {...}, "reason": "..."}
+func (s *RunsServer) resolveApproval(w http.ResponseWriter, r *http.Request, runID, toolCallID string) {
+	var d approval.Decision
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.runnerService.ResolveApproval(runID, toolCallID, d); err != nil {
+		writeApprovalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"run_id": runID, "tool_call_id": toolCallID, "decision": d.Decision})
+}
+
+func writeApprovalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, runnersvc.ErrRunNotFound):
+		http.Error(w, "run not found or already finished", http.StatusNotFound)
+	case errors.Is(err, approval.ErrNotPending):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, approval.ErrInvalidDecision):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *RunsServer) startRun(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.AgentID == "" {
+		http.Error(w, "agent_id is required", http.StatusBadRequest)
+		return
+	}
+	if req.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		req.UserID = "anonymous"
+	}
+	if req.SessionID == "" {
+		req.SessionID = uuid.NewString()
+	}
+
+	// The run outlives the request; keep its values but not its cancellation.
+	ctx := context.WithoutCancel(r.Context())
+	run := runstore.Run{
+		ID:        uuid.NewString(),
+		AgentID:   req.AgentID,
+		UserID:    req.UserID,
+		SessionID: req.SessionID,
+		Status:    runstore.StatusRunning,
+		CreatedAt: time.Now(),
+	}
+	s.detach(ctx, w, run, func() (<-chan *event.Event, error) {
+		return s.runnerService.Run(ctx, req.AgentID, req.UserID, req.SessionID,
+			model.NewUserMessage(req.Message), agent.WithRequestID(run.ID))
+	})
+}
+
+// detach records run in the store, starts it and answers 202 with the
+// location of its events.
+func (s *RunsServer) detach(ctx context.Context, w http.ResponseWriter, run runstore.Run, start func() (<-chan *event.Event, error)) {
+	if err := s.store.CreateRun(ctx, run); err != nil {
+		log.Printf("create run failed: %v", err)
+		http.Error(w, "create run failed", http.StatusInternalServerError)
+		return
+	}
+
+	eventCh, err := start()
+	if err != nil {
+		log.Printf("runner service failed: %v", err)
+		if finishErr := s.store.FinishRun(ctx, run.ID, runstore.StatusFailed, err.Error()); finishErr != nil {
+			log.Printf("finish run %s failed: %v", run.ID, finishErr)
+		}
+		var budgetErr *usage.BudgetExceededError
+		switch {
+		case errors.As(err, &budgetErr):
+			http.Error(w, budgetErr.Error(), http.StatusTooManyRequests)
+		case errors.Is(err, runnersvc.ErrBuildAgent):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	go s.record(ctx, run.ID, eventCh)
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"id":         run.ID,
+		"status":     run.Status,
+		"session_id": run.SessionID,
+		"events_url": "/runs/" + run.ID + "/events",
+	})
+}
+
+// record stores the UI events of a detached run and its final status.
+func (s *RunsServer) record(ctx context.Context, runID string, events <-chan *event.Event) {
+	var (
+		seq    int64
+		status = runstore.StatusFailed
+		errMsg = "run ended before completion"
+		failed bool // an error event was seen
+	)
+	for ev := range events {
+		uiEv := BuildUIEvent(ev)
+		if uiEv == nil {
+			continue
+		}
+		// The final status follows every event, even one that could not
+		// be stored.
+		switch {
+		case uiEv.Type == runnersvc.ObjectTypeRunCancelled:
+			status, errMsg = runstore.StatusCancelled, ""
+		case uiEv.RunnerCompletion && uiEv.Error == nil:
+			// The runner completes runs whose agent ended with an error too.
+			if !failed {
+				status, errMsg = runstore.StatusCompleted, ""
+			}
+		case uiEv.Error != nil:
+			failed = true
+			status, errMsg = runstore.StatusFailed, uiEv.Error.Message
+		}
+
+		data, err := json.Marshal(uiEv)
+		if err != nil {
+			log.Printf("marshal run event failed: %v", err)
+			continue
+		}
+		if err := s.store.AppendEvent(ctx, runID, runstore.Event{Seq: seq + 1, Data: data}); err != nil {
+			log.Printf("store event of run %s failed: %v", runID, err)
+			continue
+		}
+		seq++
+		s.notifier.Notify(runID)
+	}
+
+	if err := s.store.FinishRun(ctx, runID, status, errMsg); err != nil {
+		log.Printf("finish run %s failed: %v", runID, err)
+	}
+	s.notifier.Notify(runID)
+}
+
+// streamEvents writes the stored events of a run as SSE, starting after
+// ?offset=N (or the Last-Event-ID header of a reconnecting EventSource), and
+// follows new events until the run has finished. Each frame carries the
+// event's sequence number as its id. A final "run.finished" frame holds the
+// run status.
+func (s *RunsServer) streamEvents(w http.ResponseWriter, r *http.Request, id string) {
+	var after int64
+	raw := r.URL.Query().Get("offset")
+	if raw == "" {
+		raw = r.Header.Get("Last-Event-ID")
+	}
+	if raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+
+	ctx := r.Context()
+	if _, err := s.store.GetRun(ctx, id); err != nil {
+		writeRunError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	setSSEHeaders(w)
+	flusher.Flush()
+
+	for {
+		events, err := s.store.Events(ctx, id, after, runEventsBatch)
+		if err != nil {
+			log.Printf("read events of run %s failed: %v", id, err)
+			return
+		}
+		for _, ev := range events {
+			if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", ev.Seq, ev.Data); err != nil {
+				log.Printf("write sse event failed: %v", err)
+				return
+			}
+			after = ev.Seq
+		}
+		flusher.Flush()
+		if len(events) == runEventsBatch {
+			continue
+		}
+
+		run, err := s.store.GetRun(ctx, id)
+		if err != nil {
+			log.Printf("read run %s failed: %v", id, err)
+			return
+		}
+		if run.Finished() && after >= run.EventCount {
+			data, _ := json.Marshal(map[string]any{"type": "run.finished", "run": run})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+
+		// Subscribe, then check again so an event stored in between is not
+		// missed.
+		wake, release := s.notifier.Wait(id)
+		if run, err = s.store.GetRun(ctx, id); err == nil && (run.Finished() || run.EventCount > after) {
+			release()
+			continue
+		}
+		select {
+		case <-wake:
+		case <-time.After(runEventsPoll):
+		case <-ctx.Done():
+		}
+		release()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func writeRunError(w http.ResponseWriter, err error) {
+	if errors.Is(err, runstore.ErrNotFound) {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("run store failed: %v", err)
+	http.Error(w, "run store failed", http.StatusInternalServerError)
+}
@@ -0,0 +1,221 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"helixrun/internal/runstore"
+
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// runFrame is one frame of a run event stream.
+type runFrame struct {
+	id   string
+	data map[string]any
+}
+
+func readRunFrames(t *testing.T, sc *bufio.Scanner, n int) []runFrame {
+	t.Helper()
+	var (
+		frames []runFrame
+		cur    runFrame
+	)
+	for len(frames) < n && sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			cur.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cur.data); err != nil {
+				t.Fatalf("decode frame %q: %v", line, err)
+			}
+		case line == "" && cur.data != nil:
+			frames = append(frames, cur)
+			cur = runFrame{}
+		}
+	}
+	return frames
+}
+
+// storedRun creates a run in store with n events whose content is "e<seq>".
+func storedRun(t *testing.T, store runstore.Store, id string, n int) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.CreateRun(ctx, runstore.Run{ID: id, Status: runstore.StatusRunning, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	for i := 1; i <= n; i++ {
+		appendRunEvent(t, store, id, int64(i))
+	}
+}
+
+func appendRunEvent(t *testing.T, store runstore.Store, id string, seq int64) {
+	t.Helper()
+	data, _ := json.Marshal(UIEvent{Type: model.ObjectTypeChatCompletion, Content: fmt.Sprintf("e%d", seq)})
+	if err := store.AppendEvent(context.Background(), id, runstore.Event{Seq: seq, Data: data}); err != nil {
+		t.Fatalf("AppendEvent: %v", err)
+	}
+}
+
+func TestStreamEventsReplaysFromOffset(t *testing.T) {
+	store := runstore.NewMemoryStore()
+	storedRun(t, store, "r1", 3)
+	if err := store.FinishRun(context.Background(), "r1", runstore.StatusCompleted, ""); err != nil {
+		t.Fatal(err)
+	}
+	s := NewRunsServer(nil, store)
+
+	tests := []struct {
+		name, query, lastEventID string
+		wantIDs                  []string
+	}{
+		{"from the start", "", "", []string{"1", "2", "3"}},
+		{"offset", "?offset=1", "", []string{"2", "3"}},
+		{"Last-Event-ID", "", "2", []string{"3"}},
+		{"offset wins over Last-Event-ID", "?offset=0", "2", []string{"1", "2", "3"}},
+		{"offset at the end", "?offset=3", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/runs/r1/events"+tt.query, nil)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := httptest.NewRecorder()
+			s.RunHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+
+			frames := readRunFrames(t, bufio.NewScanner(rec.Body), 100)
+			if len(frames) != len(tt.wantIDs)+1 {
+				t.Fatalf("got %d frames, want %d events and run.finished", len(frames), len(tt.wantIDs))
+			}
+			for i, id := range tt.wantIDs {
+				if frames[i].id != id || frames[i].data["content"] != "e"+id {
+					t.Errorf("frame %d = %+v, want event %s", i, frames[i], id)
+				}
+			}
+			last := frames[len(frames)-1]
+			if last.data["type"] != "run.finished" || last.id != "" {
+				t.Fatalf("last frame = %+v, want run.finished without id", last)
+			}
+			if run := last.data["run"].(map[string]any); run["status"] != runstore.StatusCompleted || run["event_count"] != 3.0 {
+				t.Errorf("finished run = %v", run)
+			}
+		})
+	}
+}
+
+func TestStreamEventsRejectsRequests(t *testing.T) {
+	store := runstore.NewMemoryStore()
+	storedRun(t, store, "r1", 1)
+	s := NewRunsServer(nil, store)
+
+	tests := []struct {
+		name, path, lastEventID string
+		status                  int
+	}{
+		{"negative offset", "/runs/r1/events?offset=-1", "", http.StatusBadRequest},
+		{"non-numeric offset", "/runs/r1/events?offset=abc", "", http.StatusBadRequest},
+		{"bad Last-Event-ID", "/runs/r1/events", "x", http.StatusBadRequest},
+		{"unknown run", "/runs/nope/events", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.lastEventID != "" {
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+		}
+		rec := httptest.NewRecorder()
+		s.RunHandler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestStreamEventsFollowsLiveRun(t *testing.T) {
+	store := runstore.NewMemoryStore()
+	storedRun(t, store, "r1", 2)
+	s := NewRunsServer(nil, store)
+	srv := httptest.NewServer(http.HandlerFunc(s.RunHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/runs/r1/events?offset=1")
+	if err != nil {
+		t.Fatalf("GET events: %v", err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+
+	if frames := readRunFrames(t, sc, 1); len(frames) != 1 || frames[0].id != "2" {
+		t.Fatalf("replayed frames = %+v, want event 2", frames)
+	}
+
+	appendRunEvent(t, store, "r1", 3)
+	s.notifier.Notify("r1")
+	if frames := readRunFrames(t, sc, 1); len(frames) != 1 || frames[0].id != "3" {
+		t.Fatalf("live frames = %+v, want event 3", frames)
+	}
+
+	store.FinishRun(context.Background(), "r1", runstore.StatusCancelled, "")
+	s.notifier.Notify("r1")
+	frames := readRunFrames(t, sc, 1)
+	if len(frames) != 1 || frames[0].data["type"] != "run.finished" {
+		t.Fatalf("final frames = %+v, want run.finished", frames)
+	}
+	if sc.Scan() {
+		t.Errorf("stream continues after run.finished: %q", sc.Text())
+	}
+}
+
+// failingStore fails AppendEvent for events with an error.
+type failingStore struct {
+	*runstore.MemoryStore
+}
+
+func (s failingStore) AppendEvent(ctx context.Context, runID string, ev runstore.Event) error {
+	if strings.Contains(string(ev.Data), `"error"`) {
+		return errors.New("disk full")
+	}
+	return s.MemoryStore.AppendEvent(ctx, runID, ev)
+}
+
+func TestRecordFinishesRunWhenAnEventIsNotStored(t *testing.T) {
+	store := failingStore{runstore.NewMemoryStore()}
+	storedRun(t, store, "r1", 0)
+	s := NewRunsServer(nil, store)
+
+	events := make(chan *event.Event, 3)
+	events <- event.NewResponseEvent("inv", "agent", &model.Response{
+		Object:  model.ObjectTypeChatCompletion,
+		Choices: []model.Choice{{Message: model.NewAssistantMessage("partial answer")}},
+	})
+	events <- event.NewErrorEvent("inv", "agent", "api_error", "upstream failed")
+	events <- event.NewResponseEvent("inv", "runner", &model.Response{Object: model.ObjectTypeRunnerCompletion, Done: true})
+	close(events)
+	s.record(context.Background(), "r1", events)
+
+	run, err := store.GetRun(context.Background(), "r1")
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.Status != runstore.StatusFailed || run.Error != "upstream failed" {
+		t.Errorf("run = %s %q, want failed with the unstored error", run.Status, run.Error)
+	}
+	// The failed write leaves no gap in the sequence.
+	stored, _ := store.Events(context.Background(), "r1", 0, 0)
+	if len(stored) != 2 || stored[0].Seq != 1 || stored[1].Seq != 2 || run.EventCount != 2 {
+		t.Errorf("stored %d events (count %d), want seq 1 and 2", len(stored), run.EventCount)
+	}
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sseBufferSize is how many recent frames are kept per /chat run for
+	// clients that reconnect with Last-Event-ID.
+	sseBufferSize = 1000
+	// sseResumeGrace is how long a /chat run keeps going without a
+	// connected client before it is cancelled.
+	sseResumeGrace = 30 * time.Second
+	// sseBufferRetention is how long the frames of a finished run stay
+	// available for replay.
+	sseBufferRetention = 5 * time.Minute
+)
+
+// sseFrame is one buffered SSE data frame.
+type sseFrame struct {
+	seq  int64
+	data []byte
+}
+
+// sseEventID is the SSE id of a /chat frame: "<run-id>:<seq>", so the
+// Last-Event-ID header alone identifies the run to resume.
+func sseEventID(runID string, seq int64) string {
+	return runID + ":" + strconv.FormatInt(seq, 10)
+}
+
+// parseSSEEventID parses an id written by sseEventID.
+func parseSSEEventID(id string) (runID string, seq int64, err error) {
+	i := strings.LastIndexByte(id, ':')
+	if i <= 0 {
+		return "", 0, fmt.Errorf("want <run-id>:<seq>")
+	}
+	seq, err = strconv.ParseInt(id[i+1:], 10, 64)
+	if err != nil || seq < 0 {
+		return "", 0, fmt.Errorf("want <run-id>:<seq>")
+	}
+	return id[:i], seq, nil
+}
+
+// sseOwner is the user and session that started a /chat run. Only they
+// may resume its stream.
+type sseOwner struct {
+	userID    string
+	sessionID string
+}
+
+// sseBuffer holds the most recent frames of one run and wakes the
+// connected clients when a frame is added.
+type sseBuffer struct {
+	owner sseOwner // set at creation, never changed
+
+	mu      sync.Mutex
+	frames  []sseFrame // oldest first, at most sseBufferSize
+	last    int64      // seq of the newest frame
+	done    bool
+	wake    chan struct{} // closed and replaced on every change
+	clients int
+	left    time.Time // when the last client detached
+}
+
+func newSSEBuffer(owner sseOwner) *sseBuffer {
+	return &sseBuffer{owner: owner, wake: make(chan struct{})}
+}
+
+// add appends a frame and returns its seq.
+func (b *sseBuffer) add(data []byte) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.last++
+	b.frames = append(b.frames, sseFrame{seq: b.last, data: data})
+	if len(b.frames) > sseBufferSize {
+		b.frames = append(b.frames[:0:0], b.frames[len(b.frames)-sseBufferSize:]...)
+	}
+	b.notify()
+	return b.last
+}
+
+// finish marks the run as ended.
+func (b *sseBuffer) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.notify()
+}
+
+func (b *sseBuffer) notify() {
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// since returns the buffered frames after seq. missed is the number of
+// frames after seq that are no longer buffered. wake is closed on the next
+// change; done reports whether the run has ended.
+func (b *sseBuffer) since(seq int64) (frames []sseFrame, missed int64, done bool, wake <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.frames) > 0 {
+		if oldest := b.frames[0].seq; seq < oldest-1 {
+			missed = oldest - 1 - seq
+		}
+		for _, f := range b.frames {
+			if f.seq > seq {
+				frames = append(frames, f)
+			}
+		}
+	}
+	return frames, missed, b.done, b.wake
+}
+
+// attach and detach count the connected clients. detach reports whether
+// the last client left a run that is still going.
+func (b *sseBuffer) attach() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients++
+}
+
+func (b *sseBuffer) detach() (orphaned bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clients--
+	if b.clients > 0 || b.done {
+		return false
+	}
+	b.left = time.Now()
+	return true
+}
+
+// orphanedFor reports whether the run is still going and has had no client
+// for at least d.
+func (b *sseBuffer) orphanedFor(d time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.clients == 0 && !b.done && time.Since(b.left) >= d
+}
+
+// sseBuffers indexes the buffers of /chat runs by run ID.
+type sseBuffers struct {
+	mu sync.Mutex
+	m  map[string]*sseBuffer
+}
+
+func (bs *sseBuffers) create(runID string, owner sseOwner) *sseBuffer {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if bs.m == nil {
+		bs.m = make(map[string]*sseBuffer)
+	}
+	b := newSSEBuffer(owner)
+	bs.m[runID] = b
+	return b
+}
+
+// get returns the buffer of runID if it belongs to owner.
+func (bs *sseBuffers) get(runID string, owner sseOwner) *sseBuffer {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.m[runID]
+	if b == nil || b.owner != owner {
+		return nil
+	}
+	return b
+}
+
+func (bs *sseBuffers) remove(runID string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	delete(bs.m, runID)
+}
@@ -0,0 +1,180 @@
+package http
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEEventID(t *testing.T) {
+	id := sseEventID("run:with:colons", 42)
+	runID, seq, err := parseSSEEventID(id)
+	if err != nil || runID != "run:with:colons" || seq != 42 {
+		t.Errorf("parse %q = %q, %d, %v", id, runID, seq, err)
+	}
+
+	for _, bad := range []string{"", "run", ":1", "run:", "run:x", "run:-1"} {
+		if _, _, err := parseSSEEventID(bad); err == nil {
+			t.Errorf("parse %q succeeded", bad)
+		}
+	}
+}
+
+func TestSSEBufferSince(t *testing.T) {
+	b := newSSEBuffer(sseOwner{})
+	for i := 1; i <= 3; i++ {
+		if seq := b.add([]byte(fmt.Sprint(i))); seq != int64(i) {
+			t.Fatalf("add %d returned seq %d", i, seq)
+		}
+	}
+
+	frames, missed, done, wake := b.since(1)
+	if len(frames) != 2 || frames[0].seq != 2 || frames[1].seq != 3 || missed != 0 || done {
+		t.Fatalf("since(1) = %v, missed %d, done %v", frames, missed, done)
+	}
+	if frames, _, _, _ := b.since(3); len(frames) != 0 {
+		t.Errorf("since(3) = %v, want nothing", frames)
+	}
+
+	b.finish()
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("finish did not wake the reader")
+	}
+	if _, _, done, _ := b.since(3); !done {
+		t.Error("since after finish is not done")
+	}
+}
+
+func TestSSEBufferDropsOldFrames(t *testing.T) {
+	b := newSSEBuffer(sseOwner{})
+	for i := 0; i < sseBufferSize+10; i++ {
+		b.add([]byte("x"))
+	}
+
+	frames, missed, _, _ := b.since(0)
+	if len(frames) != sseBufferSize || frames[0].seq != 11 {
+		t.Fatalf("since(0) returned %d frames from seq %d, want %d from 11", len(frames), frames[0].seq, sseBufferSize)
+	}
+	if missed != 10 {
+		t.Errorf("missed = %d, want 10", missed)
+	}
+	if _, missed, _, _ := b.since(10); missed != 0 {
+		t.Errorf("since(10) missed = %d, want 0", missed)
+	}
+}
+
+func TestSSEBufferOrphaned(t *testing.T) {
+	b := newSSEBuffer(sseOwner{})
+	b.attach()
+	b.attach()
+	if b.detach() {
+		t.Error("detach with a client left reports orphaned")
+	}
+	if !b.detach() {
+		t.Error("detach of the last client does not report orphaned")
+	}
+	if !b.orphanedFor(0) {
+		t.Error("run without clients is not orphaned")
+	}
+	if b.orphanedFor(time.Hour) {
+		t.Error("run is orphaned before the grace period")
+	}
+
+	b.attach()
+	if b.orphanedFor(0) {
+		t.Error("run with a client is orphaned")
+	}
+	b.finish()
+	if b.detach() || b.orphanedFor(0) {
+		t.Error("finished run is orphaned")
+	}
+}
+
+func TestSSEBuffersRequireOwner(t *testing.T) {
+	var bs sseBuffers
+	owner := sseOwner{userID: "alice", sessionID: "s1"}
+	b := bs.create("r1", owner)
+
+	if got := bs.get("r1", owner); got != b {
+		t.Error("owner does not get its buffer")
+	}
+	for _, other := range []sseOwner{
+		{userID: "mallory", sessionID: "s1"},
+		{userID: "alice", sessionID: "s2"},
+		{userID: "alice"},
+		{},
+	} {
+		if bs.get("r1", other) != nil {
+			t.Errorf("%+v gets the buffer of %+v", other, owner)
+		}
+	}
+
+	bs.remove("r1")
+	if bs.get("r1", owner) != nil {
+		t.Error("removed buffer is still returned")
+	}
+}
+
+func TestChatResumeRequiresOwner(t *testing.T) {
+	s := NewChatServer(nil)
+	buf := s.buffers.create("r1", sseOwner{userID: "alice", sessionID: "s1"})
+	buf.add([]byte(`{"content":"one"}`))
+	buf.add([]byte(`{"content":"two"}`))
+	buf.finish()
+
+	tests := []struct {
+		name, body string
+		status     int
+	}{
+		{"owner", `{"user_id":"alice","session_id":"s1"}`, http.StatusOK},
+		{"other user", `{"user_id":"mallory","session_id":"s1"}`, http.StatusNotFound},
+		{"other session", `{"user_id":"alice","session_id":"s2"}`, http.StatusNotFound},
+		{"no body", ``, http.StatusNotFound},
+		{"invalid body", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/chat", strings.NewReader(tt.body))
+			req.Header.Set("Last-Event-ID", sseEventID("r1", 1))
+			rec := httptest.NewRecorder()
+			s.ChatHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				if strings.Contains(rec.Body.String(), "content") {
+					t.Errorf("rejected resume got frames: %s", rec.Body)
+				}
+				return
+			}
+
+			frames := readRunFrames(t, bufio.NewScanner(rec.Body), 10)
+			if len(frames) != 1 || frames[0].id != "r1:2" || frames[0].data["content"] != "two" {
+				t.Errorf("frames = %+v, want only frame r1:2", frames)
+			}
+			if got := rec.Header().Get("X-Run-ID"); got != "r1" {
+				t.Errorf("X-Run-ID = %q", got)
+			}
+		})
+	}
+}
+
+func TestChatResumeAnonymous(t *testing.T) {
+	s := NewChatServer(nil)
+	buf := s.buffers.create("r1", sseOwner{userID: "anonymous"})
+	buf.finish()
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	req.Header.Set("Last-Event-ID", sseEventID("r1", 0))
+	rec := httptest.NewRecorder()
+	s.ChatHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200 for a run started without user and session", rec.Code)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"time"
 
+	"helixrun/internal/approval"
+	"helixrun/internal/checkpoint"
+
 	"trpc.group/trpc-go/trpc-agent-go/event"
 	"trpc.group/trpc-go/trpc-agent-go/graph"
 	"trpc.group/trpc-go/trpc-agent-go/model"
@@ -41,6 +44,12 @@ type UIEvent struct {
 	ChannelMetadata *graph.ChannelUpdateMetadata  `json:"channelMetadata,omitempty"`
 	StateMetadata   *graph.StateUpdateMetadata    `json:"stateMetadata,omitempty"`
 
+	// Tool approval (tool.approval_required / tool.approval_resolved)
+	Approval *approval.Request `json:"approval,omitempty"`
+
+	// Graph interrupt (graph.interrupted): checkpoint om vanaf te hervatten
+	Interrupt *checkpoint.Interrupt `json:"interrupt,omitempty"`
+
 	// Ruwe error info (LLM/tool/flow error)
 	Error *model.ResponseError `json:"error,omitempty"`
 
@@ -151,6 +160,22 @@ func BuildUIEvent(ev *event.Event) *UIEvent {
 				ui.StateMetadata = &md
 			}
 		}
+
+		// Approval request (_approval) – tool call die wacht op een beslissing.
+		if b, ok := ev.StateDelta[approval.MetadataKey]; ok {
+			var req approval.Request
+			if err := json.Unmarshal(b, &req); err == nil {
+				ui.Approval = &req
+			}
+		}
+
+		// Interrupt (_checkpoint) – graph run gepauzeerd bij een interrupt_before node.
+		if b, ok := ev.StateDelta[checkpoint.MetadataKey]; ok {
+			var in checkpoint.Interrupt
+			if err := json.Unmarshal(b, &in); err == nil {
+				ui.Interrupt = &in
+			}
+		}
 	}
 
 	return ui
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	pgstore "helixrun/internal/store/postgres"
+	"helixrun/internal/usage"
+)
+
+// UsageServer serves aggregated usage under /api/usage.
+type UsageServer struct {
+	usage   *pgstore.UsageRepository
+	budgets []usage.Budget
+}
+
+// NewUsageServer creates a UsageServer. budgets are echoed in the summary
+// so clients can show spend against them.
+func NewUsageServer(repo *pgstore.UsageRepository, budgets []usage.Budget) *UsageServer {
+	return &UsageServer{usage: repo, budgets: budgets}
+}
+
+// SummaryHandler handles GET /api/usage/summary.
+//
+// Query parameters: group_by (comma-separated: day, provider, model, agent,
+// user), from and to (RFC3339 or YYYY-MM-DD), provider, model, agent_id and
+// user_id.
+func (s *UsageServer) SummaryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := pgstore.UsageSummaryFilter{
+		Provider: q.Get("provider"),
+		Model:    q.Get("model"),
+		AgentID:  q.Get("agent_id"),
+		UserID:   q.Get("user_id"),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(q.Get("from")); err != nil {
+		http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTimeParam(q.Get("to")); err != nil {
+		http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, g := range strings.Split(q.Get("group_by"), ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || seen[g] {
+			continue
+		}
+		switch g {
+		case pgstore.UsageGroupDay, pgstore.UsageGroupProvider, pgstore.UsageGroupModel,
+			pgstore.UsageGroupAgent, pgstore.UsageGroupUser:
+		default:
+			http.Error(w, fmt.Sprintf("invalid group_by %q (want day, provider, model, agent or user)", g), http.StatusBadRequest)
+			return
+		}
+		seen[g] = true
+		filter.GroupBy = append(filter.GroupBy, g)
+	}
+
+	rows, err := s.usage.Summary(r.Context(), filter)
+	if err != nil {
+		log.Printf("summarize usage failed: %v", err)
+		http.Error(w, "summarize usage failed", http.StatusInternalServerError)
+		return
+	}
+	budgets := s.budgets
+	if budgets == nil {
+		budgets = []usage.Budget{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"group_by": filter.GroupBy,
+		"rows":     rows,
+		"budgets":  budgets,
+	})
+}
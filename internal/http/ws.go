@@ -0,0 +1,299 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/websocket"
+
+	"helixrun/internal/approval"
+	runnersvc "helixrun/internal/runner"
+	"helixrun/internal/usage"
+
+	"trpc.group/trpc-go/trpc-agent-go/agent"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+const (
+	// wsMaxMessage bounds the size of one client message.
+	wsMaxMessage = 1 << 20
+	// wsWriteTimeout bounds how long a frame may take to reach a slow client.
+	wsWriteTimeout = 10 * time.Second
+)
+
+// WebSocket message types.
+const (
+	wsTypeStart    = "start"
+	wsTypeInput    = "input"
+	wsTypeCancel   = "cancel"
+	wsTypeApproval = "approval"
+	wsTypePing     = "ping"
+
+	wsTypePong        = "pong"
+	wsTypeRunStarted  = "run.started"
+	wsTypeEvent       = "event"
+	wsTypeRunFinished = "run.finished"
+	wsTypeError       = "error"
+)
+
+// WSServer serves /ws, a WebSocket over which a client starts, follows and
+// controls any number of concurrent runs. Runs started on a connection are
+// cancelled when it closes.
+type WSServer struct {
+	runnerService  *runnersvc.Service
+	allowedOrigins []string
+}
+
+// NewWSServer creates a WSServer.
+func NewWSServer(svc *runnersvc.Service) *WSServer {
+	return &WSServer{runnerService: svc}
+}
+
+// WithAllowedOrigins sets the origins (e.g. "https://app.example.com")
+// that may connect besides the server's own.
+func (s *WSServer) WithAllowedOrigins(origins ...string) *WSServer {
+	s.allowedOrigins = origins
+	return s
+}
+
+// Handler returns the /ws handler. Browsers must connect from the server's
+// own origin or an allowed one, since a WebSocket is not covered by the
+// same-origin policy; clients that send no Origin are accepted.
+func (s *WSServer) Handler() http.Handler {
+	return websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error { return s.checkOrigin(r) },
+		Handler:   s.serve,
+	}
+}
+
+// checkOrigin accepts a missing Origin, one whose host is the request's
+// Host and the allowed origins.
+func (s *WSServer) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range s.allowedOrigins {
+		if strings.EqualFold(strings.TrimRight(strings.TrimSpace(allowed), "/"), u.Scheme+"://"+u.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// wsRequest is a message from the client. Type selects the fields used:
+//
+//	start    - agent_id, message, user_id, session_id (as for /chat)
+//	input    - run_id, message: a follow-up in the session of that run
+//	cancel   - run_id
+//	approval - run_id, tool_call_id, decision, arguments, reason
+//	ping     - none
+//
+// Ref is echoed in the reply so a client can match run.started and error
+// messages to its request.
+type wsRequest struct {
+	Type string `json:"type"`
+	Ref  string `json:"ref,omitempty"`
+	ChatRequest
+	RunID      string          `json:"run_id,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	Decision   string          `json:"decision,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Reason     string          `json:"reason,omitempty"`
+}
+
+// wsMessage is a message to the client. Events of a run are sent as
+// "event" messages carrying the same UIEvent as /chat.
+type wsMessage struct {
+	Type      string   `json:"type"`
+	Ref       string   `json:"ref,omitempty"`
+	RunID     string   `json:"run_id,omitempty"`
+	SessionID string   `json:"session_id,omitempty"`
+	Event     *UIEvent `json:"event,omitempty"`
+	Error     *wsError `json:"error,omitempty"`
+}
+
+type wsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// wsConn is the state of one connection.
+type wsConn struct {
+	svc *runnersvc.Service
+	ws  *websocket.Conn
+	ctx context.Context
+
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	runs map[string]ChatRequest // runs started here; only these can be controlled
+	wg   sync.WaitGroup
+}
+
+func (s *WSServer) serve(ws *websocket.Conn) {
+	ws.MaxPayloadBytes = wsMaxMessage
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ws.Request().Context()))
+	c := &wsConn{svc: s.runnerService, ws: ws, ctx: ctx, runs: make(map[string]ChatRequest)}
+	defer c.wg.Wait()
+	defer cancel()
+
+	for {
+		var req wsRequest
+		if err := websocket.JSON.Receive(ws, &req); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.sendError("", "", "bad_request", "invalid JSON: "+err.Error())
+				continue
+			}
+			if !errors.Is(err, io.EOF) {
+				log.Printf("ws receive failed: %v", err)
+			}
+			return
+		}
+		c.handle(req)
+	}
+}
+
+func (c *wsConn) handle(req wsRequest) {
+	switch req.Type {
+	case wsTypePing:
+		c.send(wsMessage{Type: wsTypePong, Ref: req.Ref})
+
+	case wsTypeStart:
+		if req.AgentID == "" || req.Message == "" {
+			c.sendError(req.Ref, "", "bad_request", "agent_id and message are required")
+			return
+		}
+		c.start(req.Ref, req.ChatRequest)
+
+	case wsTypeInput:
+		prev, ok := c.run(req)
+		if !ok {
+			return
+		}
+		if req.Message == "" {
+			c.sendError(req.Ref, req.RunID, "bad_request", "message is required")
+			return
+		}
+		prev.Message = req.Message
+		c.start(req.Ref, prev)
+
+	case wsTypeCancel:
+		if _, ok := c.run(req); !ok {
+			return
+		}
+		if err := c.svc.Cancel(req.RunID); err != nil {
+			c.sendError(req.Ref, req.RunID, "not_found", "run not found or already finished")
+		}
+
+	case wsTypeApproval:
+		if _, ok := c.run(req); !ok {
+			return
+		}
+		d := approval.Decision{Decision: req.Decision, Arguments: req.Arguments, Reason: req.Reason}
+		if err := c.svc.ResolveApproval(req.RunID, req.ToolCallID, d); err != nil {
+			code := "not_found"
+			if errors.Is(err, approval.ErrInvalidDecision) {
+				code = "bad_request"
+			}
+			c.sendError(req.Ref, req.RunID, code, err.Error())
+		}
+
+	default:
+		c.sendError(req.Ref, req.RunID, "bad_request", "unknown message type "+req.Type)
+	}
+}
+
+// run returns the request of the run req refers to. Connections may only
+// control their own runs; for other run IDs an error is sent.
+func (c *wsConn) run(req wsRequest) (ChatRequest, bool) {
+	c.mu.Lock()
+	prev, ok := c.runs[req.RunID]
+	c.mu.Unlock()
+	if !ok {
+		c.sendError(req.Ref, req.RunID, "not_found", "run was not started on this connection")
+	}
+	return prev, ok
+}
+
+// start runs req and forwards its events until the run ends.
+func (c *wsConn) start(ref string, req ChatRequest) {
+	if req.UserID == "" {
+		req.UserID = "anonymous"
+	}
+	if req.SessionID == "" {
+		req.SessionID = uuid.NewString()
+	}
+
+	runID := uuid.NewString()
+	eventCh, err := c.svc.Run(c.ctx, req.AgentID, req.UserID, req.SessionID,
+		model.NewUserMessage(req.Message), agent.WithRequestID(runID))
+	if err != nil {
+		log.Printf("runner service failed: %v", err)
+		var budgetErr *usage.BudgetExceededError
+		switch {
+		case errors.As(err, &budgetErr):
+			c.sendError(ref, "", "budget_exceeded", budgetErr.Error())
+		case errors.Is(err, runnersvc.ErrBuildAgent):
+			c.sendError(ref, "", "bad_request", err.Error())
+		default:
+			c.sendError(ref, "", "internal", err.Error())
+		}
+		return
+	}
+
+	c.mu.Lock()
+	c.runs[runID] = req
+	c.mu.Unlock()
+	c.send(wsMessage{Type: wsTypeRunStarted, Ref: ref, RunID: runID, SessionID: req.SessionID})
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for ev := range eventCh {
+			if uiEv := BuildUIEvent(ev); uiEv != nil {
+				c.send(wsMessage{Type: wsTypeEvent, RunID: runID, Event: uiEv})
+			}
+		}
+		c.send(wsMessage{Type: wsTypeRunFinished, RunID: runID})
+	}()
+}
+
+func (c *wsConn) sendError(ref, runID, code, msg string) {
+	c.send(wsMessage{Type: wsTypeError, Ref: ref, RunID: runID, Error: &wsError{Code: code, Message: msg}})
+}
+
+// send writes one message. A failed write closes the connection, which ends
+// the read loop and cancels the runs.
+func (c *wsConn) send(msg wsMessage) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.ctx.Err() != nil {
+		return
+	}
+	_ = c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := websocket.JSON.Send(c.ws, msg); err != nil {
+		log.Printf("ws send failed: %v", err)
+		c.ws.Close()
+	}
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	runnersvc "helixrun/internal/runner"
+)
+
+func TestWSCheckOrigin(t *testing.T) {
+	s := NewWSServer(nil).WithAllowedOrigins("https://app.example.com/", " http://localhost:3000")
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://helixrun.internal:8080", true}, // the request's own host
+		{"https://HELIXRUN.internal:8080", true},
+		{"https://app.example.com", true},
+		{"http://localhost:3000", true},
+		{"http://app.example.com", false}, // scheme must match
+		{"https://app.example.com:8443", false},
+		{"https://evil.example.com", false},
+		{"https://helixrun.internal", false}, // port must match
+		{"https://helixrun.internal:8080.evil.com", false},
+		{"null", false},
+		{"::", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://helixrun.internal:8080/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if err := s.checkOrigin(r); (err == nil) != tt.ok {
+			t.Errorf("origin %q: error = %v, want accepted %v", tt.origin, err, tt.ok)
+		}
+	}
+}
+
+func TestWSHandshakeRejectsForeignOrigin(t *testing.T) {
+	srv := httptest.NewServer(NewWSServer(nil).Handler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	if _, err := websocket.Dial(wsURL, "", "https://evil.example.com"); err == nil {
+		t.Fatal("dial from a foreign origin succeeded")
+	}
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial from the server's origin: %v", err)
+	}
+	ws.Close()
+}
+
+// wsClient is a test connection to a WSServer.
+type wsClient struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+func dialWS(t *testing.T, srv *httptest.Server) *wsClient {
+	t.Helper()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &wsClient{t: t, ws: ws}
+}
+
+func (c *wsClient) send(req wsRequest) {
+	c.t.Helper()
+	if err := websocket.JSON.Send(c.ws, req); err != nil {
+		c.t.Fatalf("send: %v", err)
+	}
+}
+
+// until returns the next message of type typ, skipping others.
+func (c *wsClient) until(typ string) wsMessage {
+	c.t.Helper()
+	_ = c.ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg wsMessage
+		if err := websocket.JSON.Receive(c.ws, &msg); err != nil {
+			c.t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+	}
+}
+
+func TestWSRunsBelongToTheirConnection(t *testing.T) {
+	up := upstreamOpenAI(t, "Hi")
+	reg := testRegistry(t, up.URL, map[string]string{"agent": `,"stream":true`})
+	srv := httptest.NewServer(NewWSServer(runnersvc.NewService(reg)).Handler())
+	defer srv.Close()
+
+	owner, other := dialWS(t, srv), dialWS(t, srv)
+
+	owner.send(wsRequest{Type: wsTypeStart, Ref: "s1", ChatRequest: ChatRequest{AgentID: "agent", Message: "hi"}})
+	started := owner.until(wsTypeRunStarted)
+	if started.Ref != "s1" || started.RunID == "" || started.SessionID == "" {
+		t.Fatalf("run.started = %+v", started)
+	}
+
+	for _, req := range []wsRequest{
+		{Type: wsTypeCancel, Ref: "c", RunID: started.RunID},
+		{Type: wsTypeInput, Ref: "i", RunID: started.RunID, ChatRequest: ChatRequest{Message: "again"}},
+		{Type: wsTypeApproval, Ref: "a", RunID: started.RunID, ToolCallID: "call-1", Decision: "approve"},
+	} {
+		other.send(req)
+		msg := other.until(wsTypeError)
+		if msg.Ref != req.Ref || msg.RunID != started.RunID || msg.Error == nil || msg.Error.Code != "not_found" ||
+			!strings.Contains(msg.Error.Message, "not started on this connection") {
+			t.Errorf("%s from another connection: reply %+v, want not_found", req.Type, msg)
+		}
+	}
+
+	if fin := owner.until(wsTypeRunFinished); fin.RunID != started.RunID {
+		t.Errorf("run.finished for %q, want %q", fin.RunID, started.RunID)
+	}
+
+	// The owner continues its run in the same session.
+	owner.send(wsRequest{Type: wsTypeInput, Ref: "i2", RunID: started.RunID, ChatRequest: ChatRequest{Message: "again"}})
+	next := owner.until(wsTypeRunStarted)
+	if next.Ref != "i2" || next.SessionID != started.SessionID || next.RunID == started.RunID {
+		t.Errorf("follow-up run.started = %+v, want a new run in session %s", next, started.SessionID)
+	}
+	owner.until(wsTypeRunFinished)
+}
+
+func TestWSRejectsBadRequests(t *testing.T) {
+	srv := httptest.NewServer(NewWSServer(nil).Handler())
+	defer srv.Close()
+	c := dialWS(t, srv)
+
+	c.send(wsRequest{Type: wsTypePing, Ref: "p"})
+	if msg := c.until(wsTypePong); msg.Ref != "p" {
+		t.Errorf("pong ref = %q", msg.Ref)
+	}
+
+	tests := []struct {
+		req  wsRequest
+		code string
+	}{
+		{wsRequest{Type: wsTypeStart, Ref: "1", ChatRequest: ChatRequest{AgentID: "agent"}}, "bad_request"},
+		{wsRequest{Type: "shutdown", Ref: "2"}, "bad_request"},
+		{wsRequest{Type: wsTypeCancel, Ref: "3", RunID: "unknown"}, "not_found"},
+	}
+	for _, tt := range tests {
+		c.send(tt.req)
+		msg := c.until(wsTypeError)
+		if msg.Ref != tt.req.Ref || msg.Error == nil || msg.Error.Code != tt.code {
+			t.Errorf("%s: reply %+v, want %s", tt.req.Type, msg, tt.code)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Package keypool hands out CLIProxy-managed provider keys to the model
+// layer. It rotates over the active keys of a provider, enforces their
+// per-minute and per-day limits and takes keys out of rotation when the
+// provider rejects them.
+//
+// Limits are counted in memory by each replica. Per-day counts are seeded
+// from the recorded usage events whenever the keys are refreshed, so usage
+// of other replicas and of earlier processes counts with a delay of one
+// refresh interval. Per-minute limits apply to each replica separately.
+package keypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	pgstore "helixrun/internal/store/postgres"
+)
+
+// ErrNoKey is returned when a provider has no usable key left.
+var ErrNoKey = errors.New("keypool: no usable key")
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultCooldown        = time.Minute
+)
+
+// Store is the persistence the pool needs; *pgstore.KeyRepository
+// implements it.
+type Store interface {
+	List(ctx context.Context, provider string) ([]pgstore.APIKey, error)
+	SetStatus(ctx context.Context, id, status string, until *time.Time) error
+}
+
+// UsageCounter counts the requests already made with keys;
+// *pgstore.UsageRepository implements it.
+type UsageCounter interface {
+	CountByKey(ctx context.Context, keyIDs []string, since time.Time) (map[string]int, error)
+}
+
+// Pool is safe for concurrent use.
+type Pool struct {
+	store    Store
+	usage    UsageCounter
+	refresh  time.Duration
+	cooldown time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex
+	providers map[string]*providerKeys
+	byID      map[string]*keyState
+}
+
+type providerKeys struct {
+	keys       []*keyState
+	next       int
+	loadedAt   time.Time
+	refreshing bool // a reload reads the store; others use the cached keys
+}
+
+// keyState is a key plus its in-memory usage counters. Counters use fixed
+// windows: the current minute and the current UTC day.
+type keyState struct {
+	key            pgstore.APIKey
+	exhaustedUntil time.Time
+
+	minute      time.Time
+	minuteCount int
+	day         time.Time
+	dayCount    int
+}
+
+// statusChange is a status update to persist once the lock is released.
+type statusChange struct {
+	id, status string
+	until      *time.Time
+}
+
+// New creates a Pool backed by store.
+func New(store Store) *Pool {
+	return &Pool{
+		store:     store,
+		refresh:   defaultRefreshInterval,
+		cooldown:  defaultCooldown,
+		now:       time.Now,
+		providers: make(map[string]*providerKeys),
+		byID:      make(map[string]*keyState),
+	}
+}
+
+// WithCooldown sets how long a key stays out of rotation after a 429.
+func (p *Pool) WithCooldown(d time.Duration) *Pool {
+	p.cooldown = d
+	return p
+}
+
+// WithUsageCounter seeds the per-day counters from recorded usage on
+// every refresh.
+func (p *Pool) WithUsageCounter(c UsageCounter) *Pool {
+	p.usage = c
+	return p
+}
+
+// WithRefreshInterval sets how often keys are re-read from the store.
+func (p *Pool) WithRefreshInterval(d time.Duration) *Pool {
+	p.refresh = d
+	return p
+}
+
+// Acquire picks the next usable key of provider round-robin and counts one
+// request against its limits.
+func (p *Pool) Acquire(ctx context.Context, provider string) (keyID, secret string, err error) {
+	var changes []statusChange
+	defer func() { p.persist(ctx, changes) }()
+
+	if err := p.reload(ctx, provider); err != nil {
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pk := p.providers[provider]
+	now := p.now()
+	minute, day := now.Truncate(time.Minute), utcDay(now)
+
+	for i := 0; i < len(pk.keys); i++ {
+		idx := (pk.next + i) % len(pk.keys)
+		ks := pk.keys[idx]
+
+		switch ks.key.Status {
+		case pgstore.KeyStatusActive:
+		case pgstore.KeyStatusExhausted:
+			if now.Before(ks.exhaustedUntil) {
+				continue
+			}
+			ks.key.Status, ks.exhaustedUntil = pgstore.KeyStatusActive, time.Time{}
+			changes = append(changes, statusChange{id: ks.key.ID, status: pgstore.KeyStatusActive})
+		default:
+			continue
+		}
+
+		if !ks.minute.Equal(minute) {
+			ks.minute, ks.minuteCount = minute, 0
+		}
+		if !ks.day.Equal(day) {
+			ks.day, ks.dayCount = day, 0
+		}
+		if ks.key.LimitPerDay != nil && ks.dayCount >= *ks.key.LimitPerDay {
+			until := day.Add(24 * time.Hour)
+			ks.key.Status, ks.exhaustedUntil = pgstore.KeyStatusExhausted, until
+			changes = append(changes, statusChange{id: ks.key.ID, status: pgstore.KeyStatusExhausted, until: &until})
+			continue
+		}
+		if ks.key.LimitPerMinute != nil && ks.minuteCount >= *ks.key.LimitPerMinute {
+			continue
+		}
+
+		ks.minuteCount++
+		ks.dayCount++
+		pk.next = idx + 1
+		return ks.key.ID, ks.key.Secret, nil
+	}
+	return "", "", fmt.Errorf("%w for provider %s", ErrNoKey, provider)
+}
+
+// Report records the HTTP status of a call made with keyID. 401 and 403
+// mark the key invalid; 429 takes it out of rotation for the cooldown.
+// Other statuses are ignored.
+func (p *Pool) Report(ctx context.Context, keyID string, status int) {
+	var (
+		change  *statusChange
+		preview string
+	)
+
+	p.mu.Lock()
+	ks := p.byID[keyID]
+	if ks != nil {
+		preview = ks.key.SecretPreview
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			ks.key.Status = pgstore.KeyStatusInvalid
+			change = &statusChange{id: keyID, status: pgstore.KeyStatusInvalid}
+		case http.StatusTooManyRequests:
+			until := p.now().Add(p.cooldown)
+			ks.key.Status, ks.exhaustedUntil = pgstore.KeyStatusExhausted, until
+			change = &statusChange{id: keyID, status: pgstore.KeyStatusExhausted, until: &until}
+		}
+	}
+	p.mu.Unlock()
+
+	if change != nil {
+		log.Printf("keypool: key %s (%s) got HTTP %d, marked %s", keyID, preview, status, change.status)
+		p.persist(ctx, []statusChange{*change})
+	}
+}
+
+// reload re-reads the keys of provider from the store when they are stale.
+// The store and the usage counter are read without holding p.mu, and only
+// by one caller at a time while cached keys exist. Counters survive a
+// reload.
+func (p *Pool) reload(ctx context.Context, provider string) error {
+	p.mu.Lock()
+	pk := p.providers[provider]
+	if pk != nil && (pk.refreshing || p.now().Sub(pk.loadedAt) < p.refresh) {
+		p.mu.Unlock()
+		return nil
+	}
+	if pk != nil {
+		pk.refreshing = true
+	}
+	day := utcDay(p.now())
+	p.mu.Unlock()
+
+	keys, err := p.store.List(ctx, provider)
+	var counts map[string]int
+	if err == nil {
+		counts = p.countToday(ctx, keys, day)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pk != nil {
+		pk.refreshing = false
+	}
+	if err != nil {
+		if pk != nil {
+			log.Printf("keypool: refresh %s keys failed, using cached keys: %v", provider, err)
+			return nil
+		}
+		return fmt.Errorf("keypool: load %s keys: %w", provider, err)
+	}
+
+	fresh := &providerKeys{loadedAt: p.now()}
+	if cur := p.providers[provider]; cur != nil {
+		fresh.next = cur.next
+	}
+	for _, k := range keys {
+		ks := p.byID[k.ID]
+		if ks == nil {
+			ks = &keyState{}
+			p.byID[k.ID] = ks
+		}
+		ks.key = k
+		ks.exhaustedUntil = exhaustedUntil(k)
+		if counts != nil {
+			// Counters are never lowered: requests of this replica may
+			// not be recorded yet.
+			if !ks.day.Equal(day) {
+				ks.day, ks.dayCount = day, 0
+			}
+			ks.dayCount = max(ks.dayCount, counts[k.ID])
+		}
+		fresh.keys = append(fresh.keys, ks)
+	}
+	p.providers[provider] = fresh
+	return nil
+}
+
+// countToday returns the usage recorded for keys since day, or nil when
+// there is no usage counter or it fails.
+func (p *Pool) countToday(ctx context.Context, keys []pgstore.APIKey, day time.Time) map[string]int {
+	if p.usage == nil || len(keys) == 0 {
+		return nil
+	}
+	ids := make([]string, len(keys))
+	for i, k := range keys {
+		ids[i] = k.ID
+	}
+	counts, err := p.usage.CountByKey(ctx, ids, day)
+	if err != nil {
+		log.Printf("keypool: count today's key usage failed, using local counters: %v", err)
+		return nil
+	}
+	return counts
+}
+
+// persist writes status changes. Failures are only logged; the next
+// refresh then restores the stored status.
+func (p *Pool) persist(ctx context.Context, changes []statusChange) {
+	for _, c := range changes {
+		if err := p.store.SetStatus(ctx, c.id, c.status, c.until); err != nil {
+			log.Printf("keypool: persist status of key %s failed: %v", c.id, err)
+		}
+	}
+}
+
+func exhaustedUntil(k pgstore.APIKey) time.Time {
+	raw, _ := k.Attributes["exhausted_until"].(string)
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func utcDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,270 @@
+package keypool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"helixrun/internal/model"
+	pgstore "helixrun/internal/store/postgres"
+
+	trpcmodel "trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// memStore keeps keys in memory and records persisted statuses.
+type memStore struct {
+	mu       sync.Mutex
+	keys     []pgstore.APIKey
+	statuses map[string]string
+}
+
+func (s *memStore) List(context.Context, string) ([]pgstore.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pgstore.APIKey(nil), s.keys...), nil
+}
+
+func (s *memStore) SetStatus(_ context.Context, id, status string, _ *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.statuses == nil {
+		s.statuses = map[string]string{}
+	}
+	s.statuses[id] = status
+	return nil
+}
+
+func (s *memStore) status(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses[id]
+}
+
+type usageCounts struct {
+	counts map[string]int
+	since  time.Time
+}
+
+func (u *usageCounts) CountByKey(_ context.Context, _ []string, since time.Time) (map[string]int, error) {
+	u.since = since
+	return u.counts, nil
+}
+
+func intPtr(n int) *int { return &n }
+
+// testPool returns a pool over keys with a controllable clock.
+func testPool(keys ...pgstore.APIKey) (*Pool, *memStore, *time.Time) {
+	store := &memStore{keys: keys}
+	now := time.Date(2026, 3, 10, 12, 0, 30, 0, time.UTC)
+	p := New(store).WithRefreshInterval(time.Hour)
+	p.now = func() time.Time { return now }
+	return p, store, &now
+}
+
+func acquireIDs(t *testing.T, p *Pool, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		id, _, err := p.Acquire(context.Background(), "openai")
+		if errors.Is(err, ErrNoKey) {
+			ids = append(ids, "-")
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestAcquireLimits(t *testing.T) {
+	p, store, now := testPool(
+		pgstore.APIKey{ID: "minute", Status: pgstore.KeyStatusActive, LimitPerMinute: intPtr(1)},
+		pgstore.APIKey{ID: "day", Status: pgstore.KeyStatusActive, LimitPerDay: intPtr(2)},
+	)
+
+	got := fmt.Sprint(acquireIDs(t, p, 4))
+	if want := "[minute day day -]"; got != want {
+		t.Fatalf("first minute = %s, want %s", got, want)
+	}
+	if store.status("day") != pgstore.KeyStatusExhausted {
+		t.Errorf("day key status = %q, want exhausted", store.status("day"))
+	}
+
+	*now = now.Add(time.Minute)
+	got = fmt.Sprint(acquireIDs(t, p, 2))
+	if want := "[minute -]"; got != want {
+		t.Fatalf("next minute = %s, want %s", got, want)
+	}
+
+	*now = now.Add(24 * time.Hour)
+	got = fmt.Sprint(acquireIDs(t, p, 3))
+	if want := "[day minute day]"; got != want {
+		t.Fatalf("next day = %s, want %s", got, want)
+	}
+}
+
+func TestAcquireSeedsDayCountFromUsage(t *testing.T) {
+	p, _, now := testPool(
+		pgstore.APIKey{ID: "a", Status: pgstore.KeyStatusActive, LimitPerDay: intPtr(3)},
+	)
+	usage := &usageCounts{counts: map[string]int{"a": 2}}
+	p.WithUsageCounter(usage)
+
+	got := fmt.Sprint(acquireIDs(t, p, 2))
+	if want := "[a -]"; got != want {
+		t.Fatalf("acquired %s, want %s", got, want)
+	}
+	if want := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC); !usage.since.Equal(want) {
+		t.Errorf("counted usage since %s, want %s", usage.since, want)
+	}
+
+	// On a later day the count restarts from that day's usage. Refreshing
+	// before every Acquire never lowers the local count below it.
+	*now = now.Add(48 * time.Hour)
+	usage.counts = map[string]int{"a": 1}
+	p.WithRefreshInterval(0)
+	got = fmt.Sprint(acquireIDs(t, p, 3))
+	if want := "[a a -]"; got != want {
+		t.Fatalf("next day acquired %s, want %s", got, want)
+	}
+}
+
+// blockingCounter blocks CountByKey until release is closed.
+type blockingCounter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingCounter) CountByKey(context.Context, []string, time.Time) (map[string]int, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return nil, nil
+}
+
+func TestRefreshDoesNotHoldTheLock(t *testing.T) {
+	p, _, now := testPool(
+		pgstore.APIKey{ID: "a", Status: pgstore.KeyStatusActive},
+		pgstore.APIKey{ID: "b", Status: pgstore.KeyStatusActive},
+	)
+	usage := &blockingCounter{started: make(chan struct{}), release: make(chan struct{})}
+	p.WithUsageCounter(usage)
+
+	// The first load has no cached keys to fall back on.
+	first := make(chan string)
+	go func() { first <- fmt.Sprint(acquireIDs(t, p, 1)) }()
+	<-usage.started
+	p.Report(context.Background(), "unknown", http.StatusTooManyRequests) // must not block
+	close(usage.release)
+	if got := <-first; got != "[a]" {
+		t.Fatalf("first acquire = %s, want [a]", got)
+	}
+
+	// Once the keys are stale, one caller refreshes them while the others
+	// keep using the cached keys.
+	*now = now.Add(2 * time.Hour)
+	usage.release = make(chan struct{})
+	refreshed := make(chan string)
+	go func() { refreshed <- fmt.Sprint(acquireIDs(t, p, 1)) }()
+	<-usage.started
+	if got := fmt.Sprint(acquireIDs(t, p, 2)); got != "[b a]" {
+		t.Errorf("acquired during refresh = %s, want [b a]", got)
+	}
+	close(usage.release)
+	if got := <-refreshed; got != "[b]" {
+		t.Errorf("refreshing acquire = %s, want [b]", got)
+	}
+}
+
+func TestReportRotatesKeys(t *testing.T) {
+	p, store, now := testPool(
+		pgstore.APIKey{ID: "bad", Secret: "sk-bad", Status: pgstore.KeyStatusActive},
+		pgstore.APIKey{ID: "busy", Secret: "sk-busy", Status: pgstore.KeyStatusActive},
+		pgstore.APIKey{ID: "good", Secret: "sk-good", Status: pgstore.KeyStatusActive},
+	)
+	p.WithCooldown(time.Minute)
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		mu.Lock()
+		seen = append(seen, auth)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch auth {
+		case "Bearer sk-bad":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"invalid key"}}`)
+		case "Bearer sk-busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"slow down"}}`)
+		default:
+			fmt.Fprint(w, `{"id":"c1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	model.SetKeySource(p)
+	t.Cleanup(func() { model.SetKeySource(nil) })
+	m, _, err := model.NewModelFromConfig(model.Config{
+		Provider:  model.ProviderOpenAI,
+		Model:     "m",
+		BaseURL:   srv.URL,
+		APIKeyEnv: "pool:openai",
+	}, false)
+	if err != nil {
+		t.Fatalf("NewModelFromConfig: %v", err)
+	}
+
+	call := func() string {
+		ch, err := m.GenerateContent(context.Background(), &trpcmodel.Request{
+			Messages: []trpcmodel.Message{trpcmodel.NewUserMessage("hi")},
+		})
+		if err != nil {
+			t.Fatalf("GenerateContent: %v", err)
+		}
+		var last *trpcmodel.Response
+		for r := range ch {
+			last = r
+		}
+		if last.Error != nil {
+			return "error: " + last.Error.Message
+		}
+		return last.Choices[0].Message.Content
+	}
+
+	if got := call(); got != "ok" {
+		t.Fatalf("first call = %q, want ok", got)
+	}
+	want := "[Bearer sk-bad Bearer sk-busy Bearer sk-good]"
+	if fmt.Sprint(seen) != want {
+		t.Fatalf("keys tried = %v, want %s", seen, want)
+	}
+	if store.status("bad") != pgstore.KeyStatusInvalid || store.status("busy") != pgstore.KeyStatusExhausted {
+		t.Errorf("statuses = %v, want bad invalid and busy exhausted", store.statuses)
+	}
+
+	// Only the good key is left until the cooldown of the busy key ends.
+	seen = nil
+	call()
+	if fmt.Sprint(seen) != "[Bearer sk-good]" {
+		t.Errorf("keys tried during cooldown = %v, want only sk-good", seen)
+	}
+
+	*now = now.Add(2 * time.Minute)
+	seen = nil
+	call()
+	if fmt.Sprint(seen) != "[Bearer sk-busy Bearer sk-good]" {
+		t.Errorf("keys tried after cooldown = %v, want sk-busy back in rotation", seen)
+	}
+}
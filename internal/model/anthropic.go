@@ -0,0 +1,472 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+)
+
+// ProviderAnthropic is the Anthropic Messages API provider.
+const ProviderAnthropic = "anthropic"
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	anthropicVersion          = "2023-06-01"
+	defaultAnthropicMaxTokens = 4096
+
+	// anthropicResponseHeaderTimeout bounds the wait for the response
+	// headers. The body is not bounded so that long streams are not cut
+	// off; the request context still cancels them.
+	anthropicResponseHeaderTimeout = 5 * time.Minute
+)
+
+// anthropicTransport is shared by all Anthropic models so that they reuse
+// connections.
+var anthropicTransport = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = anthropicResponseHeaderTimeout
+	return t
+}()
+
+func init() {
+	RegisterProvider(ProviderAnthropic, newAnthropicModel)
+}
+
+// newAnthropicModel builds a Messages API client.
+// Base URL: JSON override > env var ANTHROPIC_BASE_URL > api.anthropic.com.
+// API key: secret reference in api_key_env (default env:ANTHROPIC_API_KEY).
+func newAnthropicModel(cfg Config) (model.Model, error) {
+	apiKey, source, err := cfg.apiKey("ANTHROPIC_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, missingKeyError("Anthropic", source)
+	}
+	return &anthropicModel{
+		name:    cfg.Model,
+		baseURL: strings.TrimRight(cfg.baseURL("ANTHROPIC_BASE_URL", defaultAnthropicBaseURL), "/"),
+		apiKey:  apiKey,
+		client: model.DefaultNewHTTPClient(
+			model.WithHTTPClientName(ProviderAnthropic),
+			model.WithHTTPClientTransport(anthropicTransport),
+		),
+	}, nil
+}
+
+// anthropicModel implements model.Model on top of POST /v1/messages.
+type anthropicModel struct {
+	name    string
+	baseURL string
+	apiKey  string
+	client  model.HTTPClient
+}
+
+func (m *anthropicModel) Info() model.Info {
+	return model.Info{Name: m.name}
+}
+
+// GenerateContent sends the request and streams the result. API errors are
+// reported as a final response with Error set.
+func (m *anthropicModel) GenerateContent(ctx context.Context, req *model.Request) (<-chan *model.Response, error) {
+	if req == nil {
+		return nil, fmt.Errorf("anthropic: request is nil")
+	}
+	body, err := json.Marshal(m.buildRequest(req))
+	if err != nil {
+		return nil, fmt.Errorf("anthropic: encode request: %w", err)
+	}
+
+	out := make(chan *model.Response, 16)
+	go func() {
+		defer close(out)
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/v1/messages", bytes.NewReader(body))
+		if err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeAPIError, err.Error()))
+			return
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("X-Api-Key", m.apiKey)
+		httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+		resp, err := m.client.Do(httpReq)
+		if err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeAPIError, fmt.Sprintf("anthropic: %v", err)))
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			sendResponse(ctx, out, anthropicHTTPError(resp))
+			return
+		}
+		if req.Stream {
+			m.readStream(ctx, resp.Body, out)
+			return
+		}
+		var msg anthropicMessage
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeAPIError, fmt.Sprintf("anthropic: decode response: %v", err)))
+			return
+		}
+		sendResponse(ctx, out, msg.toResponse())
+	}()
+	return out, nil
+}
+
+// readStream converts the Messages API SSE stream into partial responses
+// followed by one final response carrying the full message.
+func (m *anthropicModel) readStream(ctx context.Context, body io.Reader, out chan<- *model.Response) {
+	var (
+		msg     anthropicMessage
+		partial = map[int]*strings.Builder{} // tool_use input JSON per block index
+	)
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var ev anthropicStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &ev); err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeStreamError, fmt.Sprintf("anthropic: decode stream event: %v", err)))
+			return
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				msg = *ev.Message
+				msg.Content = nil
+			}
+		case "content_block_start":
+			if ev.ContentBlock == nil {
+				continue
+			}
+			for len(msg.Content) <= ev.Index {
+				msg.Content = append(msg.Content, anthropicContent{})
+			}
+			msg.Content[ev.Index] = *ev.ContentBlock
+			if ev.ContentBlock.Type == "tool_use" {
+				partial[ev.Index] = &strings.Builder{}
+			}
+		case "content_block_delta":
+			if ev.Delta == nil || ev.Index >= len(msg.Content) {
+				continue
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				msg.Content[ev.Index].Text += ev.Delta.Text
+				if !sendResponse(ctx, out, msg.chunk(ev.Delta.Text)) {
+					return
+				}
+			case "input_json_delta":
+				if b := partial[ev.Index]; b != nil {
+					b.WriteString(ev.Delta.PartialJSON)
+				}
+			}
+		case "content_block_stop":
+			if b := partial[ev.Index]; b != nil && ev.Index < len(msg.Content) {
+				input := b.String()
+				if input == "" {
+					input = "{}"
+				}
+				msg.Content[ev.Index].Input = json.RawMessage(input)
+				delete(partial, ev.Index)
+			}
+		case "message_delta":
+			if ev.Delta != nil && ev.Delta.StopReason != "" {
+				msg.StopReason = ev.Delta.StopReason
+			}
+			if ev.Usage != nil {
+				msg.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		case "message_stop":
+			sendResponse(ctx, out, msg.toResponse())
+			return
+		case "error":
+			text := "stream error"
+			if ev.Error != nil {
+				text = ev.Error.Type + ": " + ev.Error.Message
+			}
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeStreamError, "anthropic: "+text))
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		sendResponse(ctx, out, errorResponse(model.ErrorTypeStreamError, fmt.Sprintf("anthropic: %v", err)))
+		return
+	}
+	sendResponse(ctx, out, errorResponse(model.ErrorTypeStreamError, "anthropic: stream ended before message_stop"))
+}
+
+// buildRequest maps a model.Request onto the Messages API. System messages
+// are hoisted into "system" and tool results become tool_result blocks in a
+// user turn; consecutive turns of the same role are merged.
+func (m *anthropicModel) buildRequest(req *model.Request) anthropicRequest {
+	ar := anthropicRequest{
+		Model:         m.name,
+		MaxTokens:     defaultAnthropicMaxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+		Stream:        req.Stream,
+	}
+	if req.MaxTokens != nil {
+		ar.MaxTokens = *req.MaxTokens
+	}
+
+	var system []string
+	appendTurn := func(role string, blocks ...anthropicContent) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(ar.Messages); n > 0 && ar.Messages[n-1].Role == role {
+			ar.Messages[n-1].Content = append(ar.Messages[n-1].Content, blocks...)
+			return
+		}
+		ar.Messages = append(ar.Messages, anthropicTurn{Role: role, Content: blocks})
+	}
+
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case model.RoleSystem:
+			if msg.Content != "" {
+				system = append(system, msg.Content)
+			}
+		case model.RoleUser:
+			appendTurn("user", textBlocks(msg)...)
+		case model.RoleAssistant:
+			blocks := textBlocks(msg)
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if len(bytes.TrimSpace(input)) == 0 {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContent{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Function.Name,
+					Input: input,
+				})
+			}
+			appendTurn("assistant", blocks...)
+		case model.RoleTool:
+			appendTurn("user", anthropicContent{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolID,
+				Content:   msg.Content,
+			})
+		}
+	}
+	ar.System = strings.Join(system, "\n\n")
+
+	names := make([]string, 0, len(req.Tools))
+	for name := range req.Tools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ar.Tools = append(ar.Tools, anthropicTool(name, req.Tools[name]))
+	}
+	return ar
+}
+
+func textBlocks(msg model.Message) []anthropicContent {
+	var blocks []anthropicContent
+	if msg.Content != "" {
+		blocks = append(blocks, anthropicContent{Type: "text", Text: msg.Content})
+	}
+	for _, part := range msg.ContentParts {
+		if part.Type == model.ContentTypeText && part.Text != nil && *part.Text != "" {
+			blocks = append(blocks, anthropicContent{Type: "text", Text: *part.Text})
+		}
+	}
+	return blocks
+}
+
+func anthropicTool(name string, t tool.Tool) anthropicToolDef {
+	decl := t.Declaration()
+	schema := decl.InputSchema
+	if schema == nil {
+		schema = &tool.Schema{Type: "object"}
+	}
+	return anthropicToolDef{Name: name, Description: decl.Description, InputSchema: schema}
+}
+
+// anthropicHTTPError turns a non-2xx response into a final error response.
+// The HTTP status is kept in Error.Code.
+func anthropicHTTPError(resp *http.Response) *model.Response {
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	text := strings.TrimSpace(string(raw))
+	if json.Unmarshal(raw, &body) == nil && body.Error.Message != "" {
+		text = body.Error.Type + ": " + body.Error.Message
+	}
+	r := errorResponse(model.ErrorTypeAPIError, fmt.Sprintf("anthropic: %s: %s", resp.Status, text))
+	code := fmt.Sprint(resp.StatusCode)
+	r.Error.Code = &code
+	return r
+}
+
+func errorResponse(errType, msg string) *model.Response {
+	return &model.Response{
+		Object:    model.ObjectTypeError,
+		Error:     &model.ResponseError{Message: msg, Type: errType},
+		Timestamp: time.Now(),
+		Done:      true,
+	}
+}
+
+// sendResponse delivers r unless ctx is done first.
+func sendResponse(ctx context.Context, out chan<- *model.Response, r *model.Response) bool {
+	select {
+	case out <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Messages API wire types.
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicTurn    `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []anthropicToolDef `json:"tools,omitempty"`
+}
+
+type anthropicTurn struct {
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicContent struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicToolDef struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	InputSchema *tool.Schema `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicMessage struct {
+	ID         string             `json:"id"`
+	Model      string             `json:"model"`
+	Content    []anthropicContent `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      anthropicUsage     `json:"usage"`
+}
+
+type anthropicStreamEvent struct {
+	Type         string            `json:"type"`
+	Index        int               `json:"index"`
+	Message      *anthropicMessage `json:"message,omitempty"`
+	ContentBlock *anthropicContent `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// chunk builds a partial response carrying a text delta.
+func (msg *anthropicMessage) chunk(text string) *model.Response {
+	return &model.Response{
+		ID:     msg.ID,
+		Object: model.ObjectTypeChatCompletionChunk,
+		Model:  msg.Model,
+		Choices: []model.Choice{{
+			Delta: model.Message{Role: model.RoleAssistant, Content: text},
+		}},
+		Timestamp: time.Now(),
+		IsPartial: true,
+	}
+}
+
+// toResponse builds the final response for a complete message.
+func (msg *anthropicMessage) toResponse() *model.Response {
+	out := model.Message{Role: model.RoleAssistant}
+	for _, c := range msg.Content {
+		switch c.Type {
+		case "text":
+			out.Content += c.Text
+		case "tool_use":
+			args := []byte(c.Input)
+			if len(args) == 0 {
+				args = []byte("{}")
+			}
+			out.ToolCalls = append(out.ToolCalls, model.ToolCall{
+				Type:     "function",
+				ID:       c.ID,
+				Function: model.FunctionDefinitionParam{Name: c.Name, Arguments: args},
+			})
+		}
+	}
+
+	finish := "stop"
+	switch msg.StopReason {
+	case "max_tokens":
+		finish = "length"
+	case "tool_use":
+		finish = "tool_calls"
+	}
+
+	now := time.Now()
+	return &model.Response{
+		ID:      msg.ID,
+		Object:  model.ObjectTypeChatCompletion,
+		Created: now.Unix(),
+		Model:   msg.Model,
+		Choices: []model.Choice{{Message: out, FinishReason: &finish}},
+		Usage: &model.Usage{
+			PromptTokens:     msg.Usage.InputTokens,
+			CompletionTokens: msg.Usage.OutputTokens,
+			TotalTokens:      msg.Usage.InputTokens + msg.Usage.OutputTokens,
+		},
+		Timestamp: now,
+		Done:      len(out.ToolCalls) == 0,
+	}
+}
@@ -0,0 +1,406 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+	"trpc.group/trpc-go/trpc-agent-go/tool"
+	"trpc.group/trpc-go/trpc-agent-go/tool/function"
+)
+
+// anthropicStub serves /v1/messages with handler and records the decoded
+// request bodies.
+type anthropicStub struct {
+	*httptest.Server
+	requests []anthropicRequest
+	headers  []http.Header
+}
+
+func newAnthropicStub(t *testing.T, handler func(w http.ResponseWriter, req anthropicRequest)) *anthropicStub {
+	t.Helper()
+	s := &anthropicStub{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s, want /v1/messages", r.URL.Path)
+		}
+		var req anthropicRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		s.requests = append(s.requests, req)
+		s.headers = append(s.headers, r.Header.Clone())
+		handler(w, req)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestAnthropicModel(t *testing.T, baseURL string) model.Model {
+	t.Helper()
+	t.Setenv("TEST_ANTHROPIC_KEY", "test-key")
+	m, err := newAnthropicModel(Config{
+		Provider:  ProviderAnthropic,
+		Model:     "claude-test",
+		BaseURL:   baseURL,
+		APIKeyEnv: "env:TEST_ANTHROPIC_KEY",
+	})
+	if err != nil {
+		t.Fatalf("newAnthropicModel: %v", err)
+	}
+	return m
+}
+
+// collect drains a response channel.
+func collect(t *testing.T, ch <-chan *model.Response) []*model.Response {
+	t.Helper()
+	var out []*model.Response
+	for r := range ch {
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		t.Fatal("no responses")
+	}
+	return out
+}
+
+func TestAnthropicRequestMapping(t *testing.T) {
+	stub := newAnthropicStub(t, func(w http.ResponseWriter, _ anthropicRequest) {
+		fmt.Fprint(w, `{"id":"msg_1","model":"claude-test","content":[{"type":"text","text":"4"}],"stop_reason":"end_turn","usage":{"input_tokens":10,"output_tokens":1}}`)
+	})
+	m := newTestAnthropicModel(t, stub.URL)
+
+	calc := function.NewFunctionTool(
+		func(context.Context, struct {
+			A float64 `json:"a"`
+		}) (float64, error) {
+			return 0, nil
+		},
+		function.WithName("calculator"),
+		function.WithDescription("Adds numbers."),
+	)
+	temp, maxTokens := 0.2, 256
+	req := &model.Request{
+		Messages: []model.Message{
+			model.NewSystemMessage("Be brief."),
+			model.NewSystemMessage("Use tools."),
+			model.NewUserMessage("What is 2+2?"),
+			{
+				Role:    model.RoleAssistant,
+				Content: "Let me check.",
+				ToolCalls: []model.ToolCall{{
+					Type:     "function",
+					ID:       "call_1",
+					Function: model.FunctionDefinitionParam{Name: "calculator", Arguments: []byte(`{"a":2}`)},
+				}},
+			},
+			{Role: model.RoleTool, ToolID: "call_1", Content: "4"},
+			model.NewUserMessage("And?"),
+		},
+		GenerationConfig: model.GenerationConfig{Temperature: &temp, MaxTokens: &maxTokens, Stop: []string{"END"}},
+		Tools:            map[string]tool.Tool{"calculator": calc},
+	}
+
+	resps := collect(t, mustGenerate(t, m, req))
+	if got := resps[len(resps)-1].Choices[0].Message.Content; got != "4" {
+		t.Fatalf("content = %q, want 4", got)
+	}
+
+	if len(stub.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(stub.requests))
+	}
+	got := stub.requests[0]
+	h := stub.headers[0]
+	if h.Get("X-Api-Key") != "test-key" || h.Get("Anthropic-Version") != anthropicVersion {
+		t.Errorf("headers: X-Api-Key set=%v, Anthropic-Version=%q", h.Get("X-Api-Key") != "", h.Get("Anthropic-Version"))
+	}
+	if got.Model != "claude-test" || got.MaxTokens != 256 || got.Temperature == nil || *got.Temperature != 0.2 {
+		t.Errorf("model=%q max_tokens=%d temperature=%v", got.Model, got.MaxTokens, got.Temperature)
+	}
+	if len(got.StopSequences) != 1 || got.StopSequences[0] != "END" {
+		t.Errorf("stop_sequences = %v, want [END]", got.StopSequences)
+	}
+	if got.System != "Be brief.\n\nUse tools." {
+		t.Errorf("system = %q", got.System)
+	}
+
+	// user, assistant (text + tool_use), user (tool_result + text).
+	if len(got.Messages) != 3 {
+		t.Fatalf("got %d turns, want 3: %+v", len(got.Messages), got.Messages)
+	}
+	wantRoles := []string{"user", "assistant", "user"}
+	for i, turn := range got.Messages {
+		if turn.Role != wantRoles[i] {
+			t.Errorf("turn %d role = %s, want %s", i, turn.Role, wantRoles[i])
+		}
+	}
+	asst := got.Messages[1].Content
+	if len(asst) != 2 || asst[0].Type != "text" || asst[1].Type != "tool_use" ||
+		asst[1].ID != "call_1" || asst[1].Name != "calculator" || string(asst[1].Input) != `{"a":2}` {
+		t.Errorf("assistant turn = %+v", asst)
+	}
+	user := got.Messages[2].Content
+	if len(user) != 2 || user[0].Type != "tool_result" || user[0].ToolUseID != "call_1" || user[0].Content != "4" ||
+		user[1].Type != "text" || user[1].Text != "And?" {
+		t.Errorf("tool result turn = %+v", user)
+	}
+
+	if len(got.Tools) != 1 || got.Tools[0].Name != "calculator" || got.Tools[0].Description != "Adds numbers." ||
+		got.Tools[0].InputSchema == nil || got.Tools[0].InputSchema.Properties["a"] == nil {
+		t.Errorf("tools = %+v", got.Tools)
+	}
+}
+
+func TestAnthropicDefaultMaxTokens(t *testing.T) {
+	stub := newAnthropicStub(t, func(w http.ResponseWriter, _ anthropicRequest) {
+		fmt.Fprint(w, `{"id":"msg_1","content":[],"stop_reason":"end_turn"}`)
+	})
+	m := newTestAnthropicModel(t, stub.URL)
+	collect(t, mustGenerate(t, m, &model.Request{Messages: []model.Message{model.NewUserMessage("hi")}}))
+	if got := stub.requests[0].MaxTokens; got != defaultAnthropicMaxTokens {
+		t.Fatalf("max_tokens = %d, want %d", got, defaultAnthropicMaxTokens)
+	}
+}
+
+func TestAnthropicStream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-test","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"ping"}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me "}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"add."}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"calculator","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"a\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"2}"}}`,
+		`{"type":"content_block_stop","index":1}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+		`{"type":"message_stop"}`,
+	}
+	stub := newAnthropicStub(t, func(w http.ResponseWriter, req anthropicRequest) {
+		if !req.Stream {
+			t.Error("stream = false, want true")
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range events {
+			var typ struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal([]byte(ev), &typ)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ.Type, ev)
+		}
+	})
+	m := newTestAnthropicModel(t, stub.URL)
+
+	req := &model.Request{
+		Messages:         []model.Message{model.NewUserMessage("2+2?")},
+		GenerationConfig: model.GenerationConfig{Stream: true},
+	}
+	resps := collect(t, mustGenerate(t, m, req))
+
+	var deltas []string
+	for _, r := range resps[:len(resps)-1] {
+		if !r.IsPartial {
+			t.Errorf("response before the last is not partial: %+v", r)
+		}
+		deltas = append(deltas, r.Choices[0].Delta.Content)
+	}
+	if strings.Join(deltas, "|") != "Let me |add." {
+		t.Errorf("deltas = %q", deltas)
+	}
+
+	final := resps[len(resps)-1]
+	if final.IsPartial || final.Error != nil {
+		t.Fatalf("final response = %+v", final)
+	}
+	msg := final.Choices[0].Message
+	if msg.Content != "Let me add." {
+		t.Errorf("content = %q", msg.Content)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "toolu_1" ||
+		msg.ToolCalls[0].Function.Name != "calculator" || string(msg.ToolCalls[0].Function.Arguments) != `{"a":2}` {
+		t.Errorf("tool calls = %+v", msg.ToolCalls)
+	}
+	if fr := final.Choices[0].FinishReason; fr == nil || *fr != "tool_calls" {
+		t.Errorf("finish reason = %v, want tool_calls", fr)
+	}
+	if final.Done {
+		t.Error("Done = true for a tool call response")
+	}
+	if u := final.Usage; u == nil || u.PromptTokens != 12 || u.CompletionTokens != 20 || u.TotalTokens != 32 {
+		t.Errorf("usage = %+v", final.Usage)
+	}
+}
+
+func TestAnthropicStreamErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		want   string
+	}{
+		{
+			name:   "error event",
+			stream: "data: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+			want:   "overloaded_error: Overloaded",
+		},
+		{
+			name:   "truncated",
+			stream: "data: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"content\":[]}}\n\n",
+			want:   "stream ended before message_stop",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newAnthropicStub(t, func(w http.ResponseWriter, _ anthropicRequest) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, tt.stream)
+			})
+			m := newTestAnthropicModel(t, stub.URL)
+			resps := collect(t, mustGenerate(t, m, &model.Request{
+				Messages:         []model.Message{model.NewUserMessage("hi")},
+				GenerationConfig: model.GenerationConfig{Stream: true},
+			}))
+			last := resps[len(resps)-1]
+			if last.Error == nil || last.Error.Type != model.ErrorTypeStreamError || !strings.Contains(last.Error.Message, tt.want) {
+				t.Fatalf("error = %+v, want stream error containing %q", last.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicErrorStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusTooManyRequests, `{"type":"error","error":{"type":"rate_limit_error","message":"Slow down"}}`, "rate_limit_error: Slow down"},
+		{http.StatusUnauthorized, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, "authentication_error"},
+		{529, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, "overloaded_error"},
+		{http.StatusBadGateway, `upstream failed`, "upstream failed"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.status), func(t *testing.T) {
+			stub := newAnthropicStub(t, func(w http.ResponseWriter, _ anthropicRequest) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			m := newTestAnthropicModel(t, stub.URL)
+			resps := collect(t, mustGenerate(t, m, &model.Request{Messages: []model.Message{model.NewUserMessage("hi")}}))
+			if len(resps) != 1 {
+				t.Fatalf("got %d responses, want 1", len(resps))
+			}
+			e := resps[0].Error
+			if e == nil || e.Code == nil || *e.Code != fmt.Sprint(tt.status) {
+				t.Fatalf("error = %+v, want Code %d", e, tt.status)
+			}
+			if e.Type != model.ErrorTypeAPIError || !strings.Contains(e.Message, tt.want) {
+				t.Errorf("error = %+v, want api error containing %q", e, tt.want)
+			}
+			if status, ok := errorStatus(e); !ok || status != tt.status {
+				t.Errorf("errorStatus = %d, %v, want %d", status, ok, tt.status)
+			}
+		})
+	}
+}
+
+func TestAnthropicMissingKey(t *testing.T) {
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	_, err := newAnthropicModel(Config{Provider: ProviderAnthropic, Model: "claude-test"})
+	if err == nil || !strings.Contains(err.Error(), "ANTHROPIC_API_KEY") {
+		t.Fatalf("err = %v, want missing ANTHROPIC_API_KEY", err)
+	}
+}
+
+func TestAnthropicUnsupportedOptions(t *testing.T) {
+	penalty, effort := 0.5, "high"
+	for _, opts := range []GenerationOptions{
+		{PresencePenalty: &penalty},
+		{FrequencyPenalty: &penalty},
+		{ReasoningEffort: &effort},
+	} {
+		cfg := Config{Provider: ProviderAnthropic, Model: "claude-test", GenerationOptions: opts}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "not supported by provider anthropic") {
+			t.Errorf("Validate(%+v) = %v, want unsupported option error", opts, err)
+		}
+
+		// Options of an OpenAI primary are also sent to an Anthropic fallback.
+		cfg = Config{
+			Provider:          ProviderOpenAI,
+			Model:             "gpt-test",
+			GenerationOptions: opts,
+			Fallbacks:         []Config{{Provider: ProviderAnthropic, Model: "claude-test"}},
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) with anthropic fallback = nil, want error", opts)
+		}
+	}
+}
+
+func TestAnthropicTemperatureRange(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"anthropic 1", Config{Provider: ProviderAnthropic, Model: "c", GenerationOptions: GenerationOptions{Temperature: f(1)}}, true},
+		{"anthropic 1.5", Config{Provider: ProviderAnthropic, Model: "c", GenerationOptions: GenerationOptions{Temperature: f(1.5)}}, false},
+		{"openai 1.5", Config{Provider: ProviderOpenAI, Model: "g", GenerationOptions: GenerationOptions{Temperature: f(1.5)}}, true},
+		{"openai 1.5 with anthropic fallback", Config{
+			Provider: ProviderOpenAI, Model: "g", GenerationOptions: GenerationOptions{Temperature: f(1.5)},
+			Fallbacks: []Config{{Provider: ProviderAnthropic, Model: "c"}},
+		}, false},
+		{"anthropic fallback with its own temperature", Config{
+			Provider: ProviderOpenAI, Model: "g", GenerationOptions: GenerationOptions{Temperature: f(1.5)},
+			Fallbacks: []Config{{Provider: ProviderAnthropic, Model: "c", GenerationOptions: GenerationOptions{Temperature: f(0.5)}}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("Validate = %v, want nil", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "temperature must be between 0 and 1 for provider anthropic")) {
+				t.Errorf("Validate = %v, want a range error", err)
+			}
+		})
+	}
+}
+
+func TestAnthropicHTTPClient(t *testing.T) {
+	var got model.HTTPClientOptions
+	orig := model.DefaultNewHTTPClient
+	model.DefaultNewHTTPClient = func(opts ...model.HTTPClientOption) model.HTTPClient {
+		for _, opt := range opts {
+			opt(&got)
+		}
+		return orig(opts...)
+	}
+	t.Cleanup(func() { model.DefaultNewHTTPClient = orig })
+
+	newTestAnthropicModel(t, "http://127.0.0.1:1")
+	tr, ok := got.Transport.(*http.Transport)
+	if got.Name != ProviderAnthropic || !ok || tr.ResponseHeaderTimeout <= 0 {
+		t.Errorf("client options = %+v, want a named client whose transport has a response header timeout", got)
+	}
+	if tr == http.DefaultTransport {
+		t.Error("the anthropic client uses http.DefaultTransport")
+	}
+}
+
+func mustGenerate(t *testing.T, m model.Model, req *model.Request) <-chan *model.Response {
+	t.Helper()
+	ch, err := m.GenerateContent(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GenerateContent: %v", err)
+	}
+	return ch
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+	"sync"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// Attribution identifies the provider and CLIProxy key (if any) that
+// produced a model response.
+type Attribution struct {
+	Provider string
+	KeyID    string
+}
+
+// AttributionRecorder collects attributions by response ID for one run.
+// The runner installs it in the context; models built by
+// NewModelFromConfig report to it.
+type AttributionRecorder struct {
+	mu sync.Mutex
+	m  map[string]Attribution
+}
+
+// NewAttributionRecorder creates an empty recorder.
+func NewAttributionRecorder() *AttributionRecorder {
+	return &AttributionRecorder{m: make(map[string]Attribution)}
+}
+
+// Take returns and forgets the attribution of a response.
+func (r *AttributionRecorder) Take(responseID string) (Attribution, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.m[responseID]
+	delete(r.m, responseID)
+	return a, ok
+}
+
+func (r *AttributionRecorder) put(responseID string, a Attribution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.m[responseID] = a
+}
+
+type attributionKey struct{}
+
+// ContextWithAttribution returns a context whose model calls report to rec.
+func ContextWithAttribution(ctx context.Context, rec *AttributionRecorder) context.Context {
+	return context.WithValue(ctx, attributionKey{}, rec)
+}
+
+func attributionFromContext(ctx context.Context) *AttributionRecorder {
+	rec, _ := ctx.Value(attributionKey{}).(*AttributionRecorder)
+	return rec
+}
+
+// attributedModel reports the attribution of each final response of the
+// wrapped provider model.
+type attributedModel struct {
+	model.Model
+	attr Attribution
+}
+
+func (m *attributedModel) GenerateContent(ctx context.Context, req *model.Request) (<-chan *model.Response, error) {
+	rec := attributionFromContext(ctx)
+	if rec == nil {
+		return m.Model.GenerateContent(ctx, req)
+	}
+	ch, err := m.Model.GenerateContent(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan *model.Response, cap(ch))
+	go func() {
+		defer close(out)
+		for resp := range ch {
+			if resp != nil && !resp.IsPartial && resp.ID != "" {
+				rec.put(resp.ID, m.attr)
+			}
+			if !sendResponse(ctx, out, resp) {
+				for range ch {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// UsageFunc receives final model responses that do not become run events,
+// such as the classification calls of graph routers.
+type UsageFunc func(ctx context.Context, resp *model.Response)
+
+type usageKey struct{}
+
+// ContextWithUsage returns a context whose side calls report their
+// responses to fn.
+func ContextWithUsage(ctx context.Context, fn UsageFunc) context.Context {
+	return context.WithValue(ctx, usageKey{}, fn)
+}
+
+// ReportUsage passes a final response carrying usage to the UsageFunc of
+// ctx, if any.
+func ReportUsage(ctx context.Context, resp *model.Response) {
+	fn, _ := ctx.Value(usageKey{}).(UsageFunc)
+	if fn == nil || resp == nil || resp.IsPartial || resp.Usage == nil {
+		return
+	}
+	fn(ctx, resp)
+}
@@ -0,0 +1,265 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"time"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// Retry defaults, used for unset RetryPolicy fields.
+const (
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	defaultRetryMultiplier     = 2.0
+)
+
+// defaultRetryableStatusCodes are retried when RetryPolicy.RetryableStatusCodes
+// is empty.
+var defaultRetryableStatusCodes = []int{408, 429, 500, 502, 503, 504}
+
+// RetryPolicy controls how often a failing model call is retried before the
+// next fallback model is tried.
+type RetryPolicy struct {
+	MaxAttempts          int      `json:"max_attempts,omitempty"`    // per model, including the first call; default 1
+	InitialBackoff       Duration `json:"initial_backoff,omitempty"` // default 500ms
+	MaxBackoff           Duration `json:"max_backoff,omitempty"`     // default 10s
+	Multiplier           float64  `json:"multiplier,omitempty"`      // default 2
+	RetryableStatusCodes []int    `json:"retryable_status_codes,omitempty"`
+}
+
+// Duration is a time.Duration written as a Go duration string ("750ms")
+// in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"500ms\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Validate checks the policy values.
+func (p *RetryPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch {
+	case p.MaxAttempts < 0:
+		return fmt.Errorf("retry.max_attempts must be >= 1")
+	case p.InitialBackoff < 0 || p.MaxBackoff < 0:
+		return fmt.Errorf("retry backoff must not be negative")
+	case p.MaxBackoff != 0 && p.MaxBackoff < p.InitialBackoff:
+		return fmt.Errorf("retry.max_backoff must be >= initial_backoff")
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return fmt.Errorf("retry.multiplier must be >= 1")
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("retry.retryable_status_codes: invalid HTTP status %d", code)
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a copy of p with unset fields filled in. A nil policy
+// means a single attempt.
+func (p *RetryPolicy) withDefaults() RetryPolicy {
+	var out RetryPolicy
+	if p != nil {
+		out = *p
+	}
+	if out.MaxAttempts == 0 {
+		out.MaxAttempts = 1
+	}
+	if out.InitialBackoff == 0 {
+		out.InitialBackoff = Duration(defaultRetryInitialBackoff)
+	}
+	if out.MaxBackoff == 0 {
+		out.MaxBackoff = Duration(defaultRetryMaxBackoff)
+	}
+	if out.Multiplier == 0 {
+		out.Multiplier = defaultRetryMultiplier
+	}
+	if len(out.RetryableStatusCodes) == 0 {
+		out.RetryableStatusCodes = defaultRetryableStatusCodes
+	}
+	return out
+}
+
+// backoff returns the delay before retry n (1-based).
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for i := 1; i < n; i++ {
+		d *= p.Multiplier
+		if d >= float64(p.MaxBackoff) {
+			return time.Duration(p.MaxBackoff)
+		}
+	}
+	return time.Duration(d)
+}
+
+// retryable reports whether a failed call may be retried or handed to a
+// fallback. Errors without an HTTP status (connection resets, timeouts) are
+// retryable.
+func (p RetryPolicy) retryable(e *model.ResponseError) bool {
+	status, ok := errorStatus(e)
+	if !ok {
+		return true
+	}
+	for _, code := range p.RetryableStatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// statusInMessage matches the HTTP status in openai-go errors, e.g.
+// `POST "https://.../chat/completions": 429 Too Many Requests`.
+var statusInMessage = regexp.MustCompile(`": ([1-5]\d\d)\b`)
+
+// errorStatus extracts the HTTP status of a failed call from Error.Code
+// (set by the anthropic provider) or from the error message.
+func errorStatus(e *model.ResponseError) (int, bool) {
+	if e.Code != nil {
+		if n, err := strconv.Atoi(*e.Code); err == nil && n >= 100 && n <= 599 {
+			return n, true
+		}
+	}
+	if m := statusInMessage.FindStringSubmatch(e.Message); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n, true
+	}
+	return 0, false
+}
+
+// fallbackModel calls its models in order, retrying each according to the
+// policy. Once a response has been forwarded to the caller the call is
+// committed to that model and later errors are passed through unchanged.
+type fallbackModel struct {
+	models  []model.Model
+	options []GenerationOptions // per model, applied over the request's values
+	policy  RetryPolicy
+}
+
+// newFallbackModel wraps models, primary first, with the retry policy.
+// options holds the generation options each model sets itself; the
+// primary's are already part of the request.
+func newFallbackModel(models []model.Model, options []GenerationOptions, policy *RetryPolicy) model.Model {
+	return &fallbackModel{models: models, options: options, policy: policy.withDefaults()}
+}
+
+func (m *fallbackModel) Info() model.Info {
+	return m.models[0].Info()
+}
+
+func (m *fallbackModel) GenerateContent(ctx context.Context, req *model.Request) (<-chan *model.Response, error) {
+	out := make(chan *model.Response, 16)
+	go func() {
+		defer close(out)
+		m.run(ctx, req, out)
+	}()
+	return out, nil
+}
+
+func (m *fallbackModel) run(ctx context.Context, req *model.Request, out chan<- *model.Response) {
+	var failure *model.Response
+	for i, target := range m.models {
+		name := target.Info().Name
+		targetReq := m.request(i, req)
+		for attempt := 1; attempt <= m.policy.MaxAttempts; attempt++ {
+			if attempt > 1 {
+				delay := m.policy.backoff(attempt - 1)
+				log.Printf("model %s: attempt %d failed (%s), retrying in %s", name, attempt-1, failure.Error.Message, delay)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			var committed bool
+			failure, committed = forward(ctx, target, targetReq, out)
+			if failure == nil || committed {
+				return
+			}
+			if !m.policy.retryable(failure.Error) {
+				sendResponse(ctx, out, failure)
+				return
+			}
+		}
+		if i+1 < len(m.models) {
+			log.Printf("model %s failed after %d attempt(s) (%s), falling back to %s",
+				name, m.policy.MaxAttempts, failure.Error.Message, m.models[i+1].Info().Name)
+		}
+	}
+	sendResponse(ctx, out, failure)
+}
+
+// request returns req with the generation options of model i applied.
+func (m *fallbackModel) request(i int, req *model.Request) *model.Request {
+	if i >= len(m.options) {
+		return req
+	}
+	r := *req
+	r.GenerationConfig = m.options[i].Apply(r.GenerationConfig)
+	return &r
+}
+
+// forward runs one call and passes its responses to out. It returns the
+// failure response when the call failed before anything was forwarded, and
+// committed=true once a response has been forwarded.
+func forward(ctx context.Context, target model.Model, req *model.Request, out chan<- *model.Response) (failure *model.Response, committed bool) {
+	ch, err := target.GenerateContent(ctx, req)
+	if err != nil {
+		return errorResponse(model.ErrorTypeAPIError, err.Error()), false
+	}
+	for resp := range ch {
+		if resp == nil {
+			continue
+		}
+		if resp.Error != nil && !committed {
+			failure = resp
+			continue // drain
+		}
+		if failure != nil {
+			continue
+		}
+		committed = true
+		if !sendResponse(ctx, out, resp) {
+			for range ch {
+			}
+			return nil, true
+		}
+	}
+	return failure, committed
+}
+
+// modelChain returns the configs to try in order: cfg itself followed by
+// its fallbacks, each inheriting unset fields from cfg.
+func (cfg Config) modelChain() []Config {
+	base := cfg
+	base.Fallbacks = nil
+	base.Retry = nil
+	chain := []Config{base}
+	for _, fb := range cfg.Fallbacks {
+		chain = append(chain, fb.Inherit(base))
+	}
+	return chain
+}
@@ -0,0 +1,148 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// openaiStub answers chat completions with the given statuses in turn and
+// with a completion once they run out. It records the decoded requests.
+type openaiStub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []map[string]any
+}
+
+func newOpenAIStub(t *testing.T, reply string, statuses ...int) *openaiStub {
+	t.Helper()
+	s := &openaiStub{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		s.mu.Lock()
+		n := len(s.requests)
+		s.requests = append(s.requests, body)
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if n < len(s.statuses) {
+			w.WriteHeader(s.statuses[n])
+			fmt.Fprintf(w, `{"error":{"message":"%s","type":"server_error"}}`, http.StatusText(s.statuses[n]))
+			return
+		}
+		fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, reply)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *openaiStub) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func loadTestConfig(t *testing.T, raw string) Config {
+	t.Helper()
+	t.Setenv("TEST_OPENAI_KEY", "test-key")
+	var cfg Config
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		t.Fatalf("unmarshal config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	return cfg
+}
+
+func TestFallbackRetriesThenFallsBack(t *testing.T) {
+	primary := newOpenAIStub(t, "from primary", http.StatusTooManyRequests, http.StatusServiceUnavailable)
+	fallback := newOpenAIStub(t, "from fallback")
+	cfg := loadTestConfig(t, fmt.Sprintf(`{
+		"provider": "openai",
+		"model": "gpt-primary",
+		"base_url": %q,
+		"api_key_env": "env:TEST_OPENAI_KEY",
+		"temperature": 0.2,
+		"max_tokens": 100,
+		"retry": {"max_attempts": 2, "initial_backoff": "1ms"},
+		"fallbacks": [{"model": "gpt-fallback", "base_url": %q, "temperature": 0.9}]
+	}`, primary.URL, fallback.URL))
+
+	m, gen, err := NewModelFromConfig(cfg, false)
+	if err != nil {
+		t.Fatalf("NewModelFromConfig: %v", err)
+	}
+	resps := collect(t, mustGenerate(t, m, &model.Request{
+		Messages:         []model.Message{model.NewUserMessage("hi")},
+		GenerationConfig: gen,
+	}))
+
+	last := resps[len(resps)-1]
+	if last.Error != nil || last.Choices[0].Message.Content != "from fallback" {
+		t.Fatalf("response = %+v", last)
+	}
+	if got := primary.calls(); got != 2 {
+		t.Errorf("primary calls = %d, want 2 (429, then 503)", got)
+	}
+	if got := fallback.calls(); got != 1 {
+		t.Fatalf("fallback calls = %d, want 1", got)
+	}
+
+	if got := primary.requests[0]["temperature"]; got != 0.2 {
+		t.Errorf("primary temperature = %v, want 0.2", got)
+	}
+	req := fallback.requests[0]
+	if req["model"] != "gpt-fallback" || req["temperature"] != 0.9 {
+		t.Errorf("fallback model = %v, temperature = %v, want gpt-fallback and its own 0.9", req["model"], req["temperature"])
+	}
+	if req["max_tokens"] != 100.0 && req["max_completion_tokens"] != 100.0 {
+		t.Errorf("fallback request %v does not keep the primary's max_tokens", req)
+	}
+}
+
+func TestFallbackStopsOnNonRetryableStatus(t *testing.T) {
+	primary := newOpenAIStub(t, "from primary", http.StatusBadRequest)
+	fallback := newOpenAIStub(t, "from fallback")
+	cfg := loadTestConfig(t, fmt.Sprintf(`{
+		"provider": "openai",
+		"model": "gpt-primary",
+		"base_url": %q,
+		"api_key_env": "env:TEST_OPENAI_KEY",
+		"retry": {"max_attempts": 3, "initial_backoff": "1ms"},
+		"fallbacks": [{"base_url": %q}]
+	}`, primary.URL, fallback.URL))
+
+	m, gen, err := NewModelFromConfig(cfg, false)
+	if err != nil {
+		t.Fatalf("NewModelFromConfig: %v", err)
+	}
+	resps := collect(t, mustGenerate(t, m, &model.Request{
+		Messages:         []model.Message{model.NewUserMessage("hi")},
+		GenerationConfig: gen,
+	}))
+
+	last := resps[len(resps)-1]
+	if last.Error == nil {
+		t.Fatalf("response = %+v, want an error", last)
+	}
+	// The status is only known from the openai-go error text; if its
+	// format changes the error counts as retryable and this fails.
+	if status, ok := errorStatus(last.Error); !ok || status != http.StatusBadRequest {
+		t.Errorf("errorStatus(%q) = %d, %v, want 400", last.Error.Message, status, ok)
+	}
+	if !strings.Contains(last.Error.Message, "400") {
+		t.Errorf("error message = %q", last.Error.Message)
+	}
+	if primary.calls() != 1 || fallback.calls() != 0 {
+		t.Errorf("calls = primary %d, fallback %d, want 1 and 0", primary.calls(), fallback.calls())
+	}
+}
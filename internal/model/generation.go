@@ -0,0 +1,184 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// maxStopSequences mirrors the limit of the OpenAI chat completions API.
+const maxStopSequences = 4
+
+// GenerationOptions are optional generation parameters. They are flattened
+// into Config and used standalone for sub-agent and graph node overrides.
+type GenerationOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`       // 0.0 - 2.0
+	TopP             *float64 `json:"top_p,omitempty"`             // 0.0 - 1.0
+	MaxTokens        *int     `json:"max_tokens,omitempty"`        // > 0
+	Stop             []string `json:"stop,omitempty"`              // up to 4 sequences
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`  // -2.0 - 2.0
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"` // -2.0 - 2.0
+	ReasoningEffort  *string  `json:"reasoning_effort,omitempty"`  // "low", "medium" or "high"
+}
+
+// Apply returns gen with every set option copied over. A nil receiver
+// returns gen unchanged.
+func (o *GenerationOptions) Apply(gen model.GenerationConfig) model.GenerationConfig {
+	if o == nil {
+		return gen
+	}
+	if o.Temperature != nil {
+		gen.Temperature = o.Temperature
+	}
+	if o.TopP != nil {
+		gen.TopP = o.TopP
+	}
+	if o.MaxTokens != nil {
+		gen.MaxTokens = o.MaxTokens
+	}
+	if len(o.Stop) > 0 {
+		gen.Stop = append([]string(nil), o.Stop...)
+	}
+	if o.PresencePenalty != nil {
+		gen.PresencePenalty = o.PresencePenalty
+	}
+	if o.FrequencyPenalty != nil {
+		gen.FrequencyPenalty = o.FrequencyPenalty
+	}
+	if o.ReasoningEffort != nil {
+		gen.ReasoningEffort = o.ReasoningEffort
+	}
+	return gen
+}
+
+// Inherit fills the unset options of o from parent.
+func (o GenerationOptions) Inherit(parent GenerationOptions) GenerationOptions {
+	if o.Temperature == nil {
+		o.Temperature = parent.Temperature
+	}
+	if o.TopP == nil {
+		o.TopP = parent.TopP
+	}
+	if o.MaxTokens == nil {
+		o.MaxTokens = parent.MaxTokens
+	}
+	if o.Stop == nil {
+		o.Stop = parent.Stop
+	}
+	if o.PresencePenalty == nil {
+		o.PresencePenalty = parent.PresencePenalty
+	}
+	if o.FrequencyPenalty == nil {
+		o.FrequencyPenalty = parent.FrequencyPenalty
+	}
+	if o.ReasoningEffort == nil {
+		o.ReasoningEffort = parent.ReasoningEffort
+	}
+	return o
+}
+
+// unsupportedOptions lists the options a provider's API has no equivalent
+// for. Configs setting them are rejected instead of partly ignored.
+var unsupportedOptions = map[string][]string{
+	ProviderAnthropic: {"presence_penalty", "frequency_penalty", "reasoning_effort"},
+}
+
+// providerRanges narrows the ranges checked by Validate for providers
+// whose API accepts less than OpenAI's.
+var providerRanges = map[string]map[string][2]float64{
+	ProviderAnthropic: {"temperature": {0, 1}},
+}
+
+// ValidateFor checks o like Validate and also rejects options that one of
+// providers does not support or sets outside the provider's range.
+func (o *GenerationOptions) ValidateFor(providers ...string) error {
+	errs := []error{o.Validate()}
+	if o == nil {
+		return errs[0]
+	}
+	seen := make(map[string]bool, len(providers))
+	for _, p := range providers {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		for _, name := range unsupportedOptions[p] {
+			if o.isSet(name) {
+				errs = append(errs, fmt.Errorf("%s is not supported by provider %s", name, p))
+			}
+		}
+		for name, r := range providerRanges[p] {
+			if v := o.float(name); v != nil && (*v < r[0] || *v > r[1]) {
+				errs = append(errs, fmt.Errorf("%s must be between %g and %g for provider %s, got %g", name, r[0], r[1], p, *v))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// float returns the value of a numeric option by its JSON name.
+func (o *GenerationOptions) float(name string) *float64 {
+	switch name {
+	case "temperature":
+		return o.Temperature
+	case "top_p":
+		return o.TopP
+	case "presence_penalty":
+		return o.PresencePenalty
+	case "frequency_penalty":
+		return o.FrequencyPenalty
+	}
+	return nil
+}
+
+func (o *GenerationOptions) isSet(name string) bool {
+	switch name {
+	case "presence_penalty":
+		return o.PresencePenalty != nil
+	case "frequency_penalty":
+		return o.FrequencyPenalty != nil
+	case "reasoning_effort":
+		return o.ReasoningEffort != nil
+	}
+	return false
+}
+
+// Validate checks that every set option is within its allowed range.
+func (o *GenerationOptions) Validate() error {
+	if o == nil {
+		return nil
+	}
+
+	var errs []error
+	checkRange := func(name string, v *float64, lo, hi float64) {
+		if v != nil && (*v < lo || *v > hi) {
+			errs = append(errs, fmt.Errorf("%s must be between %g and %g, got %g", name, lo, hi, *v))
+		}
+	}
+	checkRange("temperature", o.Temperature, 0, 2)
+	checkRange("top_p", o.TopP, 0, 1)
+	checkRange("presence_penalty", o.PresencePenalty, -2, 2)
+	checkRange("frequency_penalty", o.FrequencyPenalty, -2, 2)
+
+	if o.MaxTokens != nil && *o.MaxTokens <= 0 {
+		errs = append(errs, fmt.Errorf("max_tokens must be > 0, got %d", *o.MaxTokens))
+	}
+	if len(o.Stop) > maxStopSequences {
+		errs = append(errs, fmt.Errorf("stop allows at most %d sequences, got %d", maxStopSequences, len(o.Stop)))
+	}
+	for i, s := range o.Stop {
+		if s == "" {
+			errs = append(errs, fmt.Errorf("stop[%d] must not be empty", i))
+		}
+	}
+	if o.ReasoningEffort != nil {
+		switch *o.ReasoningEffort {
+		case "low", "medium", "high":
+		default:
+			errs = append(errs, fmt.Errorf("reasoning_effort must be low, medium or high, got %q", *o.ReasoningEffort))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// SecretSchemePool draws a key per call from the key source, e.g.
+// "pool:openai" for the CLIProxy keys of provider openai.
+const SecretSchemePool = "pool"
+
+// maxKeyRotations bounds how many keys one call tries after 401/429.
+const maxKeyRotations = 5
+
+// KeySource hands out API keys per call and learns from their failures.
+// The CLIProxy key pool (internal/keypool) implements it.
+type KeySource interface {
+	Acquire(ctx context.Context, provider string) (keyID, secret string, err error)
+	Report(ctx context.Context, keyID string, status int)
+}
+
+var (
+	keySourceMu sync.RWMutex
+	keySource   KeySource
+)
+
+// SetKeySource installs the source used for "pool:" references.
+func SetKeySource(ks KeySource) {
+	keySourceMu.Lock()
+	defer keySourceMu.Unlock()
+	keySource = ks
+}
+
+func currentKeySource() KeySource {
+	keySourceMu.RLock()
+	defer keySourceMu.RUnlock()
+	return keySource
+}
+
+// pooledModel builds a provider client per call with a key from the key
+// source. When the provider rejects the key (401, 403, 429) before any
+// output was forwarded, the key is reported and the call is repeated with
+// the next key.
+type pooledModel struct {
+	cfg     Config
+	pool    string
+	factory ProviderFunc
+	source  KeySource
+}
+
+func newPooledModel(cfg Config, pool string, factory ProviderFunc) (model.Model, error) {
+	source := currentKeySource()
+	if source == nil {
+		return nil, fmt.Errorf("api_key_env: pool: key pool not available (is DATABASE_URL set?)")
+	}
+	return &pooledModel{cfg: cfg, pool: pool, factory: factory, source: source}, nil
+}
+
+func (m *pooledModel) Info() model.Info {
+	return model.Info{Name: m.cfg.Model}
+}
+
+func (m *pooledModel) GenerateContent(ctx context.Context, req *model.Request) (<-chan *model.Response, error) {
+	out := make(chan *model.Response, 16)
+	go func() {
+		defer close(out)
+		m.run(ctx, req, out)
+	}()
+	return out, nil
+}
+
+func (m *pooledModel) run(ctx context.Context, req *model.Request, out chan<- *model.Response) {
+	for rotation := 0; ; rotation++ {
+		keyID, secret, err := m.source.Acquire(ctx, m.pool)
+		if err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeAPIError, err.Error()))
+			return
+		}
+
+		cfg := m.cfg
+		cfg.apiKeyOverride = secret
+		inner, err := m.factory(cfg)
+		if err != nil {
+			sendResponse(ctx, out, errorResponse(model.ErrorTypeAPIError, err.Error()))
+			return
+		}
+
+		inner = &attributedModel{Model: inner, attr: Attribution{Provider: cfg.Provider, KeyID: keyID}}
+
+		failure, committed := forward(ctx, inner, req, out)
+		if failure == nil || committed {
+			return
+		}
+		status, _ := errorStatus(failure.Error)
+		m.source.Report(ctx, keyID, status)
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+			if rotation < maxKeyRotations {
+				continue
+			}
+		}
+		sendResponse(ctx, out, failure)
+		return
+	}
+}
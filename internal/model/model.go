@@ -1,75 +1,197 @@
 package model
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
 
 	"trpc.group/trpc-go/trpc-agent-go/model"
-	"trpc.group/trpc-go/trpc-agent-go/model/openai"
 )
 
 // Config describes how to construct a Model instance.
 type Config struct {
-	Provider  string `json:"provider"`              // e.g. "openai"
+	Provider  string `json:"provider"`              // e.g. "openai", "anthropic", "ollama"
 	Model     string `json:"model"`                 // e.g. "gpt-4o-mini"
 	BaseURL   string `json:"base_url,omitempty"`    // optional override (per-agent)
-	APIKeyEnv string `json:"api_key_env,omitempty"` // env var name OR direct key (als hij met sk- begint)
+	APIKeyEnv string `json:"api_key_env,omitempty"` // secret reference: env:NAME, file:/path, cliproxy:<key-id> or a bare env var name
+
+	// Generation parameters, flattened into the model JSON object
+	// (e.g. "temperature": 0.2).
+	GenerationOptions
+
+	// Fallbacks are tried in order when this model keeps failing with a
+	// retryable error. Unset fields are inherited from this config.
+	Fallbacks []Config `json:"fallbacks,omitempty"`
+	// Retry applies to this model and each fallback; nil means one attempt.
+	Retry *RetryPolicy `json:"retry,omitempty"`
+
+	// apiKeyOverride is the key drawn from the key pool for one call.
+	apiKeyOverride string
 }
 
-// NewModelFromConfig builds a model.Model and a basic GenerationConfig.
-func NewModelFromConfig(cfg Config, stream bool) (model.Model, model.GenerationConfig, error) {
-	switch cfg.Provider {
-	case "openai":
-		opts := []openai.Option{}
+// Inherit fills the unset fields of cfg from parent. When cfg switches to a
+// different provider only the provider defaults apply, so base URL and key
+// are not carried across providers.
+func (cfg Config) Inherit(parent Config) Config {
+	if cfg.Provider != "" && cfg.Provider != parent.Provider {
+		return cfg
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = parent.Provider
+	}
+	if cfg.Model == "" {
+		cfg.Model = parent.Model
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = parent.BaseURL
+	}
+	if cfg.APIKeyEnv == "" {
+		cfg.APIKeyEnv = parent.APIKeyEnv
+	}
+	if cfg.Fallbacks == nil {
+		cfg.Fallbacks = parent.Fallbacks
+	}
+	if cfg.Retry == nil {
+		cfg.Retry = parent.Retry
+	}
+	cfg.GenerationOptions = cfg.GenerationOptions.Inherit(parent.GenerationOptions)
+	return cfg
+}
 
-		// 1) Base URL: JSON override > env var OPENAI_BASE_URL
-		baseURL := cfg.BaseURL
-		if baseURL == "" {
-			if envURL := os.Getenv("OPENAI_BASE_URL"); envURL != "" {
-				baseURL = envURL
-			}
+// Validate checks the static parts of the config. It does not resolve
+// API keys, which may legitimately be absent at load time.
+func (cfg Config) Validate() error {
+	if cfg.Provider == "" {
+		return fmt.Errorf("model provider is required")
+	}
+	if _, ok := lookupProvider(cfg.Provider); !ok {
+		return fmt.Errorf("unsupported model provider: %s (registered: %s)",
+			cfg.Provider, strings.Join(Providers(), ", "))
+	}
+	if cfg.Model == "" {
+		return fmt.Errorf("model name is required")
+	}
+	if err := validateSecretRef(cfg.APIKeyEnv); err != nil {
+		return err
+	}
+	if err := cfg.Retry.Validate(); err != nil {
+		return err
+	}
+	chain := cfg.modelChain()
+	for i, fb := range cfg.Fallbacks {
+		if len(fb.Fallbacks) > 0 || fb.Retry != nil {
+			return fmt.Errorf("fallbacks[%d]: fallbacks and retry are only allowed on the primary model", i)
+		}
+		if err := chain[i+1].Validate(); err != nil {
+			return fmt.Errorf("fallbacks[%d]: %w", i, err)
 		}
-		if baseURL != "" {
-			opts = append(opts, openai.WithBaseURL(baseURL))
+	}
+	if err := cfg.GenerationOptions.ValidateFor(cfg.Provider); err != nil {
+		return err
+	}
+	for i, fb := range chain[1:] {
+		// A fallback is sent the primary's options it does not set itself.
+		opts := fb.GenerationOptions.Inherit(cfg.GenerationOptions)
+		if err := opts.ValidateFor(fb.Provider); err != nil {
+			return fmt.Errorf("fallbacks[%d]: %w", i, err)
 		}
+	}
+	return nil
+}
 
-		// 2) API key:
-		//    - Als cfg.APIKeyEnv met "sk-" begint -> behandel het als directe key.
-		//    - Anders: zie het als env-var naam en lees os.Getenv(name).
-		var apiKey string
-		var apiKeyEnv string
+// Providers returns the providers of cfg and its fallbacks. Generation
+// options of cfg are sent to all of them.
+func (cfg Config) Providers() []string {
+	chain := cfg.modelChain()
+	out := make([]string, 0, len(chain))
+	for _, c := range chain {
+		out = append(out, c.Provider)
+	}
+	return out
+}
 
-		if cfg.APIKeyEnv != "" {
-			if strings.HasPrefix(cfg.APIKeyEnv, "sk-") {
-				// directe key in config
-				apiKey = cfg.APIKeyEnv
-			} else {
-				apiKeyEnv = cfg.APIKeyEnv
-				apiKey = os.Getenv(apiKeyEnv)
+// NewModelFromConfig builds a model.Model and its GenerationConfig. When
+// cfg declares fallbacks or a retry policy the returned model wraps them.
+func NewModelFromConfig(cfg Config, stream bool) (model.Model, model.GenerationConfig, error) {
+	chain := cfg.modelChain()
+	models := make([]model.Model, 0, len(chain))
+	for i, c := range chain {
+		m, err := newProviderModel(c)
+		if err != nil {
+			if i > 0 {
+				err = fmt.Errorf("fallbacks[%d]: %w", i-1, err)
 			}
-		} else {
-			apiKeyEnv = "OPENAI_API_KEY"
-			apiKey = os.Getenv(apiKeyEnv)
+			return nil, model.GenerationConfig{}, err
 		}
+		models = append(models, m)
+	}
 
-		if apiKey == "" {
-			if apiKeyEnv == "" {
-				return nil, model.GenerationConfig{}, fmt.Errorf("missing OpenAI API key (no env and no direct key)")
-			}
-			return nil, model.GenerationConfig{}, fmt.Errorf("missing OpenAI API key, env %s is empty", apiKeyEnv)
+	m := models[0]
+	if len(models) > 1 || cfg.Retry != nil {
+		// Fallbacks apply only the options they set themselves so that
+		// sub-agent and node overrides of the primary's values survive.
+		options := make([]GenerationOptions, len(models))
+		for i, fb := range cfg.Fallbacks {
+			options[i+1] = fb.GenerationOptions
 		}
+		m = newFallbackModel(models, options, cfg.Retry)
+	}
+	gen := cfg.GenerationOptions.Apply(model.GenerationConfig{
+		Stream: stream,
+	})
+	return m, gen, nil
+}
 
-		opts = append(opts, openai.WithAPIKey(apiKey))
-
-		// 3) Model client + generation config
-		m := openai.New(cfg.Model, opts...)
-		gen := model.GenerationConfig{
-			Stream: stream,
+func newProviderModel(cfg Config) (model.Model, error) {
+	factory, ok := lookupProvider(cfg.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unsupported model provider: %s", cfg.Provider)
+	}
+	attr := Attribution{Provider: cfg.Provider}
+	if scheme, ref, ok := splitSecretRef(cfg.APIKeyEnv); ok {
+		switch scheme {
+		case SecretSchemePool:
+			return newPooledModel(cfg, ref, factory)
+		case SecretSchemeCLIProxy:
+			attr.KeyID = ref
 		}
-		return m, gen, nil
+	}
+	m, err := factory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &attributedModel{Model: m, attr: attr}, nil
+}
+
+// baseURL returns the configured base URL, falling back to the env var
+// envName and then to def.
+func (cfg Config) baseURL(envName, def string) string {
+	if cfg.BaseURL != "" {
+		return cfg.BaseURL
+	}
+	if envURL := os.Getenv(envName); envURL != "" {
+		return envURL
+	}
+	return def
+}
 
-	default:
-		return nil, model.GenerationConfig{}, fmt.Errorf("unsupported model provider: %s", cfg.Provider)
+// apiKey resolves the API key reference in cfg.APIKeyEnv, or the env var
+// defaultEnv when it is unset. source names where the key came from and is
+// safe to log; the key itself never appears in errors.
+func (cfg Config) apiKey(defaultEnv string) (key, source string, err error) {
+	if cfg.apiKeyOverride != "" {
+		return cfg.apiKeyOverride, "key pool", nil
+	}
+	if cfg.APIKeyEnv == "" {
+		// The default name comes from code, so it is safe to show.
+		key, _, err := ResolveSecret(context.Background(), SecretSchemeEnv+":"+defaultEnv)
+		return key, "env " + defaultEnv, err
 	}
+	return ResolveSecret(context.Background(), cfg.APIKeyEnv)
+}
+
+// missingKeyError reports an unresolved API key without echoing any value.
+func missingKeyError(provider, source string) error {
+	return fmt.Errorf("missing %s API key from %s", provider, source)
 }
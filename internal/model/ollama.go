@@ -0,0 +1,37 @@
+package model
+
+import (
+	"trpc.group/trpc-go/trpc-agent-go/model"
+	"trpc.group/trpc-go/trpc-agent-go/model/openai"
+)
+
+// ProviderOllama is a local OpenAI-compatible server such as Ollama,
+// LM Studio or vLLM.
+const ProviderOllama = "ollama"
+
+// defaultOllamaBaseURL is Ollama's OpenAI-compatible endpoint.
+const defaultOllamaBaseURL = "http://localhost:11434/v1"
+
+func init() {
+	RegisterProvider(ProviderOllama, newOllamaModel)
+}
+
+// newOllamaModel builds an OpenAI-compatible client for a local server.
+// Base URL: JSON override > env var OLLAMA_BASE_URL > localhost:11434. An
+// API key is optional; only api_key_env (default OLLAMA_API_KEY) is read,
+// never OPENAI_API_KEY.
+func newOllamaModel(cfg Config) (model.Model, error) {
+	apiKey, _, err := cfg.apiKey("OLLAMA_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		// The client insists on a key; local servers ignore it.
+		apiKey = "ollama"
+	}
+	return openai.New(cfg.Model,
+		openai.WithBaseURL(cfg.baseURL("OLLAMA_BASE_URL", defaultOllamaBaseURL)),
+		openai.WithAPIKey(apiKey),
+		noSDKRetries,
+	), nil
+}
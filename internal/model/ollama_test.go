@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+const chatCompletion = `{"id":"chatcmpl-1","object":"chat.completion","model":"llama3","choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}]}`
+
+// ollamaStub answers chat completions and records the request URL and
+// Authorization header.
+func ollamaStub(t *testing.T, gotPath, gotAuth *string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*gotPath = r.URL.Path
+		*gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, chatCompletion)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOllamaWithoutKey(t *testing.T) {
+	t.Setenv("OLLAMA_API_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "must-not-be-sent")
+	var path, auth string
+	srv := ollamaStub(t, &path, &auth)
+
+	m, err := newOllamaModel(Config{Provider: ProviderOllama, Model: "llama3", BaseURL: srv.URL + "/v1"})
+	if err != nil {
+		t.Fatalf("newOllamaModel: %v", err)
+	}
+	resps := collect(t, mustGenerate(t, m, &model.Request{Messages: []model.Message{model.NewUserMessage("hi")}}))
+	if r := resps[len(resps)-1]; r.Error != nil || r.Choices[0].Message.Content != "hi" {
+		t.Fatalf("response = %+v", r)
+	}
+	if path != "/v1/chat/completions" {
+		t.Errorf("path = %s, want /v1/chat/completions", path)
+	}
+	if auth != "Bearer ollama" {
+		t.Errorf("Authorization = %q, want the placeholder key", auth)
+	}
+}
+
+func TestOllamaKeyAndBaseURLFromEnv(t *testing.T) {
+	var path, auth string
+	srv := ollamaStub(t, &path, &auth)
+	t.Setenv("OLLAMA_API_KEY", "local-key")
+	t.Setenv("OLLAMA_BASE_URL", srv.URL+"/v1")
+
+	m, err := newOllamaModel(Config{Provider: ProviderOllama, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("newOllamaModel: %v", err)
+	}
+	collect(t, mustGenerate(t, m, &model.Request{Messages: []model.Message{model.NewUserMessage("hi")}}))
+	if path != "/v1/chat/completions" || auth != "Bearer local-key" {
+		t.Errorf("path = %s, Authorization set to OLLAMA_API_KEY = %v", path, auth == "Bearer local-key")
+	}
+}
+
+// redirectTransport sends every request to target and records the URL it
+// was addressed to.
+type redirectTransport struct {
+	target *url.URL
+	base   http.RoundTripper
+	got    *url.URL
+}
+
+func (rt *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	rt.got = &u
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return rt.base.RoundTrip(req)
+}
+
+func TestOllamaDefaultBaseURL(t *testing.T) {
+	t.Setenv("OLLAMA_API_KEY", "")
+	t.Setenv("OLLAMA_BASE_URL", "")
+	var path, auth string
+	srv := ollamaStub(t, &path, &auth)
+	target, _ := url.Parse(srv.URL)
+	rt := &redirectTransport{target: target, base: http.DefaultTransport}
+
+	orig := model.DefaultNewHTTPClient
+	model.DefaultNewHTTPClient = func(...model.HTTPClientOption) model.HTTPClient {
+		return &http.Client{Transport: rt}
+	}
+	t.Cleanup(func() { model.DefaultNewHTTPClient = orig })
+
+	m, err := newOllamaModel(Config{Provider: ProviderOllama, Model: "llama3"})
+	if err != nil {
+		t.Fatalf("newOllamaModel: %v", err)
+	}
+
+	collect(t, mustGenerate(t, m, &model.Request{Messages: []model.Message{model.NewUserMessage("hi")}}))
+	if rt.got == nil {
+		t.Fatal("no request sent")
+	}
+	if got := rt.got.Scheme + "://" + rt.got.Host + rt.got.Path; got != "http://localhost:11434/v1/chat/completions" {
+		t.Errorf("request URL = %s, want the default Ollama endpoint", got)
+	}
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	openaiopt "github.com/openai/openai-go/option"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+	"trpc.group/trpc-go/trpc-agent-go/model/openai"
+)
+
+// ProviderOpenAI is the OpenAI(-compatible) chat completions provider.
+const ProviderOpenAI = "openai"
+
+func init() {
+	RegisterProvider(ProviderOpenAI, newOpenAIModel)
+}
+
+func newOpenAIModel(cfg Config) (model.Model, error) {
+	opts := []openai.Option{}
+
+	// 1) Base URL: JSON override > env var OPENAI_BASE_URL
+	if baseURL := cfg.baseURL("OPENAI_BASE_URL", ""); baseURL != "" {
+		opts = append(opts, openai.WithBaseURL(baseURL))
+	}
+
+	// 2) API key: secret reference in api_key_env (default env:OPENAI_API_KEY)
+	apiKey, source, err := cfg.apiKey("OPENAI_API_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" {
+		return nil, missingKeyError("OpenAI", source)
+	}
+	opts = append(opts, openai.WithAPIKey(apiKey))
+
+	// 3) Retries are left to RetryPolicy, see noSDKRetries.
+	opts = append(opts, noSDKRetries)
+
+	return openai.New(cfg.Model, opts...), nil
+}
+
+// noSDKRetries turns off the client's own retries of 408, 429 and 5xx
+// responses. They would hide failures from RetryPolicy and delay fallbacks.
+var noSDKRetries = openai.WithOpenAIOptions(openaiopt.WithMaxRetries(0))
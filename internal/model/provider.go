@@ -0,0 +1,45 @@
+package model
+
+import (
+	"sort"
+	"sync"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// ProviderFunc builds a model client for cfg. Generation parameters are
+// applied by NewModelFromConfig and need not be handled by providers.
+type ProviderFunc func(cfg Config) (model.Model, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]ProviderFunc{}
+)
+
+// RegisterProvider makes a provider available under name, replacing any
+// provider previously registered under the same name. Built-in providers
+// register themselves in init.
+func RegisterProvider(name string, fn ProviderFunc) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = fn
+}
+
+// Providers returns the registered provider names, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupProvider(name string) (ProviderFunc, bool) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	fn, ok := providers[name]
+	return fn, ok
+}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret reference schemes accepted in Config.APIKeyEnv. A value without a
+// scheme is an environment variable name. SecretSchemePool is declared with
+// the key source.
+const (
+	SecretSchemeEnv      = "env"      // env:OPENAI_API_KEY
+	SecretSchemeFile     = "file"     // file:/run/secrets/openai
+	SecretSchemeCLIProxy = "cliproxy" // cliproxy:<key-id> from cliproxy_api_keys
+)
+
+// secretResolveTimeout bounds resolvers that reach external stores.
+const secretResolveTimeout = 5 * time.Second
+
+// SecretResolver returns the secret stored under ref, the part after
+// "<scheme>:". Errors must not contain the secret.
+type SecretResolver func(ctx context.Context, ref string) (string, error)
+
+var (
+	secretResolversMu sync.RWMutex
+	secretResolvers   = map[string]SecretResolver{}
+)
+
+// RegisterSecretResolver installs the resolver for a scheme such as
+// "cliproxy". env and file are built in.
+func RegisterSecretResolver(scheme string, fn SecretResolver) {
+	secretResolversMu.Lock()
+	defer secretResolversMu.Unlock()
+	secretResolvers[scheme] = fn
+}
+
+var (
+	// literalKeyPattern matches well-known API key prefixes.
+	literalKeyPattern = regexp.MustCompile(`^(sk-|sk_|xai-|gsk_|AIza)`)
+	envNamePattern    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	schemeRefPattern  = regexp.MustCompile(`^[a-z]+:`)
+)
+
+// IsLiteralSecret reports whether ref is a plain API key instead of a secret
+// reference: it has a known key prefix, or is neither a scheme reference nor
+// an environment variable name.
+func IsLiteralSecret(ref string) bool {
+	if ref == "" {
+		return false
+	}
+	if literalKeyPattern.MatchString(ref) {
+		return true
+	}
+	return !schemeRefPattern.MatchString(ref) && !envNamePattern.MatchString(ref)
+}
+
+// splitSecretRef splits "scheme:value" for the known and registered
+// schemes.
+func splitSecretRef(ref string) (scheme, value string, ok bool) {
+	scheme, value, found := strings.Cut(ref, ":")
+	if !found {
+		return "", "", false
+	}
+	switch scheme {
+	case SecretSchemeEnv, SecretSchemeFile, SecretSchemeCLIProxy, SecretSchemePool:
+		return scheme, value, true
+	}
+	secretResolversMu.RLock()
+	defer secretResolversMu.RUnlock()
+	_, registered := secretResolvers[scheme]
+	return scheme, value, registered
+}
+
+// validateSecretRef checks Config.APIKeyEnv with ValidateSecretRef.
+func validateSecretRef(ref string) error {
+	if err := ValidateSecretRef(ref); err != nil {
+		return fmt.Errorf("api_key_env: %w", err)
+	}
+	return nil
+}
+
+// ValidateSecretRef checks the syntax of a reference without resolving it.
+// Literal keys are accepted here; callers decide whether to warn or fail.
+func ValidateSecretRef(ref string) error {
+	if ref == "" || IsLiteralSecret(ref) {
+		return nil
+	}
+	scheme, value, ok := splitSecretRef(ref)
+	if !ok {
+		if schemeRefPattern.MatchString(ref) {
+			scheme, _, _ = strings.Cut(ref, ":")
+			return fmt.Errorf("unknown secret scheme %q (want env:, file:, cliproxy: or pool:)", scheme)
+		}
+		return nil // bare env var name
+	}
+	switch {
+	case value == "":
+		return fmt.Errorf("%s: reference is empty", scheme)
+	case scheme == SecretSchemeEnv && !envNamePattern.MatchString(value):
+		// The value may be a pasted key, so it is not echoed.
+		return fmt.Errorf("env: is not followed by a valid environment variable name")
+	case scheme == SecretSchemeFile && !strings.HasPrefix(value, "/"):
+		return fmt.Errorf("file: path must be absolute")
+	}
+	return nil
+}
+
+// ResolveSecret resolves a secret reference. source describes where the
+// secret came from without revealing it, for use in error messages.
+func ResolveSecret(ctx context.Context, ref string) (secret, source string, err error) {
+	if IsLiteralSecret(ref) {
+		return ref, "literal key in config", nil
+	}
+	scheme, value, ok := splitSecretRef(ref)
+	if !ok {
+		scheme, value = SecretSchemeEnv, ref
+	}
+
+	switch scheme {
+	case SecretSchemePool:
+		return "", "key pool " + value, fmt.Errorf("pool: references are resolved per model call")
+	case SecretSchemeEnv:
+		v, set := os.LookupEnv(value)
+		return v, envSource(value, set), nil
+	case SecretSchemeFile:
+		b, err := os.ReadFile(value)
+		if err != nil {
+			// Only the path and the error kind; never file contents.
+			return "", "file " + value, fmt.Errorf("read secret file %s: %w", value, unwrapPathError(err))
+		}
+		return strings.TrimSpace(string(b)), "file " + value, nil
+	}
+
+	secretResolversMu.RLock()
+	fn := secretResolvers[scheme]
+	secretResolversMu.RUnlock()
+	source = scheme + " key " + value
+	if fn == nil {
+		return "", source, fmt.Errorf("secret scheme %s: not available (is DATABASE_URL set?)", scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, secretResolveTimeout)
+	defer cancel()
+	secret, err = fn(ctx, value)
+	if err != nil {
+		return "", source, fmt.Errorf("resolve %s: %w", source, err)
+	}
+	return secret, source, nil
+}
+
+// envSource describes an environment variable reference. The name is only
+// shown for a variable that exists: a "name" that is not set may well be a
+// pasted key.
+func envSource(name string, set bool) string {
+	if !set {
+		return "an unset environment variable"
+	}
+	return "env " + name
+}
+
+// unwrapPathError drops the *os.PathError wrapper, whose message would
+// repeat the path.
+func unwrapPathError(err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
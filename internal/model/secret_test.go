@@ -0,0 +1,56 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMissingKeyErrorsDoNotContainKeys(t *testing.T) {
+	keys := []string{
+		"abcdef0123456789ABCDEF0123456789", // no known prefix, looks like an env var name
+		"sk-proj-0123456789abcdef",
+		"key_with_underscores_0123456789",
+	}
+	for _, key := range keys {
+		for _, ref := range []string{key, "env:" + key} {
+			t.Run(ref, func(t *testing.T) {
+				for name, build := range map[string]func(Config) error{
+					"openai":    func(cfg Config) error { _, err := newOpenAIModel(cfg); return err },
+					"anthropic": func(cfg Config) error { _, err := newAnthropicModel(cfg); return err },
+				} {
+					err := build(Config{Provider: name, Model: "m", APIKeyEnv: ref})
+					if err != nil && strings.Contains(err.Error(), key) {
+						t.Errorf("%s error %q contains the key", name, err)
+					}
+				}
+				if _, source, _ := ResolveSecret(context.Background(), ref); strings.Contains(source, key) {
+					t.Errorf("source %q contains the key", source)
+				}
+				if err := ValidateSecretRef(ref); err != nil && strings.Contains(err.Error(), key) {
+					t.Errorf("ValidateSecretRef error %q contains the key", err)
+				}
+			})
+		}
+	}
+}
+
+func TestMissingKeyErrorNamesSource(t *testing.T) {
+	t.Setenv("TEST_EMPTY_KEY", "")
+	t.Setenv("OPENAI_API_KEY", "")
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"", "missing OpenAI API key from env OPENAI_API_KEY"},
+		{"env:TEST_EMPTY_KEY", "missing OpenAI API key from env TEST_EMPTY_KEY"},
+		{"TEST_EMPTY_KEY", "missing OpenAI API key from env TEST_EMPTY_KEY"},
+		{"env:TEST_UNSET_KEY", "missing OpenAI API key from an unset environment variable"},
+	}
+	for _, tt := range tests {
+		_, err := newOpenAIModel(Config{Provider: ProviderOpenAI, Model: "m", APIKeyEnv: tt.ref})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("api_key_env %q: error = %v, want %q", tt.ref, err, tt.want)
+		}
+	}
+}
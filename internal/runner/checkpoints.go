@@ -0,0 +1,231 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"helixrun/internal/checkpoint"
+
+	"trpc.group/trpc-go/trpc-agent-go/agent"
+	"trpc.group/trpc-go/trpc-agent-go/agent/graphagent"
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// WithCheckpointStore makes graph agents checkpoint every step into store,
+// so runs that stop at an interrupt_before node can be resumed.
+func (s *Service) WithCheckpointStore(store checkpoint.Store) {
+	s.checkpoints = store
+	s.registry.WithCheckpointSaver(store)
+}
+
+// startLineage returns the checkpoint lineage of a run of agt, or "" when
+// the run is not checkpointed. Resumed runs name their lineage in the
+// runtime state; other graph runs start a lineage named after the run, which
+// is added to opts.
+func (s *Service) startLineage(ctx context.Context, agt agent.Agent, info RunInfo, ro agent.RunOptions, opts []agent.RunOption) (string, []agent.RunOption, error) {
+	if _, ok := agt.(*graphagent.GraphAgent); !ok || s.checkpoints == nil {
+		return "", opts, nil
+	}
+	if id, _ := ro.RuntimeState[graph.CfgKeyLineageID].(string); id != "" {
+		return id, opts, nil
+	}
+
+	lineage := checkpoint.Lineage{
+		ID:        info.ID,
+		AgentID:   info.AgentID,
+		UserID:    info.UserID,
+		SessionID: info.SessionID,
+		CreatedAt: info.StartedAt,
+	}
+	if err := s.checkpoints.PutLineage(ctx, lineage); err != nil {
+		return "", nil, fmt.Errorf("start checkpoint lineage: %w", err)
+	}
+	state := make(map[string]any, len(ro.RuntimeState)+1)
+	for k, v := range ro.RuntimeState {
+		state[k] = v
+	}
+	state[graph.CfgKeyLineageID] = lineage.ID
+	return lineage.ID, append(opts, agent.WithRuntimeState(state)), nil
+}
+
+// reportInterrupts passes events through and follows the graph's interrupt
+// event with a checkpoint.ObjectTypeInterrupted event that names the
+// checkpoint to resume from.
+func (s *Service) reportInterrupts(ctx context.Context, runID, lineageID, namespace string, in <-chan *event.Event) <-chan *event.Event {
+	out := make(chan *event.Event, cap(in))
+	go func() {
+		defer close(out)
+		for ev := range in {
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				go drain(in)
+				return
+			}
+			if !isInterruptEvent(ev) {
+				continue
+			}
+			notice := s.interruptEvent(ctx, runID, lineageID, namespace, ev)
+			if notice == nil {
+				continue
+			}
+			select {
+			case out <- notice:
+			case <-ctx.Done():
+				go drain(in)
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func isInterruptEvent(ev *event.Event) bool {
+	if ev == nil || ev.Object != graph.ObjectTypeGraphPregelStep {
+		return false
+	}
+	b, ok := ev.StateDelta[graph.MetadataKeyPregel]
+	if !ok {
+		return false
+	}
+	var md graph.PregelStepMetadata
+	return json.Unmarshal(b, &md) == nil && md.InterruptValue != nil
+}
+
+// interruptEvent builds the notice for the interrupt checkpoint the
+// executor saved just before emitting ev.
+func (s *Service) interruptEvent(ctx context.Context, runID, lineageID, namespace string, ev *event.Event) *event.Event {
+	tuple, err := s.checkpoints.GetTuple(ctx, graph.CreateCheckpointConfig(lineageID, "", namespace))
+	if err != nil || tuple == nil || tuple.Checkpoint == nil || tuple.Checkpoint.InterruptState == nil {
+		log.Printf("run %s was interrupted but its checkpoint was not found: %v", runID, err)
+		return nil
+	}
+	is := tuple.Checkpoint.InterruptState
+	data, err := json.Marshal(checkpoint.Interrupt{
+		LineageID:    lineageID,
+		CheckpointID: tuple.Checkpoint.ID,
+		NodeID:       is.NodeID,
+		Value:        is.InterruptValue,
+	})
+	if err != nil {
+		log.Printf("marshal interrupt failed: %v", err)
+		return nil
+	}
+	notice := event.New(ev.InvocationID, s.runnerName,
+		event.WithObject(checkpoint.ObjectTypeInterrupted),
+		event.WithStateDelta(map[string][]byte{checkpoint.MetadataKey: data}))
+	notice.RequestID = runID
+	return notice
+}
+
+// Resume continues a graph run in a new run from a checkpoint of lineageID,
+// with the agent, user and session of the run that started the lineage. An
+// empty checkpointID means the latest checkpoint. When that checkpoint is
+// an interrupt, value is handed to the interrupted node (true when nil);
+// false stops the run at an interrupt_before node.
+// opts are passed to Run, e.g. agent.WithRequestID.
+//
+// Unknown lineages and checkpoints yield checkpoint.ErrNotFound.
+func (s *Service) Resume(ctx context.Context, lineageID, checkpointID string, value any, opts ...agent.RunOption) (<-chan *event.Event, error) {
+	lineage, tuple, err := s.lookupCheckpoint(ctx, lineageID, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	state := map[string]any{
+		graph.CfgKeyLineageID:    lineageID,
+		graph.CfgKeyCheckpointNS: graph.GetNamespace(tuple.Config),
+		graph.CfgKeyCheckpointID: tuple.Checkpoint.ID,
+	}
+	if tuple.Checkpoint.InterruptState != nil {
+		if value == nil {
+			value = true
+		}
+		state[graph.StateKeyCommand] = &graph.Command{Resume: value}
+	}
+	opts = append(opts, agent.WithRuntimeState(state))
+	// An empty message is neither stored in the session nor handed to the
+	// graph as input.
+	return s.Run(ctx, lineage.AgentID, lineage.UserID, lineage.SessionID, model.NewUserMessage(""), opts...)
+}
+
+// Checkpoints lists the checkpoints of a lineage, newest first.
+func (s *Service) Checkpoints(ctx context.Context, lineageID string) ([]*graph.CheckpointTuple, error) {
+	lineage, err := s.Lineage(ctx, lineageID)
+	if err != nil {
+		return nil, err
+	}
+	return s.checkpoints.List(ctx, graph.CreateCheckpointConfig(lineage.ID, "", lineage.AgentID), nil)
+}
+
+// Checkpoint returns a checkpoint of a lineage; an empty checkpointID
+// means the latest one.
+func (s *Service) Checkpoint(ctx context.Context, lineageID, checkpointID string) (*graph.CheckpointTuple, error) {
+	_, tuple, err := s.lookupCheckpoint(ctx, lineageID, checkpointID)
+	return tuple, err
+}
+
+// UpdateCheckpoint stores a copy of a checkpoint with values merged into
+// its state and returns it. Resuming from the copy runs the graph on the
+// edited state. Keys for which checkpoint.IsReservedKey holds are rejected
+// with checkpoint.ErrReservedKey.
+func (s *Service) UpdateCheckpoint(ctx context.Context, lineageID, checkpointID string, values map[string]any) (*graph.CheckpointTuple, error) {
+	for k := range values {
+		if checkpoint.IsReservedKey(k) {
+			return nil, fmt.Errorf("%w %q", checkpoint.ErrReservedKey, k)
+		}
+	}
+	_, tuple, err := s.lookupCheckpoint(ctx, lineageID, checkpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	edited := tuple.Checkpoint.Fork()
+	if edited.ChannelValues == nil {
+		edited.ChannelValues = make(map[string]any, len(values))
+	}
+	for k, v := range values {
+		edited.ChannelValues[k] = v
+	}
+	step := 0
+	if tuple.Metadata != nil {
+		step = tuple.Metadata.Step
+	}
+	cfg, err := s.checkpoints.Put(ctx, graph.PutRequest{
+		Config:      tuple.Config,
+		Checkpoint:  edited,
+		Metadata:    graph.NewCheckpointMetadata(graph.CheckpointSourceUpdate, step),
+		NewVersions: edited.ChannelVersions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("save checkpoint: %w", err)
+	}
+	return s.checkpoints.GetTuple(ctx, cfg)
+}
+
+func (s *Service) lookupCheckpoint(ctx context.Context, lineageID, checkpointID string) (checkpoint.Lineage, *graph.CheckpointTuple, error) {
+	lineage, err := s.Lineage(ctx, lineageID)
+	if err != nil {
+		return checkpoint.Lineage{}, nil, err
+	}
+	tuple, err := s.checkpoints.GetTuple(ctx, graph.CreateCheckpointConfig(lineage.ID, checkpointID, lineage.AgentID))
+	if err != nil {
+		return checkpoint.Lineage{}, nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+	if tuple == nil || tuple.Checkpoint == nil {
+		return checkpoint.Lineage{}, nil, checkpoint.ErrNotFound
+	}
+	return lineage, tuple, nil
+}
+
+// Lineage returns a checkpoint lineage or checkpoint.ErrNotFound.
+func (s *Service) Lineage(ctx context.Context, lineageID string) (checkpoint.Lineage, error) {
+	if s.checkpoints == nil {
+		return checkpoint.Lineage{}, checkpoint.ErrNotFound
+	}
+	return s.checkpoints.GetLineage(ctx, lineageID)
+}
@@ -0,0 +1,232 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"helixrun/internal/approval"
+
+	"trpc.group/trpc-go/trpc-agent-go/event"
+)
+
+// ObjectTypeRunCancelled is the object of the final event of a run stopped
+// by Cancel.
+const ObjectTypeRunCancelled = "run.cancelled"
+
+// ErrRunNotFound is returned by Cancel for unknown or finished runs.
+var ErrRunNotFound = errors.New("runner: run not found")
+
+// RunInfo describes an active run.
+type RunInfo struct {
+	ID           string    `json:"id"`
+	InvocationID string    `json:"invocation_id,omitempty"`
+	AgentID      string    `json:"agent_id"`
+	UserID       string    `json:"user_id"`
+	SessionID    string    `json:"session_id,omitempty"`
+	LineageID    string    `json:"lineage_id,omitempty"` // checkpoint lineage of graph runs
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// activeRun is a run registered with the service until its events are
+// consumed or it is cancelled.
+type activeRun struct {
+	cancel    context.CancelFunc
+	cancelled chan struct{} // closed by Cancel
+	once      sync.Once
+	gate      *approval.Gate
+	notices   chan *event.Event // approval events, merged into the stream
+
+	mu   sync.Mutex
+	info RunInfo
+}
+
+func newActiveRun(info RunInfo, cancel context.CancelFunc) *activeRun {
+	return &activeRun{cancel: cancel, cancelled: make(chan struct{}), notices: make(chan *event.Event), info: info}
+}
+
+func (r *activeRun) snapshot() RunInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.info
+}
+
+// runTable tracks the active runs of a service by run ID.
+type runTable struct {
+	mu   sync.Mutex
+	runs map[string]*activeRun
+}
+
+func (t *runTable) add(run *activeRun) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.runs == nil {
+		t.runs = make(map[string]*activeRun)
+	}
+	t.runs[run.info.ID] = run
+}
+
+func (t *runTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.runs, id)
+}
+
+// find looks a run up by run ID or by the invocation ID of its events.
+func (t *runTable) find(id string) *activeRun {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if run, ok := t.runs[id]; ok {
+		return run
+	}
+	for _, run := range t.runs {
+		if run.snapshot().InvocationID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func (t *runTable) list() []RunInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]RunInfo, 0, len(t.runs))
+	for _, run := range t.runs {
+		out = append(out, run.snapshot())
+	}
+	return out
+}
+
+// Cancel stops an active run, identified by its run ID (the request ID of
+// its events) or its invocation ID. The run's stream ends with a
+// run.cancelled event.
+func (s *Service) Cancel(id string) error {
+	run := s.runs.find(id)
+	if run == nil {
+		return ErrRunNotFound
+	}
+	run.once.Do(func() {
+		close(run.cancelled)
+		run.cancel()
+	})
+	return nil
+}
+
+// ActiveRuns lists the runs that are still streaming.
+func (s *Service) ActiveRuns() []RunInfo {
+	return s.runs.list()
+}
+
+// ResolveApproval decides a tool call of an active run that waits for
+// approval. It returns ErrRunNotFound, approval.ErrNotPending or an
+// approval.ErrInvalidDecision error.
+func (s *Service) ResolveApproval(runID, toolCallID string, d approval.Decision) error {
+	run := s.runs.find(runID)
+	if run == nil {
+		return ErrRunNotFound
+	}
+	return run.gate.Resolve(toolCallID, d)
+}
+
+// PendingApprovals lists the tool calls of an active run that wait for
+// approval.
+func (s *Service) PendingApprovals(runID string) ([]approval.Request, error) {
+	run := s.runs.find(runID)
+	if run == nil {
+		return nil, ErrRunNotFound
+	}
+	return run.gate.Pending(), nil
+}
+
+// notifyApproval streams an approval request or its resolution as an
+// event of the run.
+func (s *Service) notifyApproval(ctx context.Context, run *activeRun, req approval.Request) {
+	object := approval.ObjectTypeRequired
+	if req.Decision != "" {
+		object = approval.ObjectTypeResolved
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		log.Printf("marshal approval request failed: %v", err)
+		return
+	}
+	info := run.snapshot()
+	ev := event.New(info.InvocationID, s.runnerName,
+		event.WithObject(object),
+		event.WithStateDelta(map[string][]byte{approval.MetadataKey: data}))
+	ev.RequestID = info.ID
+	select {
+	case run.notices <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// trackRun passes events through until the run ends, the consumer goes
+// away (ctx) or the run is cancelled. Approval events of the run are merged
+// in. Events after a cancellation are drained and replaced by one
+// run.cancelled event.
+func (s *Service) trackRun(ctx context.Context, run *activeRun, in <-chan *event.Event) <-chan *event.Event {
+	out := make(chan *event.Event, cap(in))
+	go func() {
+		defer close(out)
+		defer s.runs.remove(run.info.ID)
+		defer run.cancel()
+
+		for {
+			var ev *event.Event
+			select {
+			case next, ok := <-in:
+				if !ok {
+					return
+				}
+				if next != nil && next.InvocationID != "" {
+					run.mu.Lock()
+					if run.info.InvocationID == "" {
+						run.info.InvocationID = next.InvocationID
+					}
+					run.mu.Unlock()
+				}
+				ev = next
+			case ev = <-run.notices:
+			case <-run.cancelled:
+				go drain(in)
+				s.sendCancelled(ctx, run, out)
+				return
+			case <-ctx.Done():
+				go drain(in)
+				return
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				go drain(in)
+				return
+			case <-run.cancelled:
+				go drain(in)
+				s.sendCancelled(ctx, run, out)
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (s *Service) sendCancelled(ctx context.Context, run *activeRun, out chan<- *event.Event) {
+	info := run.snapshot()
+	ev := event.New(info.InvocationID, s.runnerName, event.WithObject(ObjectTypeRunCancelled))
+	ev.RequestID = info.ID
+	ev.Done = true
+	select {
+	case out <- ev:
+	case <-ctx.Done():
+	}
+}
+
+func drain(ch <-chan *event.Event) {
+	for range ch {
+	}
+}
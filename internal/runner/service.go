@@ -4,9 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
+
+	"github.com/google/uuid"
 
 	"helixrun/internal/agents"
+	"helixrun/internal/approval"
+	"helixrun/internal/checkpoint"
+	hmodel "helixrun/internal/model"
+	"helixrun/internal/usage"
 
+	"trpc.group/trpc-go/trpc-agent-go/agent"
 	"trpc.group/trpc-go/trpc-agent-go/event"
 	"trpc.group/trpc-go/trpc-agent-go/model"
 	trpcrunner "trpc.group/trpc-go/trpc-agent-go/runner"
@@ -25,6 +33,10 @@ type Service struct {
 	registry       *agents.Registry
 	sessionService session.Service
 	runnerName     string
+	usage          *usage.Recorder
+	budgets        *usage.BudgetChecker
+	checkpoints    checkpoint.Store
+	runs           runTable
 }
 
 // NewService creates a Runner service with the default in-memory session store.
@@ -52,14 +64,33 @@ func (s *Service) WithRunnerName(name string) {
 	s.runnerName = name
 }
 
+// WithUsageRecorder records the token usage of every model response.
+func (s *Service) WithUsageRecorder(rec *usage.Recorder) {
+	s.usage = rec
+}
+
+// WithBudgetChecker rejects runs of users or agents that are over budget.
+func (s *Service) WithBudgetChecker(c *usage.BudgetChecker) {
+	s.budgets = c
+}
+
 // Run executes the requested agent with the provided message and streams events.
-func (s *Service) Run(ctx context.Context, agentID, userID, sessionID string, message model.Message) (<-chan *event.Event, error) {
+// Runs over budget are rejected with a *usage.BudgetExceededError. opts are
+// passed to the runner, e.g. agent.WithMessages to seed a new session.
+//
+// The run is tracked until its stream ends and can be stopped with Cancel.
+// Its ID is the request ID of its events; pass agent.WithRequestID to choose
+// it up front.
+func (s *Service) Run(ctx context.Context, agentID, userID, sessionID string, message model.Message, opts ...agent.RunOption) (<-chan *event.Event, error) {
 	if s == nil {
 		return nil, fmt.Errorf("runner service is not initialized")
 	}
 	if s.registry == nil {
 		return nil, fmt.Errorf("runner service registry is not configured")
 	}
+	if err := s.budgets.Check(ctx, agentID, userID); err != nil {
+		return nil, err
+	}
 	agt, err := s.registry.BuildAgent(ctx, agentID)
 	if err != nil {
 		return nil, errors.Join(ErrBuildAgent, fmt.Errorf("build agent %q: %w", agentID, err))
@@ -71,5 +102,87 @@ func (s *Service) Run(ctx context.Context, agentID, userID, sessionID string, me
 		trpcrunner.WithSessionService(s.sessionService),
 	)
 
-	return appRunner.Run(ctx, userID, sessionID, message)
+	var ro agent.RunOptions
+	for _, opt := range opts {
+		opt(&ro)
+	}
+	if ro.RequestID == "" {
+		ro.RequestID = uuid.NewString()
+		opts = append(opts, agent.WithRequestID(ro.RequestID))
+	}
+
+	info := RunInfo{
+		ID:        ro.RequestID,
+		AgentID:   agentID,
+		UserID:    userID,
+		SessionID: sessionID,
+		StartedAt: time.Now(),
+	}
+	info.LineageID, opts, err = s.startLineage(ctx, agt, info, ro, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	consumerCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	run := newActiveRun(info, cancel)
+	runCtx := ctx
+	run.gate = approval.NewGate(func(req approval.Request) { s.notifyApproval(runCtx, run, req) })
+	ctx = approval.ContextWithGate(ctx, run.gate)
+	s.runs.add(run)
+
+	var attrs *hmodel.AttributionRecorder
+	tags := usage.Tags{AgentID: agentID, UserID: userID, SessionID: sessionID}
+	if s.usage != nil {
+		attrs = hmodel.NewAttributionRecorder()
+		ctx = hmodel.ContextWithAttribution(ctx, attrs)
+		ctx = hmodel.ContextWithUsage(ctx, s.sideCallUsage(tags, attrs))
+	}
+	events, err := appRunner.Run(ctx, userID, sessionID, message, opts...)
+	if err != nil {
+		s.runs.remove(ro.RequestID)
+		cancel()
+		return nil, err
+	}
+	if s.usage != nil {
+		events = s.recordUsage(ctx, tags, attrs, events)
+	}
+	if info.LineageID != "" {
+		events = s.reportInterrupts(ctx, info.ID, info.LineageID, agentID, events)
+	}
+	return s.trackRun(consumerCtx, run, events), nil
+}
+
+// sideCallUsage records the usage of model calls that produce no events,
+// e.g. graph classifications, as if they were events of the calling agent.
+func (s *Service) sideCallUsage(tags usage.Tags, attrs *hmodel.AttributionRecorder) hmodel.UsageFunc {
+	return func(ctx context.Context, resp *model.Response) {
+		var invocationID, author string
+		if inv, ok := agent.InvocationFromContext(ctx); ok && inv != nil {
+			invocationID, author = inv.InvocationID, inv.AgentName
+		}
+		s.usage.Record(ctx, tags, attrs, event.NewResponseEvent(invocationID, author, resp))
+	}
+}
+
+// recordUsage passes events through and records the usage of model
+// responses. Once the consumer is gone the remaining events are still
+// drained and recorded.
+func (s *Service) recordUsage(ctx context.Context, tags usage.Tags, attrs *hmodel.AttributionRecorder, in <-chan *event.Event) <-chan *event.Event {
+	out := make(chan *event.Event, cap(in))
+	go func() {
+		defer close(out)
+		for ev := range in {
+			s.usage.Record(ctx, tags, attrs, ev)
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				for ev := range in {
+					s.usage.Record(ctx, tags, attrs, ev)
+				}
+				return
+			}
+		}
+	}()
+	return out
 }
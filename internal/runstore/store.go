@@ -0,0 +1,218 @@
+// Package runstore persists detached runs and their events so clients can
+// read them back after disconnecting. MemoryStore keeps runs in process
+// memory; pgstore.RunStore keeps them in PostgreSQL.
+package runstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Run statuses.
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// ErrNotFound is returned for unknown run IDs.
+var ErrNotFound = errors.New("runstore: run not found")
+
+// Run is a detached run.
+type Run struct {
+	ID         string     `json:"id"`
+	AgentID    string     `json:"agent_id"`
+	UserID     string     `json:"user_id"`
+	SessionID  string     `json:"session_id"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	EventCount int64      `json:"event_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the run has stopped producing events.
+func (r Run) Finished() bool {
+	return r.Status != StatusRunning
+}
+
+// Event is one stored event of a run. Seq starts at 1 and has no gaps.
+type Event struct {
+	Seq  int64           `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Store persists runs and their events. Events of one run are appended by
+// a single writer in Seq order.
+type Store interface {
+	CreateRun(ctx context.Context, run Run) error
+	GetRun(ctx context.Context, id string) (Run, error)
+	AppendEvent(ctx context.Context, runID string, ev Event) error
+	FinishRun(ctx context.Context, id, status, errMsg string) error
+	// Events returns up to limit events with Seq > after.
+	Events(ctx context.Context, runID string, after int64, limit int) ([]Event, error)
+}
+
+// defaultRetention is how long MemoryStore keeps finished runs.
+const defaultRetention = 24 * time.Hour
+
+// MemoryStore is a Store that lives in process memory. Finished runs are
+// dropped after the retention period.
+type MemoryStore struct {
+	retention time.Duration
+
+	mu   sync.RWMutex
+	runs map[string]*memoryRun
+}
+
+type memoryRun struct {
+	run    Run
+	events []Event
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{retention: defaultRetention, runs: make(map[string]*memoryRun)}
+}
+
+// WithRetention sets how long finished runs are kept.
+func (s *MemoryStore) WithRetention(d time.Duration) *MemoryStore {
+	s.retention = d
+	return s
+}
+
+// CreateRun stores a new run and prunes expired ones.
+func (s *MemoryStore) CreateRun(_ context.Context, run Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	s.runs[run.ID] = &memoryRun{run: run}
+	return nil
+}
+
+// GetRun returns a run or ErrNotFound.
+func (s *MemoryStore) GetRun(_ context.Context, id string) (Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mr, ok := s.runs[id]
+	if !ok {
+		return Run{}, ErrNotFound
+	}
+	return mr.run, nil
+}
+
+// AppendEvent stores an event of a run.
+func (s *MemoryStore) AppendEvent(_ context.Context, runID string, ev Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mr, ok := s.runs[runID]
+	if !ok {
+		return ErrNotFound
+	}
+	mr.events = append(mr.events, ev)
+	mr.run.EventCount = ev.Seq
+	return nil
+}
+
+// FinishRun sets the final status of a run.
+func (s *MemoryStore) FinishRun(_ context.Context, id, status, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mr, ok := s.runs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	mr.run.Status, mr.run.Error, mr.run.FinishedAt = status, errMsg, &now
+	return nil
+}
+
+// Events returns up to limit events with Seq > after; limit <= 0 means all.
+func (s *MemoryStore) Events(_ context.Context, runID string, after int64, limit int) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mr, ok := s.runs[runID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if after < 0 {
+		after = 0
+	}
+	if after >= int64(len(mr.events)) {
+		return nil, nil
+	}
+	events := mr.events[after:] // Seq == index+1
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]Event(nil), events...), nil
+}
+
+// prune drops finished runs past the retention period. Must be called with
+// s.mu held.
+func (s *MemoryStore) prune(now time.Time) {
+	for id, mr := range s.runs {
+		if f := mr.run.FinishedAt; f != nil && now.Sub(*f) > s.retention {
+			delete(s.runs, id)
+		}
+	}
+}
+
+// Notifier wakes readers waiting for new events of a run written by this
+// process. Readers also poll, so runs written by other replicas are still
+// picked up.
+type Notifier struct {
+	mu      sync.Mutex
+	waiters map[string]*waiter
+}
+
+// waiter is the channel shared by the readers of one run.
+type waiter struct {
+	ch   chan struct{}
+	refs int
+}
+
+// NewNotifier creates a Notifier.
+func NewNotifier() *Notifier {
+	return &Notifier{waiters: make(map[string]*waiter)}
+}
+
+// Wait returns a channel that is closed on the next Notify for runID, and
+// a release func the reader must call once it stops waiting.
+func (n *Notifier) Wait(runID string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	w, ok := n.waiters[runID]
+	if !ok {
+		w = &waiter{ch: make(chan struct{})}
+		n.waiters[runID] = w
+	}
+	w.refs++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			w.refs--
+			if w.refs == 0 && n.waiters[runID] == w {
+				delete(n.waiters, runID)
+			}
+		})
+	}
+	return w.ch, release
+}
+
+// Notify wakes all current waiters of runID.
+func (n *Notifier) Notify(runID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if w, ok := n.waiters[runID]; ok {
+		close(w.ch)
+		delete(n.waiters, runID)
+	}
+}
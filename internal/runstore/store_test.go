@@ -0,0 +1,166 @@
+package runstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// appendEvents stores n events with Seq 1..n.
+func appendEvents(t *testing.T, s Store, runID string, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		ev := Event{Seq: int64(i), Data: json.RawMessage(fmt.Sprintf(`{"n":%d}`, i))}
+		if err := s.AppendEvent(context.Background(), runID, ev); err != nil {
+			t.Fatalf("AppendEvent %d: %v", i, err)
+		}
+	}
+}
+
+func seqs(events []Event) []int64 {
+	out := make([]int64, len(events))
+	for i, ev := range events {
+		out[i] = ev.Seq
+	}
+	return out
+}
+
+func TestMemoryStoreEvents(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	if err := s.CreateRun(ctx, Run{ID: "r1", Status: StatusRunning}); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	appendEvents(t, s, "r1", 5)
+
+	tests := []struct {
+		after int64
+		limit int
+		want  []int64
+	}{
+		{0, 0, []int64{1, 2, 3, 4, 5}},
+		{-1, 0, []int64{1, 2, 3, 4, 5}},
+		{2, 0, []int64{3, 4, 5}},
+		{2, 2, []int64{3, 4}},
+		{5, 0, nil},
+		{9, 0, nil},
+	}
+	for _, tt := range tests {
+		events, err := s.Events(ctx, "r1", tt.after, tt.limit)
+		if err != nil {
+			t.Fatalf("Events(%d, %d): %v", tt.after, tt.limit, err)
+		}
+		if got := seqs(events); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Events(after %d, limit %d) = %v, want %v", tt.after, tt.limit, got, tt.want)
+		}
+	}
+
+	run, err := s.GetRun(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.EventCount != 5 || run.Finished() {
+		t.Errorf("run = %+v, want 5 events and running", run)
+	}
+}
+
+func TestMemoryStoreEventsAreCopied(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	s.CreateRun(ctx, Run{ID: "r1", Status: StatusRunning})
+	appendEvents(t, s, "r1", 2)
+
+	events, _ := s.Events(ctx, "r1", 0, 0)
+	events[0] = Event{Seq: 99}
+	again, _ := s.Events(ctx, "r1", 0, 0)
+	if again[0].Seq != 1 {
+		t.Errorf("changing returned events changed the store: %v", seqs(again))
+	}
+}
+
+func TestMemoryStoreFinishRun(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	s.CreateRun(ctx, Run{ID: "r1", Status: StatusRunning})
+
+	if err := s.FinishRun(ctx, "r1", StatusFailed, "boom"); err != nil {
+		t.Fatalf("FinishRun: %v", err)
+	}
+	run, _ := s.GetRun(ctx, "r1")
+	if !run.Finished() || run.Status != StatusFailed || run.Error != "boom" || run.FinishedAt == nil {
+		t.Errorf("run = %+v, want failed with error boom", run)
+	}
+}
+
+func TestMemoryStoreNotFound(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	checks := map[string]error{
+		"GetRun":      func() error { _, err := s.GetRun(ctx, "nope"); return err }(),
+		"AppendEvent": s.AppendEvent(ctx, "nope", Event{Seq: 1}),
+		"FinishRun":   s.FinishRun(ctx, "nope", StatusCompleted, ""),
+		"Events":      func() error { _, err := s.Events(ctx, "nope", 0, 0); return err }(),
+	}
+	for name, err := range checks {
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s error = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestMemoryStoreRetention(t *testing.T) {
+	s := NewMemoryStore().WithRetention(time.Millisecond)
+	ctx := context.Background()
+	s.CreateRun(ctx, Run{ID: "done", Status: StatusRunning})
+	s.CreateRun(ctx, Run{ID: "running", Status: StatusRunning})
+	s.FinishRun(ctx, "done", StatusCompleted, "")
+	time.Sleep(5 * time.Millisecond)
+
+	// Expired runs are pruned when the next run is created.
+	s.CreateRun(ctx, Run{ID: "new", Status: StatusRunning})
+	if _, err := s.GetRun(ctx, "done"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("finished run past retention: error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetRun(ctx, "running"); err != nil {
+		t.Errorf("running run was pruned: %v", err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	n := NewNotifier()
+	wake1, release1 := n.Wait("r1")
+	wake2, release2 := n.Wait("r1")
+	other, releaseOther := n.Wait("r2")
+	defer releaseOther()
+
+	n.Notify("r1")
+	for i, wake := range []<-chan struct{}{wake1, wake2} {
+		select {
+		case <-wake:
+		case <-time.After(time.Second):
+			t.Fatalf("waiter %d was not woken", i+1)
+		}
+	}
+	select {
+	case <-other:
+		t.Fatal("waiter of another run was woken")
+	default:
+	}
+	release1()
+	release2()
+	release2() // release is idempotent
+
+	// A new Wait after Notify gets a fresh channel.
+	wake3, release3 := n.Wait("r1")
+	defer release3()
+	select {
+	case <-wake3:
+		t.Fatal("new waiter is woken by an earlier Notify")
+	default:
+	}
+	n.Notify("r1")
+	<-wake3
+}
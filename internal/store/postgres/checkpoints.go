@@ -0,0 +1,367 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"helixrun/internal/checkpoint"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+var _ checkpoint.Store = (*CheckpointStore)(nil)
+
+// CheckpointStore is a PostgreSQL implementation of checkpoint.Store,
+// backed by the tables created by configs/migrations/0004_checkpoints.sql.
+// An empty namespace in a lookup matches checkpoints of any namespace.
+type CheckpointStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewCheckpointStore creates a CheckpointStore.
+func NewCheckpointStore(pool *pgxpool.Pool) *CheckpointStore {
+	return &CheckpointStore{pool: pool}
+}
+
+// PutLineage inserts or replaces a lineage.
+func (s *CheckpointStore) PutLineage(ctx context.Context, l checkpoint.Lineage) error {
+	if _, err := s.pool.Exec(ctx, `
+		INSERT INTO graph_lineages (id, agent_id, user_id, session_id, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (id) DO UPDATE SET
+			agent_id = EXCLUDED.agent_id,
+			user_id = EXCLUDED.user_id,
+			session_id = EXCLUDED.session_id`,
+		l.ID, l.AgentID, l.UserID, l.SessionID, l.CreatedAt,
+	); err != nil {
+		return fmt.Errorf("postgres: put lineage: %w", err)
+	}
+	return nil
+}
+
+// GetLineage returns a lineage or checkpoint.ErrNotFound.
+func (s *CheckpointStore) GetLineage(ctx context.Context, id string) (checkpoint.Lineage, error) {
+	var l checkpoint.Lineage
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, agent_id, user_id, session_id, created_at
+		FROM graph_lineages WHERE id = $1`, id,
+	).Scan(&l.ID, &l.AgentID, &l.UserID, &l.SessionID, &l.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return checkpoint.Lineage{}, checkpoint.ErrNotFound
+	}
+	if err != nil {
+		return checkpoint.Lineage{}, fmt.Errorf("postgres: get lineage: %w", err)
+	}
+	return l, nil
+}
+
+// Get returns the checkpoint selected by config, or nil.
+func (s *CheckpointStore) Get(ctx context.Context, config map[string]any) (*graph.Checkpoint, error) {
+	t, err := s.GetTuple(ctx, config)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return t.Checkpoint, nil
+}
+
+// GetTuple returns the checkpoint selected by config with its pending
+// writes, or nil. Without a checkpoint ID the latest one is returned.
+func (s *CheckpointStore) GetTuple(ctx context.Context, config map[string]any) (*graph.CheckpointTuple, error) {
+	lineageID := graph.GetLineageID(config)
+	ns := graph.GetNamespace(config)
+	id := graph.GetCheckpointID(config)
+	if lineageID == "" {
+		return nil, errors.New("postgres: lineage_id is required")
+	}
+
+	var (
+		parentID           string
+		ckptJSON, metaJSON []byte
+	)
+	err := s.pool.QueryRow(ctx, `
+		SELECT checkpoint_ns, checkpoint_id, parent_checkpoint_id, checkpoint, metadata
+		FROM graph_checkpoints
+		WHERE lineage_id = $1 AND ($2 = '' OR checkpoint_ns = $2) AND ($3 = '' OR checkpoint_id = $3)
+		ORDER BY ts DESC
+		LIMIT 1`, lineageID, ns, id,
+	).Scan(&ns, &id, &parentID, &ckptJSON, &metaJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("postgres: get checkpoint: %w", err)
+	}
+
+	tuple := &graph.CheckpointTuple{Config: graph.CreateCheckpointConfig(lineageID, id, ns)}
+	if err := json.Unmarshal(ckptJSON, &tuple.Checkpoint); err != nil {
+		return nil, fmt.Errorf("postgres: decode checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(metaJSON, &tuple.Metadata); err != nil {
+		return nil, fmt.Errorf("postgres: decode checkpoint metadata: %w", err)
+	}
+	if tuple.PendingWrites, err = s.writes(ctx, lineageID, ns, id); err != nil {
+		return nil, err
+	}
+	if parentID != "" {
+		var parentNS string
+		err := s.pool.QueryRow(ctx, `
+			SELECT checkpoint_ns FROM graph_checkpoints
+			WHERE lineage_id = $1 AND checkpoint_id = $2
+			LIMIT 1`, lineageID, parentID,
+		).Scan(&parentNS)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("postgres: get parent checkpoint: %w", err)
+		}
+		tuple.ParentConfig = graph.CreateCheckpointConfig(lineageID, parentID, parentNS)
+	}
+	return tuple, nil
+}
+
+func (s *CheckpointStore) writes(ctx context.Context, lineageID, ns, id string) ([]graph.PendingWrite, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT task_id, channel, value, seq FROM graph_checkpoint_writes
+		WHERE lineage_id = $1 AND checkpoint_ns = $2 AND checkpoint_id = $3
+		ORDER BY seq`, lineageID, ns, id)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list checkpoint writes: %w", err)
+	}
+	defer rows.Close()
+
+	var writes []graph.PendingWrite
+	for rows.Next() {
+		var (
+			w     graph.PendingWrite
+			value []byte
+		)
+		if err := rows.Scan(&w.TaskID, &w.Channel, &value, &w.Sequence); err != nil {
+			return nil, fmt.Errorf("postgres: scan checkpoint write: %w", err)
+		}
+		if err := json.Unmarshal(value, &w.Value); err != nil {
+			return nil, fmt.Errorf("postgres: decode checkpoint write: %w", err)
+		}
+		writes = append(writes, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list checkpoint writes: %w", err)
+	}
+	return writes, nil
+}
+
+// List returns the checkpoints of a lineage, newest first.
+func (s *CheckpointStore) List(ctx context.Context, config map[string]any, filter *graph.CheckpointFilter) ([]*graph.CheckpointTuple, error) {
+	lineageID := graph.GetLineageID(config)
+	ns := graph.GetNamespace(config)
+	if lineageID == "" {
+		return nil, errors.New("postgres: lineage_id is required")
+	}
+
+	var beforeID string
+	if filter != nil && filter.Before != nil {
+		beforeID = graph.GetCheckpointID(filter.Before)
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT checkpoint_ns, checkpoint_id FROM graph_checkpoints c
+		WHERE lineage_id = $1 AND ($2 = '' OR checkpoint_ns = $2)
+		  AND ($3 = '' OR ts < (
+			SELECT MAX(ts) FROM graph_checkpoints b
+			WHERE b.lineage_id = $1 AND b.checkpoint_id = $3 AND ($2 = '' OR b.checkpoint_ns = $2)))
+		ORDER BY ts DESC`, lineageID, ns, beforeID)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list checkpoints: %w", err)
+	}
+	var keys [][2]string
+	for rows.Next() {
+		var key [2]string
+		if err := rows.Scan(&key[0], &key[1]); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("postgres: scan checkpoint: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list checkpoints: %w", err)
+	}
+
+	var tuples []*graph.CheckpointTuple
+	for _, key := range keys {
+		tuple, err := s.GetTuple(ctx, graph.CreateCheckpointConfig(lineageID, key[1], key[0]))
+		if err != nil {
+			return nil, err
+		}
+		if tuple == nil || !matchesMetadata(tuple, filter) {
+			continue
+		}
+		tuples = append(tuples, tuple)
+		if filter != nil && filter.Limit > 0 && len(tuples) >= filter.Limit {
+			break
+		}
+	}
+	return tuples, nil
+}
+
+func matchesMetadata(tuple *graph.CheckpointTuple, filter *graph.CheckpointFilter) bool {
+	if filter == nil || len(filter.Metadata) == 0 {
+		return true
+	}
+	if tuple.Metadata == nil {
+		return false
+	}
+	for k, v := range filter.Metadata {
+		if tuple.Metadata.Extra[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Put stores a checkpoint.
+func (s *CheckpointStore) Put(ctx context.Context, req graph.PutRequest) (map[string]any, error) {
+	return s.PutFull(ctx, graph.PutFullRequest{
+		Config:      req.Config,
+		Checkpoint:  req.Checkpoint,
+		Metadata:    req.Metadata,
+		NewVersions: req.NewVersions,
+	})
+}
+
+// PutFull stores a checkpoint together with its pending writes.
+func (s *CheckpointStore) PutFull(ctx context.Context, req graph.PutFullRequest) (map[string]any, error) {
+	lineageID := graph.GetLineageID(req.Config)
+	ns := graph.GetNamespace(req.Config)
+	if lineageID == "" {
+		return nil, errors.New("postgres: lineage_id is required")
+	}
+	if req.Checkpoint == nil {
+		return nil, errors.New("postgres: checkpoint is required")
+	}
+	if req.Metadata == nil {
+		req.Metadata = graph.NewCheckpointMetadata(graph.CheckpointSourceUpdate, 0)
+	}
+	ckptJSON, err := json.Marshal(req.Checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: encode checkpoint: %w", err)
+	}
+	metaJSON, err := json.Marshal(req.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: encode checkpoint metadata: %w", err)
+	}
+	ts := req.Checkpoint.Timestamp.UnixNano()
+	if req.Checkpoint.Timestamp.IsZero() {
+		ts = time.Now().UnixNano()
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: begin put checkpoint: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO graph_checkpoints
+			(lineage_id, checkpoint_ns, checkpoint_id, parent_checkpoint_id, ts, checkpoint, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (lineage_id, checkpoint_ns, checkpoint_id) DO UPDATE SET
+			parent_checkpoint_id = EXCLUDED.parent_checkpoint_id,
+			ts = EXCLUDED.ts,
+			checkpoint = EXCLUDED.checkpoint,
+			metadata = EXCLUDED.metadata`,
+		lineageID, ns, req.Checkpoint.ID, req.Checkpoint.ParentCheckpointID, ts, ckptJSON, metaJSON,
+	); err != nil {
+		return nil, fmt.Errorf("postgres: insert checkpoint: %w", err)
+	}
+	for idx, w := range req.PendingWrites {
+		if err := insertWrite(ctx, tx, lineageID, ns, req.Checkpoint.ID, w.TaskID, "", idx, w); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("postgres: commit checkpoint: %w", err)
+	}
+	return graph.CreateCheckpointConfig(lineageID, req.Checkpoint.ID, ns), nil
+}
+
+// PutWrites stores the writes of a task against a checkpoint.
+func (s *CheckpointStore) PutWrites(ctx context.Context, req graph.PutWritesRequest) error {
+	lineageID := graph.GetLineageID(req.Config)
+	ns := graph.GetNamespace(req.Config)
+	id := graph.GetCheckpointID(req.Config)
+	if lineageID == "" || id == "" {
+		return errors.New("postgres: lineage_id and checkpoint_id are required")
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: begin put checkpoint writes: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	for idx, w := range req.Writes {
+		if err := insertWrite(ctx, tx, lineageID, ns, id, req.TaskID, req.TaskPath, idx, w); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("postgres: commit checkpoint writes: %w", err)
+	}
+	return nil
+}
+
+func insertWrite(ctx context.Context, tx pgx.Tx, lineageID, ns, id, taskID, taskPath string, idx int, w graph.PendingWrite) error {
+	value, err := json.Marshal(w.Value)
+	if err != nil {
+		return fmt.Errorf("postgres: encode checkpoint write: %w", err)
+	}
+	seq := w.Sequence
+	if seq == 0 {
+		seq = int64(idx)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO graph_checkpoint_writes
+			(lineage_id, checkpoint_ns, checkpoint_id, task_id, idx, channel, value, task_path, seq)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (lineage_id, checkpoint_ns, checkpoint_id, task_id, idx) DO UPDATE SET
+			channel = EXCLUDED.channel,
+			value = EXCLUDED.value,
+			task_path = EXCLUDED.task_path,
+			seq = EXCLUDED.seq`,
+		lineageID, ns, id, taskID, idx, w.Channel, value, taskPath, seq,
+	); err != nil {
+		return fmt.Errorf("postgres: insert checkpoint write: %w", err)
+	}
+	return nil
+}
+
+// DeleteLineage removes a lineage with all its checkpoints.
+func (s *CheckpointStore) DeleteLineage(ctx context.Context, lineageID string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: begin delete lineage: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	for _, q := range []string{
+		`DELETE FROM graph_checkpoint_writes WHERE lineage_id = $1`,
+		`DELETE FROM graph_checkpoints WHERE lineage_id = $1`,
+		`DELETE FROM graph_lineages WHERE id = $1`,
+	} {
+		if _, err := tx.Exec(ctx, q, lineageID); err != nil {
+			return fmt.Errorf("postgres: delete lineage: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("postgres: commit delete lineage: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op; the pool is owned by the caller.
+func (s *CheckpointStore) Close() error {
+	return nil
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"helixrun/internal/checkpoint"
+
+	"trpc.group/trpc-go/trpc-agent-go/graph"
+)
+
+func TestCheckpointStoreLineages(t *testing.T) {
+	s := NewCheckpointStore(testPool(t, true))
+	ctx := context.Background()
+
+	if _, err := s.GetLineage(ctx, "l1"); !errors.Is(err, checkpoint.ErrNotFound) {
+		t.Fatalf("GetLineage of an unknown lineage error = %v, want ErrNotFound", err)
+	}
+	l := checkpoint.Lineage{ID: "l1", AgentID: "g", UserID: "u", SessionID: "s", CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+	if err := s.PutLineage(ctx, l); err != nil {
+		t.Fatalf("PutLineage: %v", err)
+	}
+	got, err := s.GetLineage(ctx, "l1")
+	if err != nil {
+		t.Fatalf("GetLineage: %v", err)
+	}
+	if got.ID != l.ID || got.AgentID != l.AgentID || got.UserID != l.UserID || got.SessionID != l.SessionID || !got.CreatedAt.Equal(l.CreatedAt) {
+		t.Errorf("GetLineage = %+v, want %+v", got, l)
+	}
+
+	if err := s.DeleteLineage(ctx, "l1"); err != nil {
+		t.Fatalf("DeleteLineage: %v", err)
+	}
+	if _, err := s.GetLineage(ctx, "l1"); !errors.Is(err, checkpoint.ErrNotFound) {
+		t.Errorf("GetLineage after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCheckpointStoreCheckpoints(t *testing.T) {
+	s := NewCheckpointStore(testPool(t, true))
+	ctx := context.Background()
+
+	// Two steps of lineage l1 and an interrupt, each a child of the last.
+	var (
+		ids    []string
+		parent string
+	)
+	for step, values := range []map[string]any{{"n": 1.0}, {"n": 2.0}, {"n": 3.0}} {
+		ck := graph.NewCheckpoint(values, map[string]int64{"n": int64(step)}, nil)
+		ck.ParentCheckpointID = parent
+		ck.Timestamp = time.Now().Add(time.Duration(step) * time.Millisecond)
+		ck.NextNodes = []string{"answer"}
+		source := graph.CheckpointSourceLoop
+		if step == 2 {
+			source = graph.CheckpointSourceInterrupt
+			ck.InterruptState = &graph.InterruptState{NodeID: "answer", InterruptValue: "before"}
+		}
+		cfg, err := s.Put(ctx, graph.PutRequest{
+			Config:     graph.CreateCheckpointConfig("l1", "", "g"),
+			Checkpoint: ck,
+			Metadata:   graph.NewCheckpointMetadata(source, step),
+		})
+		if err != nil {
+			t.Fatalf("Put step %d: %v", step, err)
+		}
+		if graph.GetCheckpointID(cfg) != ck.ID || graph.GetNamespace(cfg) != "g" {
+			t.Errorf("Put step %d returned config %v", step, cfg)
+		}
+		ids = append(ids, ck.ID)
+		parent = ck.ID
+	}
+	writes := graph.PutWritesRequest{
+		Config: graph.CreateCheckpointConfig("l1", ids[2], "g"),
+		TaskID: "task",
+		Writes: []graph.PendingWrite{{TaskID: "task", Channel: "a", Value: "x"}, {TaskID: "task", Channel: "b", Value: 2.0}},
+	}
+	if err := s.PutWrites(ctx, writes); err != nil {
+		t.Fatalf("PutWrites: %v", err)
+	}
+
+	// Latest, in any namespace.
+	latest, err := s.GetTuple(ctx, graph.CreateCheckpointConfig("l1", "", ""))
+	if err != nil || latest == nil {
+		t.Fatalf("GetTuple latest = %v, %v", latest, err)
+	}
+	if latest.Checkpoint.ID != ids[2] || latest.Checkpoint.ChannelValues["n"] != 3.0 {
+		t.Errorf("latest checkpoint = %+v, want step 2", latest.Checkpoint)
+	}
+	if is := latest.Checkpoint.InterruptState; is == nil || is.NodeID != "answer" || is.InterruptValue != "before" {
+		t.Errorf("latest interrupt = %+v, want one before answer", is)
+	}
+	if latest.Metadata == nil || latest.Metadata.Source != graph.CheckpointSourceInterrupt || latest.Metadata.Step != 2 {
+		t.Errorf("latest metadata = %+v", latest.Metadata)
+	}
+	if graph.GetCheckpointID(latest.ParentConfig) != ids[1] || graph.GetNamespace(latest.ParentConfig) != "g" {
+		t.Errorf("latest parent config = %v, want step 1", latest.ParentConfig)
+	}
+	if w := latest.PendingWrites; len(w) != 2 || w[0].Channel != "a" || w[0].Value != "x" || w[1].Channel != "b" || w[1].Value != 2.0 {
+		t.Errorf("pending writes = %+v", w)
+	}
+
+	// By ID.
+	first, err := s.GetTuple(ctx, graph.CreateCheckpointConfig("l1", ids[0], "g"))
+	if err != nil || first == nil || first.Checkpoint.ID != ids[0] || first.ParentConfig != nil {
+		t.Errorf("GetTuple step 0 = %+v, %v", first, err)
+	}
+	for _, cfg := range []map[string]any{
+		graph.CreateCheckpointConfig("l1", "nope", ""),
+		graph.CreateCheckpointConfig("l1", ids[0], "other"),
+		graph.CreateCheckpointConfig("l2", "", ""),
+	} {
+		if tuple, err := s.GetTuple(ctx, cfg); err != nil || tuple != nil {
+			t.Errorf("GetTuple(%v) = %v, %v, want nil", cfg, tuple, err)
+		}
+	}
+
+	// List is newest first and honours Before and Limit.
+	list, err := s.List(ctx, graph.CreateCheckpointConfig("l1", "", "g"), nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 3 || list[0].Checkpoint.ID != ids[2] || list[2].Checkpoint.ID != ids[0] {
+		t.Errorf("List = %d checkpoints, want 3 newest first", len(list))
+	}
+	filter := graph.NewCheckpointFilter().WithBefore(graph.CreateCheckpointConfig("l1", ids[2], "g")).WithLimit(1)
+	if list, err := s.List(ctx, graph.CreateCheckpointConfig("l1", "", "g"), filter); err != nil || len(list) != 1 || list[0].Checkpoint.ID != ids[1] {
+		t.Errorf("List before step 2, limit 1 = %v, %v, want step 1", list, err)
+	}
+
+	if err := s.DeleteLineage(ctx, "l1"); err != nil {
+		t.Fatalf("DeleteLineage: %v", err)
+	}
+	if tuple, err := s.GetTuple(ctx, graph.CreateCheckpointConfig("l1", "", "")); err != nil || tuple != nil {
+		t.Errorf("GetTuple after delete = %v, %v", tuple, err)
+	}
+}
+
+func TestCheckpointStoreRequiresLineage(t *testing.T) {
+	s := NewCheckpointStore(testPool(t, true))
+	ctx := context.Background()
+
+	if _, err := s.GetTuple(ctx, graph.CreateCheckpointConfig("", "", "")); err == nil {
+		t.Error("GetTuple without lineage succeeded")
+	}
+	if _, err := s.Put(ctx, graph.PutRequest{Config: graph.CreateCheckpointConfig("", "", ""), Checkpoint: graph.NewCheckpoint(nil, nil, nil)}); err == nil {
+		t.Error("Put without lineage succeeded")
+	}
+	if _, err := s.Put(ctx, graph.PutRequest{Config: graph.CreateCheckpointConfig("l1", "", "")}); err == nil {
+		t.Error("Put without checkpoint succeeded")
+	}
+	if err := s.PutWrites(ctx, graph.PutWritesRequest{Config: graph.CreateCheckpointConfig("l1", "", "")}); err == nil {
+		t.Error("PutWrites without checkpoint ID succeeded")
+	}
+}
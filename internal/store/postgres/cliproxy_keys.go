@@ -0,0 +1,344 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrKeyNotFound is returned when a CLIProxy API key does not exist.
+var ErrKeyNotFound = errors.New("postgres: cliproxy key not found")
+
+// ErrKeyExists is returned when a CLIProxy API key with the same ID exists.
+var ErrKeyExists = errors.New("postgres: cliproxy key already exists")
+
+// uniqueViolation is the SQLSTATE of a unique constraint violation.
+const uniqueViolation = "23505"
+
+// APIKey is a provider key stored in cliproxy_api_keys. Secret is never
+// serialized; clients only see SecretPreview.
+type APIKey struct {
+	ID             string         `json:"id"`
+	Secret         string         `json:"-"`
+	Provider       string         `json:"provider"`
+	Label          string         `json:"label,omitempty"`
+	SecretPreview  string         `json:"secret_preview"`
+	Status         string         `json:"status"`
+	LimitPerMinute *int           `json:"limit_per_minute,omitempty"`
+	LimitPerDay    *int           `json:"limit_per_day,omitempty"`
+	Metadata       map[string]any `json:"metadata"`
+	Attributes     map[string]any `json:"attributes"`
+	Source         string         `json:"source"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+}
+
+// KeyUpdate holds the fields of a partial key update; nil fields are left
+// unchanged. The Clear flags remove a limit instead.
+type KeyUpdate struct {
+	Label               *string
+	Secret              *string
+	Status              *string
+	LimitPerMinute      *int
+	LimitPerDay         *int
+	ClearLimitPerMinute bool
+	ClearLimitPerDay    bool
+	Metadata            map[string]any
+	Attributes          map[string]any
+}
+
+// KeyRepository manages rows in cliproxy_api_keys.
+type KeyRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewKeyRepository creates a KeyRepository.
+func NewKeyRepository(pool *pgxpool.Pool) *KeyRepository {
+	return &KeyRepository{pool: pool}
+}
+
+const keyColumns = `id, secret, provider, COALESCE(label, ''), secret_preview, status,
+	limit_per_minute, limit_per_day, metadata, attributes, source, created_at, updated_at`
+
+// Create inserts a new key. ID is generated when empty, SecretPreview and
+// the status/source defaults are filled in.
+func (r *KeyRepository) Create(ctx context.Context, key APIKey) (*APIKey, error) {
+	if key.Provider == "" {
+		return nil, errors.New("postgres: cliproxy key provider is required")
+	}
+	if key.Secret == "" {
+		return nil, errors.New("postgres: cliproxy key secret is required")
+	}
+	if key.ID == "" {
+		key.ID = uuid.New().String()
+	}
+	if key.Status == "" {
+		key.Status = KeyStatusActive
+	}
+	if key.Source == "" {
+		key.Source = "local"
+	}
+	key.SecretPreview = SecretPreview(key.Secret)
+
+	metadata, err := marshalObject(key.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := marshalObject(key.Attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	row := r.pool.QueryRow(ctx, `
+		INSERT INTO cliproxy_api_keys
+			(id, secret, provider, label, secret_preview, status,
+			 limit_per_minute, limit_per_day, metadata, attributes, source)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11)
+		RETURNING `+keyColumns,
+		key.ID, key.Secret, key.Provider, key.Label, key.SecretPreview, key.Status,
+		key.LimitPerMinute, key.LimitPerDay, metadata, attributes, key.Source,
+	)
+	created, err := scanKey(row)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return nil, ErrKeyExists
+	}
+	if err != nil {
+		return nil, fmt.Errorf("postgres: insert cliproxy key: %w", err)
+	}
+	return created, nil
+}
+
+// List returns all keys, optionally filtered by provider.
+func (r *KeyRepository) List(ctx context.Context, provider string) ([]APIKey, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT `+keyColumns+`
+		FROM cliproxy_api_keys
+		WHERE $1 = '' OR provider = $1
+		ORDER BY created_at`,
+		provider,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list cliproxy keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: scan cliproxy key: %w", err)
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list cliproxy keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Get returns a single key by ID.
+func (r *KeyRepository) Get(ctx context.Context, id string) (*APIKey, error) {
+	row := r.pool.QueryRow(ctx, `
+		SELECT `+keyColumns+`
+		FROM cliproxy_api_keys
+		WHERE id = $1`,
+		id,
+	)
+	key, err := scanKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("postgres: get cliproxy key: %w", err)
+	}
+	return key, nil
+}
+
+// Key statuses used by the key pool.
+const (
+	KeyStatusActive    = "active"    // may be used
+	KeyStatusExhausted = "exhausted" // rate limited until attributes.exhausted_until
+	KeyStatusInvalid   = "invalid"   // rejected by the provider (401/403)
+)
+
+// ValidKeyStatus reports whether status is one of the key statuses.
+func ValidKeyStatus(status string) bool {
+	switch status {
+	case KeyStatusActive, KeyStatusExhausted, KeyStatusInvalid:
+		return true
+	}
+	return false
+}
+
+// ResolveSecret returns the secret of an active key. It is registered as
+// the cliproxy: secret resolver for model configs; errors never contain the
+// secret.
+func (r *KeyRepository) ResolveSecret(ctx context.Context, id string) (string, error) {
+	key, err := r.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if key.Status != KeyStatusActive {
+		return "", fmt.Errorf("postgres: cliproxy key %s is %s", id, key.Status)
+	}
+	return key.Secret, nil
+}
+
+// Update applies a partial update and returns the updated key.
+func (r *KeyRepository) Update(ctx context.Context, id string, upd KeyUpdate) (*APIKey, error) {
+	var preview *string
+	if upd.Secret != nil {
+		if *upd.Secret == "" {
+			return nil, errors.New("postgres: cliproxy key secret must not be empty")
+		}
+		p := SecretPreview(*upd.Secret)
+		preview = &p
+	}
+
+	var metadata, attributes []byte
+	if upd.Metadata != nil {
+		b, err := marshalObject(upd.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = b
+	}
+	if upd.Attributes != nil {
+		b, err := marshalObject(upd.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		attributes = b
+	}
+
+	row := r.pool.QueryRow(ctx, `
+		UPDATE cliproxy_api_keys SET
+			label = COALESCE($2, label),
+			secret = COALESCE($3, secret),
+			secret_preview = COALESCE($4, secret_preview),
+			status = COALESCE($5, status),
+			limit_per_minute = CASE WHEN $10::boolean THEN NULL ELSE COALESCE($6, limit_per_minute) END,
+			limit_per_day = CASE WHEN $11::boolean THEN NULL ELSE COALESCE($7, limit_per_day) END,
+			metadata = COALESCE($8::jsonb, metadata),
+			attributes = COALESCE($9::jsonb, attributes),
+			updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+keyColumns,
+		id, upd.Label, upd.Secret, preview, upd.Status,
+		upd.LimitPerMinute, upd.LimitPerDay, metadata, attributes,
+		upd.ClearLimitPerMinute, upd.ClearLimitPerDay,
+	)
+	key, err := scanKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("postgres: update cliproxy key: %w", err)
+	}
+	return key, nil
+}
+
+// SetStatus changes the status of a key. until is stored as
+// attributes.exhausted_until; nil removes it.
+func (r *KeyRepository) SetStatus(ctx context.Context, id, status string, until *time.Time) error {
+	tag, err := r.pool.Exec(ctx, `
+		UPDATE cliproxy_api_keys SET
+			status = $2,
+			attributes = CASE
+				WHEN $3::timestamptz IS NULL THEN attributes - 'exhausted_until'
+				ELSE attributes || jsonb_build_object('exhausted_until', $3::timestamptz)
+			END,
+			updated_at = NOW()
+		WHERE id = $1`,
+		id, status, until,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: set cliproxy key status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// Delete removes a key. Usage events keep their rows with api_key_id set to NULL.
+func (r *KeyRepository) Delete(ctx context.Context, id string) error {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM cliproxy_api_keys WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("postgres: delete cliproxy key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// SecretPreview returns a redacted representation of a secret that is safe
+// to show in UIs and logs.
+func SecretPreview(secret string) string {
+	if len(secret) <= 8 {
+		return "****"
+	}
+	return secret[:4] + "..." + secret[len(secret)-4:]
+}
+
+func scanKey(row pgx.Row) (*APIKey, error) {
+	var (
+		key        APIKey
+		minute     *int32
+		day        *int32
+		metadata   []byte
+		attributes []byte
+	)
+	if err := row.Scan(
+		&key.ID, &key.Secret, &key.Provider, &key.Label, &key.SecretPreview, &key.Status,
+		&minute, &day, &metadata, &attributes, &key.Source, &key.CreatedAt, &key.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if minute != nil {
+		v := int(*minute)
+		key.LimitPerMinute = &v
+	}
+	if day != nil {
+		v := int(*day)
+		key.LimitPerDay = &v
+	}
+	if err := unmarshalObject(metadata, &key.Metadata); err != nil {
+		return nil, err
+	}
+	if err := unmarshalObject(attributes, &key.Attributes); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func marshalObject(m map[string]any) ([]byte, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: marshal json object: %w", err)
+	}
+	return data, nil
+}
+
+func unmarshalObject(data []byte, out *map[string]any) error {
+	*out = map[string]any{}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("postgres: unmarshal json object: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestKeyRepositoryCreateDuplicate(t *testing.T) {
+	repo := NewKeyRepository(testPool(t, true))
+	ctx := context.Background()
+
+	key := APIKey{ID: "k1", Provider: "openai", Secret: "secret-one"}
+	if _, err := repo.Create(ctx, key); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := repo.Create(ctx, key); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("second Create error = %v, want ErrKeyExists", err)
+	}
+}
+
+func TestKeyRepositoryUpdateLimits(t *testing.T) {
+	repo := NewKeyRepository(testPool(t, true))
+	ctx := context.Background()
+	n := func(v int) *int { return &v }
+
+	if _, err := repo.Create(ctx, APIKey{
+		ID: "k1", Provider: "openai", Secret: "secret-one",
+		LimitPerMinute: n(10), LimitPerDay: n(100),
+	}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	tests := []struct {
+		name                string
+		upd                 KeyUpdate
+		wantMinute, wantDay *int
+	}{
+		{"unchanged", KeyUpdate{}, n(10), n(100)},
+		{"set minute", KeyUpdate{LimitPerMinute: n(5)}, n(5), n(100)},
+		{"clear day", KeyUpdate{ClearLimitPerDay: true}, n(5), nil},
+		{"clear minute, set day", KeyUpdate{ClearLimitPerMinute: true, LimitPerDay: n(7)}, nil, n(7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := repo.Update(ctx, "k1", tt.upd)
+			if err != nil {
+				t.Fatalf("Update: %v", err)
+			}
+			if !equalLimit(key.LimitPerMinute, tt.wantMinute) || !equalLimit(key.LimitPerDay, tt.wantDay) {
+				t.Errorf("limits = %v/%v, want %v/%v",
+					fmtLimit(key.LimitPerMinute), fmtLimit(key.LimitPerDay), fmtLimit(tt.wantMinute), fmtLimit(tt.wantDay))
+			}
+		})
+	}
+
+	if _, err := repo.Update(ctx, "missing", KeyUpdate{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Update of a missing key error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func equalLimit(a, b *int) bool {
+	return (a == nil) == (b == nil) && (a == nil || *a == *b)
+}
+
+func fmtLimit(v *int) any {
+	if v == nil {
+		return "none"
+	}
+	return *v
+}
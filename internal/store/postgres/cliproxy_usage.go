@@ -0,0 +1,326 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// UsageEvent is a row of cliproxy_usage_events.
+type UsageEvent struct {
+	ID              int64          `json:"id"`
+	EventID         string         `json:"event_id,omitempty"`
+	APIKeyID        string         `json:"api_key_id,omitempty"`
+	Provider        string         `json:"provider,omitempty"`
+	Model           string         `json:"model,omitempty"`
+	Source          string         `json:"source,omitempty"`
+	Failed          bool           `json:"failed"`
+	TotalTokens     int            `json:"total_tokens"`
+	InputTokens     int            `json:"input_tokens"`
+	OutputTokens    int            `json:"output_tokens"`
+	ReasoningTokens int            `json:"reasoning_tokens"`
+	CachedTokens    int            `json:"cached_tokens"`
+	CostUSD         float64        `json:"cost_usd"`
+	Metadata        map[string]any `json:"metadata"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
+
+// UsageFilter narrows a usage query. Zero values are ignored.
+type UsageFilter struct {
+	Provider string
+	KeyID    string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+const (
+	defaultUsageLimit = 100
+	maxUsageLimit     = 1000
+)
+
+// UsageRepository reads and writes cliproxy_usage_events.
+type UsageRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewUsageRepository creates a UsageRepository.
+func NewUsageRepository(pool *pgxpool.Pool) *UsageRepository {
+	return &UsageRepository{pool: pool}
+}
+
+// List returns usage events matching the filter, newest first.
+func (r *UsageRepository) List(ctx context.Context, f UsageFilter) ([]UsageEvent, error) {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Provider != "" {
+		add("provider = $%d", f.Provider)
+	}
+	if f.KeyID != "" {
+		add("api_key_id = $%d", f.KeyID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultUsageLimit
+	}
+	if limit > maxUsageLimit {
+		limit = maxUsageLimit
+	}
+	args = append(args, limit)
+
+	query := `
+		SELECT id, COALESCE(event_id, ''), COALESCE(api_key_id, ''), COALESCE(provider, ''),
+			COALESCE(model, ''), COALESCE(source, ''), COALESCE(failed, FALSE),
+			COALESCE(total_tokens, 0), COALESCE(input_tokens, 0), COALESCE(output_tokens, 0),
+			COALESCE(reasoning_tokens, 0), COALESCE(cached_tokens, 0), COALESCE(cost_usd, 0),
+			metadata, created_at
+		FROM cliproxy_usage_events`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\tORDER BY created_at DESC, id DESC\n\t\tLIMIT $%d", len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list usage events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []UsageEvent{}
+	for rows.Next() {
+		var (
+			ev       UsageEvent
+			metadata []byte
+		)
+		if err := rows.Scan(
+			&ev.ID, &ev.EventID, &ev.APIKeyID, &ev.Provider,
+			&ev.Model, &ev.Source, &ev.Failed,
+			&ev.TotalTokens, &ev.InputTokens, &ev.OutputTokens,
+			&ev.ReasoningTokens, &ev.CachedTokens, &ev.CostUSD,
+			&metadata, &ev.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("postgres: scan usage event: %w", err)
+		}
+		if err := unmarshalObject(metadata, &ev.Metadata); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list usage events: %w", err)
+	}
+	return events, nil
+}
+
+// Insert stores a usage event. Events with an event_id that was already
+// recorded are ignored, so retried inserts are safe.
+func (r *UsageRepository) Insert(ctx context.Context, ev UsageEvent) error {
+	metadata, err := marshalObject(ev.Metadata)
+	if err != nil {
+		return err
+	}
+	if ev.CreatedAt.IsZero() {
+		ev.CreatedAt = time.Now()
+	}
+	_, err = r.pool.Exec(ctx, `
+		INSERT INTO cliproxy_usage_events
+			(event_id, api_key_id, provider, model, source, failed,
+			 total_tokens, input_tokens, output_tokens, reasoning_tokens, cached_tokens,
+			 cost_usd, metadata, created_at)
+		VALUES (NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6,
+			$7, $8, $9, $10, $11, $12, $13, $14)
+		ON CONFLICT (event_id) DO NOTHING`,
+		ev.EventID, ev.APIKeyID, ev.Provider, ev.Model, ev.Source, ev.Failed,
+		ev.TotalTokens, ev.InputTokens, ev.OutputTokens, ev.ReasoningTokens, ev.CachedTokens,
+		ev.CostUSD, metadata, ev.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: insert usage event: %w", err)
+	}
+	return nil
+}
+
+// CountByKey returns the number of usage events per API key recorded at or
+// after since. Keys without events are absent from the result.
+func (r *UsageRepository) CountByKey(ctx context.Context, keyIDs []string, since time.Time) (map[string]int, error) {
+	rows, err := r.pool.Query(ctx, `
+		SELECT api_key_id, COUNT(*)
+		FROM cliproxy_usage_events
+		WHERE api_key_id = ANY($1) AND created_at >= $2
+		GROUP BY api_key_id`,
+		keyIDs, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: count usage events: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(keyIDs))
+	for rows.Next() {
+		var (
+			id string
+			n  int
+		)
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, fmt.Errorf("postgres: scan usage count: %w", err)
+		}
+		counts[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: count usage events: %w", err)
+	}
+	return counts, nil
+}
+
+// Usage summary dimensions accepted in UsageSummaryFilter.GroupBy.
+const (
+	UsageGroupDay      = "day"
+	UsageGroupProvider = "provider"
+	UsageGroupModel    = "model"
+	UsageGroupAgent    = "agent"
+	UsageGroupUser     = "user"
+)
+
+// usageGroupColumns maps group names to SQL expressions.
+var usageGroupColumns = map[string]string{
+	UsageGroupDay:      "to_char(date_trunc('day', created_at AT TIME ZONE 'UTC'), 'YYYY-MM-DD')",
+	UsageGroupProvider: "COALESCE(provider, '')",
+	UsageGroupModel:    "COALESCE(model, '')",
+	UsageGroupAgent:    "COALESCE(metadata->>'agent_id', '')",
+	UsageGroupUser:     "COALESCE(metadata->>'user_id', '')",
+}
+
+// UsageSummaryFilter selects and groups usage events. Zero values are
+// ignored; without GroupBy a single total row is returned.
+type UsageSummaryFilter struct {
+	From     time.Time
+	To       time.Time
+	Provider string
+	Model    string
+	AgentID  string
+	UserID   string
+	GroupBy  []string
+}
+
+// UsageSummary is one aggregated row. Only the grouped dimensions are set.
+type UsageSummary struct {
+	Day          string  `json:"day,omitempty"`
+	Provider     string  `json:"provider,omitempty"`
+	Model        string  `json:"model,omitempty"`
+	AgentID      string  `json:"agent_id,omitempty"`
+	UserID       string  `json:"user_id,omitempty"`
+	Requests     int64   `json:"requests"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CachedTokens int64   `json:"cached_tokens"`
+	TotalTokens  int64   `json:"total_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+}
+
+// Summary aggregates usage events.
+func (r *UsageRepository) Summary(ctx context.Context, f UsageSummaryFilter) ([]UsageSummary, error) {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.Provider != "" {
+		add("provider = $%d", f.Provider)
+	}
+	if f.Model != "" {
+		add("model = $%d", f.Model)
+	}
+	if f.AgentID != "" {
+		add("metadata->>'agent_id' = $%d", f.AgentID)
+	}
+	if f.UserID != "" {
+		add("metadata->>'user_id' = $%d", f.UserID)
+	}
+
+	groups := make([]string, 0, len(f.GroupBy))
+	for _, g := range f.GroupBy {
+		col, ok := usageGroupColumns[g]
+		if !ok {
+			return nil, fmt.Errorf("postgres: unknown usage group %q", g)
+		}
+		groups = append(groups, col)
+	}
+
+	selects := append([]string{}, groups...)
+	selects = append(selects,
+		"COUNT(*)",
+		"COALESCE(SUM(input_tokens), 0)",
+		"COALESCE(SUM(output_tokens), 0)",
+		"COALESCE(SUM(cached_tokens), 0)",
+		"COALESCE(SUM(total_tokens), 0)",
+		"COALESCE(SUM(cost_usd), 0)",
+	)
+	query := "SELECT " + strings.Join(selects, ", ") + "\n\t\tFROM cliproxy_usage_events"
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	if len(groups) > 0 {
+		query += "\n\t\tGROUP BY " + strings.Join(groups, ", ") +
+			"\n\t\tORDER BY " + strings.Join(groups, ", ")
+	}
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: summarize usage: %w", err)
+	}
+	defer rows.Close()
+
+	out := []UsageSummary{}
+	for rows.Next() {
+		var s UsageSummary
+		dests := make([]any, 0, len(f.GroupBy)+6)
+		for _, g := range f.GroupBy {
+			switch g {
+			case UsageGroupDay:
+				dests = append(dests, &s.Day)
+			case UsageGroupProvider:
+				dests = append(dests, &s.Provider)
+			case UsageGroupModel:
+				dests = append(dests, &s.Model)
+			case UsageGroupAgent:
+				dests = append(dests, &s.AgentID)
+			case UsageGroupUser:
+				dests = append(dests, &s.UserID)
+			}
+		}
+		dests = append(dests, &s.Requests, &s.InputTokens, &s.OutputTokens, &s.CachedTokens, &s.TotalTokens, &s.CostUSD)
+		if err := rows.Scan(dests...); err != nil {
+			return nil, fmt.Errorf("postgres: scan usage summary: %w", err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: summarize usage: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,322 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"helixrun/configs/migrations"
+)
+
+// migrationLockKey is the pg_advisory_lock key held while migrating, so
+// replicas starting at the same time apply each migration once.
+const migrationLockKey int64 = 0x68656c6978 // "helix"
+
+// Migration is one numbered schema change. Down is empty when the
+// migration has no .down.sql file and cannot be reverted.
+type Migration struct {
+	Version  int64
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // sha256 of Up
+}
+
+// MigrationStatus describes a migration known from the files, the
+// database or both.
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	Modified  bool       `json:"modified,omitempty"` // file changed after it was applied
+	Missing   bool       `json:"missing,omitempty"`  // applied but no longer in the files
+}
+
+// Migrator applies the migrations in configs/migrations and records them in
+// schema_migrations.
+type Migrator struct {
+	pool *pgxpool.Pool
+	fsys fs.FS
+}
+
+// NewMigrator creates a Migrator for the embedded migrations.
+func NewMigrator(pool *pgxpool.Pool) *Migrator {
+	return &Migrator{pool: pool, fsys: migrations.FS}
+}
+
+// WithFS replaces the migration files, e.g. with os.DirFS for a directory
+// on disk.
+func (m *Migrator) WithFS(fsys fs.FS) *Migrator {
+	m.fsys = fsys
+	return m
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies all pending migrations in version order, each in its own
+// transaction, and returns the ones it applied. It refuses to run when an
+// applied migration was modified since.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	files, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range files {
+			if a, ok := applied[mig.Version]; ok && a.checksum != mig.Checksum {
+				return fmt.Errorf("postgres: migration %04d_%s was modified after it was applied", mig.Version, mig.Name)
+			}
+		}
+		for _, mig := range files {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := applyMigration(ctx, conn, mig); err != nil {
+				return err
+			}
+			log.Printf("postgres: applied migration %04d_%s", mig.Version, mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("postgres: down steps must be >= 1")
+	}
+	files, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]Migration, len(files))
+	for _, mig := range files {
+		byVersion[mig.Version] = mig
+	}
+
+	var done []Migration
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := readApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, v := range versions {
+			if len(done) == steps {
+				break
+			}
+			mig, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("postgres: migration %04d_%s is applied but its file is missing", v, applied[v].name)
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("postgres: migration %04d_%s has no .down.sql file", mig.Version, mig.Name)
+			}
+			if err := revertMigration(ctx, conn, mig); err != nil {
+				return err
+			}
+			log.Printf("postgres: reverted migration %04d_%s", mig.Version, mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists all migrations in version order.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	files, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var applied map[int64]appliedMigration
+	err = m.locked(ctx, func(conn *pgxpool.Conn) error {
+		applied, err = readApplied(ctx, conn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var out []MigrationStatus
+	for _, mig := range files {
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			at := a.appliedAt
+			st.AppliedAt = &at
+			st.Modified = a.checksum != mig.Checksum
+			delete(applied, mig.Version)
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		at := a.appliedAt
+		out = append(out, MigrationStatus{Version: v, Name: a.name, AppliedAt: &at, Missing: true})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// locked runs fn on one connection while holding the migration lock, after
+// making sure schema_migrations exists.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: acquire migration connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("postgres: take migration lock: %w", err)
+	}
+	defer func() {
+		// The lock is session-scoped, so unlock even when ctx is done.
+		if _, err := conn.Exec(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Printf("postgres: release migration lock failed: %v", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`); err != nil {
+		return fmt.Errorf("postgres: create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func readApplied(ctx context.Context, conn *pgxpool.Conn) (map[int64]appliedMigration, error) {
+	rows, err := conn.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var (
+			v int64
+			a appliedMigration
+		)
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, fmt.Errorf("postgres: scan schema_migrations: %w", err)
+		}
+		applied[v] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: read schema_migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func applyMigration(ctx context.Context, conn *pgxpool.Conn, mig Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mig.Up); err != nil {
+			return fmt.Errorf("postgres: apply migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO schema_migrations (version, name, checksum)
+			VALUES ($1, $2, $3)`,
+			mig.Version, mig.Name, mig.Checksum,
+		); err != nil {
+			return fmt.Errorf("postgres: record migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return nil
+	})
+}
+
+func revertMigration(ctx context.Context, conn *pgxpool.Conn, mig Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, mig.Down); err != nil {
+			return fmt.Errorf("postgres: revert migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+			return fmt.Errorf("postgres: unrecord migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return nil
+	})
+}
+
+// load reads the migration files, sorted by version. Files are named
+// <version>_<name>.sql and <version>_<name>.down.sql.
+func (m *Migrator) load() ([]Migration, error) {
+	names, err := fs.Glob(m.fsys, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, file := range names {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		down := strings.HasSuffix(base, ".down")
+		base = strings.TrimSuffix(base, ".down")
+
+		rawVersion, name, ok := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(rawVersion, 10, 64)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("postgres: migration file %s: want <version>_<name>.sql", file)
+		}
+		b, err := fs.ReadFile(m.fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("postgres: read migration %s: %w", file, err)
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		} else if mig.Name != name {
+			return nil, fmt.Errorf("postgres: migration version %04d is used by %s and %s", version, mig.Name, name)
+		}
+		if down {
+			mig.Down = string(b)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		mig.Up, mig.Checksum = string(b), hex.EncodeToString(sum[:])
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("postgres: migration %04d_%s has a .down.sql file but no .sql file", mig.Version, mig.Name)
+		}
+		out = append(out, *mig)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
@@ -0,0 +1,216 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"0001_widgets.sql":      {Data: []byte("CREATE TABLE widgets (id INT PRIMARY KEY);")},
+		"0001_widgets.down.sql": {Data: []byte("DROP TABLE widgets;")},
+		"0002_gadgets.sql":      {Data: []byte("CREATE TABLE gadgets (id INT PRIMARY KEY);")},
+		"0002_gadgets.down.sql": {Data: []byte("DROP TABLE gadgets;")},
+		"0003_sprockets.sql":    {Data: []byte("CREATE TABLE sprockets (id INT PRIMARY KEY);")},
+	}
+}
+
+func TestMigratorLoad(t *testing.T) {
+	migs, err := (&Migrator{fsys: testMigrations()}).load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migs) != 3 {
+		t.Fatalf("loaded %d migrations, want 3", len(migs))
+	}
+	for i, want := range []string{"widgets", "gadgets", "sprockets"} {
+		if migs[i].Version != int64(i+1) || migs[i].Name != want {
+			t.Errorf("migration %d = %04d_%s, want %04d_%s", i, migs[i].Version, migs[i].Name, i+1, want)
+		}
+	}
+
+	sum := sha256.Sum256([]byte("CREATE TABLE widgets (id INT PRIMARY KEY);"))
+	if got, want := migs[0].Checksum, hex.EncodeToString(sum[:]); got != want {
+		t.Errorf("checksum = %s, want the sha256 of the up file %s", got, want)
+	}
+	if migs[0].Down != "DROP TABLE widgets;" {
+		t.Errorf("down = %q", migs[0].Down)
+	}
+	if migs[2].Down != "" {
+		t.Errorf("migration without .down.sql has down %q", migs[2].Down)
+	}
+}
+
+func TestMigratorLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{
+			name: "no version",
+			fsys: fstest.MapFS{"widgets.sql": {Data: []byte("SELECT 1;")}},
+			want: "want <version>_<name>.sql",
+		},
+		{
+			name: "version zero",
+			fsys: fstest.MapFS{"0000_widgets.sql": {Data: []byte("SELECT 1;")}},
+			want: "want <version>_<name>.sql",
+		},
+		{
+			name: "duplicate version",
+			fsys: fstest.MapFS{
+				"0001_widgets.sql": {Data: []byte("SELECT 1;")},
+				"0001_gadgets.sql": {Data: []byte("SELECT 1;")},
+			},
+			want: "version 0001 is used by",
+		},
+		{
+			name: "down without up",
+			fsys: fstest.MapFS{"0001_widgets.down.sql": {Data: []byte("SELECT 1;")}},
+			want: "has a .down.sql file but no .sql file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Migrator{fsys: tt.fsys}).load()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("load error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigratorEmbeddedFiles(t *testing.T) {
+	migs, err := NewMigrator(nil).load()
+	if err != nil {
+		t.Fatalf("load embedded migrations: %v", err)
+	}
+	if len(migs) == 0 {
+		t.Fatal("no embedded migrations")
+	}
+	for i, mig := range migs {
+		if mig.Version != int64(i+1) {
+			t.Errorf("migration %04d_%s: versions must be consecutive from 1, want %04d", mig.Version, mig.Name, i+1)
+		}
+	}
+}
+
+func TestMigratorUpDownStatus(t *testing.T) {
+	pool := testPool(t, false)
+	ctx := context.Background()
+	fsys := testMigrations()
+	m := NewMigrator(pool).WithFS(fsys)
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(done) != 3 {
+		t.Fatalf("Up applied %d migrations, want 3", len(done))
+	}
+	if done, err := m.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second Up = %d migrations, %v; want none", len(done), err)
+	}
+	assertStatus(t, m, map[int64]bool{1: true, 2: true, 3: true})
+
+	// 0003 has no down file, so reverting it fails and keeps it applied.
+	if _, err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "has no .down.sql file") {
+		t.Fatalf("Down without down file error = %v", err)
+	}
+	fsys["0003_sprockets.down.sql"] = &fstest.MapFile{Data: []byte("DROP TABLE sprockets;")}
+
+	done, err = m.Down(ctx, 2)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(done) != 2 || done[0].Version != 3 || done[1].Version != 2 {
+		t.Fatalf("Down reverted %v, want 3 then 2", done)
+	}
+	var exists bool
+	if err := pool.QueryRow(ctx, `SELECT to_regclass('gadgets') IS NOT NULL`).Scan(&exists); err != nil {
+		t.Fatalf("check table: %v", err)
+	}
+	if exists {
+		t.Error("table gadgets still exists after Down")
+	}
+	assertStatus(t, m, map[int64]bool{1: true, 2: false, 3: false})
+
+	if _, err := m.Down(ctx, 0); err == nil {
+		t.Error("Down(0) succeeded")
+	}
+}
+
+func TestMigratorChecksum(t *testing.T) {
+	pool := testPool(t, false)
+	ctx := context.Background()
+	fsys := testMigrations()
+	m := NewMigrator(pool).WithFS(fsys)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	fsys["0002_gadgets.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE gadgets (id BIGINT PRIMARY KEY);")}
+	fsys["0004_cogs.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE cogs (id INT PRIMARY KEY);")}
+
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "0002_gadgets was modified") {
+		t.Fatalf("Up after modification error = %v", err)
+	}
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(st) != 4 || !st[1].Modified || st[0].Modified {
+		t.Errorf("status = %+v, want only 0002 modified", st)
+	}
+	if st[3].AppliedAt != nil {
+		t.Error("Up applied 0004 although an applied migration was modified")
+	}
+}
+
+func TestMigratorStatusMissingFile(t *testing.T) {
+	pool := testPool(t, false)
+	ctx := context.Background()
+	fsys := testMigrations()
+	m := NewMigrator(pool).WithFS(fsys)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	delete(fsys, "0003_sprockets.sql")
+
+	st, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(st) != 3 || !st[2].Missing || st[2].Name != "sprockets" || st[2].AppliedAt == nil {
+		t.Errorf("status = %+v, want 0003_sprockets applied and missing", st)
+	}
+	if _, err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "its file is missing") {
+		t.Errorf("Down of a missing file error = %v", err)
+	}
+}
+
+// assertStatus checks which migrations Status reports as applied.
+func assertStatus(t *testing.T, m *Migrator, applied map[int64]bool) {
+	t.Helper()
+	st, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(st) != len(applied) {
+		t.Fatalf("Status lists %d migrations, want %d", len(st), len(applied))
+	}
+	for _, s := range st {
+		if got := s.AppliedAt != nil; got != applied[s.Version] {
+			t.Errorf("migration %04d applied = %v, want %v", s.Version, got, applied[s.Version])
+		}
+		if s.Modified || s.Missing {
+			t.Errorf("migration %04d is modified or missing: %+v", s.Version, s)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"helixrun/internal/runstore"
+)
+
+var _ runstore.Store = (*RunStore)(nil)
+
+const defaultRunEventsLimit = 1000
+
+// RunStore is a PostgreSQL implementation of runstore.Store, backed by the
+// tables created by configs/migrations/0003_runs.sql.
+type RunStore struct {
+	pool     *pgxpool.Pool
+	runnerID string
+}
+
+// NewRunStore creates a RunStore.
+func NewRunStore(pool *pgxpool.Pool) *RunStore {
+	return &RunStore{pool: pool}
+}
+
+// WithRunnerID tags the runs created through this store with the process
+// that executes them, see FailInterrupted.
+func (s *RunStore) WithRunnerID(id string) *RunStore {
+	s.runnerID = id
+	return s
+}
+
+// CreateRun inserts a new run.
+func (s *RunStore) CreateRun(ctx context.Context, run runstore.Run) error {
+	if _, err := s.pool.Exec(ctx, `
+		INSERT INTO runs (id, agent_id, user_id, session_id, status, created_at, runner_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''))`,
+		run.ID, run.AgentID, run.UserID, run.SessionID, run.Status, run.CreatedAt, s.runnerID,
+	); err != nil {
+		return fmt.Errorf("postgres: insert run: %w", err)
+	}
+	return nil
+}
+
+// FailInterrupted marks the runs still running under this store's runner
+// ID as failed with errMsg. Called at startup, before the process starts
+// runs of its own, it finishes the runs a previous process with the same
+// ID left behind. Runs without a runner ID may belong to a live replica and
+// are never touched. It returns the number of runs updated.
+func (s *RunStore) FailInterrupted(ctx context.Context, errMsg string) (int64, error) {
+	if s.runnerID == "" {
+		return 0, errors.New("postgres: fail interrupted runs: no runner ID configured")
+	}
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE runs SET status = $1, error = $2, finished_at = NOW()
+		WHERE status = $3 AND runner_id = $4`,
+		runstore.StatusFailed, errMsg, runstore.StatusRunning, s.runnerID)
+	if err != nil {
+		return 0, fmt.Errorf("postgres: fail interrupted runs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetRun returns a run or runstore.ErrNotFound.
+func (s *RunStore) GetRun(ctx context.Context, id string) (runstore.Run, error) {
+	var (
+		run    runstore.Run
+		errMsg *string
+	)
+	err := s.pool.QueryRow(ctx, `
+		SELECT id, agent_id, user_id, session_id, status, error, event_count, created_at, finished_at
+		FROM runs WHERE id = $1`, id,
+	).Scan(&run.ID, &run.AgentID, &run.UserID, &run.SessionID, &run.Status, &errMsg,
+		&run.EventCount, &run.CreatedAt, &run.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return runstore.Run{}, runstore.ErrNotFound
+	}
+	if err != nil {
+		return runstore.Run{}, fmt.Errorf("postgres: get run: %w", err)
+	}
+	if errMsg != nil {
+		run.Error = *errMsg
+	}
+	return run, nil
+}
+
+// AppendEvent stores an event and bumps the run's event count.
+func (s *RunStore) AppendEvent(ctx context.Context, runID string, ev runstore.Event) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: begin append run event: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO run_events (run_id, seq, event) VALUES ($1, $2, $3)`,
+		runID, ev.Seq, []byte(ev.Data),
+	); err != nil {
+		return fmt.Errorf("postgres: insert run event: %w", err)
+	}
+	tag, err := tx.Exec(ctx, `UPDATE runs SET event_count = $2 WHERE id = $1`, runID, ev.Seq)
+	if err != nil {
+		return fmt.Errorf("postgres: update run event count: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return runstore.ErrNotFound
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("postgres: commit run event: %w", err)
+	}
+	return nil
+}
+
+// FinishRun sets the final status of a run.
+func (s *RunStore) FinishRun(ctx context.Context, id, status, errMsg string) error {
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE runs SET status = $2, error = NULLIF($3, ''), finished_at = NOW()
+		WHERE id = $1`, id, status, errMsg)
+	if err != nil {
+		return fmt.Errorf("postgres: finish run: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return runstore.ErrNotFound
+	}
+	return nil
+}
+
+// Events returns up to limit events of a run with seq > after.
+func (s *RunStore) Events(ctx context.Context, runID string, after int64, limit int) ([]runstore.Event, error) {
+	if limit <= 0 {
+		limit = defaultRunEventsLimit
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT seq, event FROM run_events
+		WHERE run_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3`, runID, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list run events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []runstore.Event
+	for rows.Next() {
+		var (
+			ev   runstore.Event
+			data []byte
+		)
+		if err := rows.Scan(&ev.Seq, &data); err != nil {
+			return nil, fmt.Errorf("postgres: scan run event: %w", err)
+		}
+		ev.Data = data
+		events = append(events, ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list run events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"helixrun/internal/runstore"
+)
+
+func TestRunStoreEvents(t *testing.T) {
+	s := NewRunStore(testPool(t, true))
+	ctx := context.Background()
+
+	if err := s.CreateRun(ctx, runstore.Run{ID: "r1", AgentID: "a", UserID: "u", SessionID: "s", Status: runstore.StatusRunning, CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("CreateRun: %v", err)
+	}
+	for i := int64(1); i <= 5; i++ {
+		if err := s.AppendEvent(ctx, "r1", runstore.Event{Seq: i, Data: []byte(fmt.Sprintf(`{"n":%d}`, i))}); err != nil {
+			t.Fatalf("AppendEvent %d: %v", i, err)
+		}
+	}
+
+	events, err := s.Events(ctx, "r1", 2, 2)
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if len(events) != 2 || events[0].Seq != 3 || events[1].Seq != 4 {
+		t.Fatalf("Events(after 2, limit 2) = %+v, want seq 3 and 4", events)
+	}
+	var data struct{ N int }
+	if err := json.Unmarshal(events[0].Data, &data); err != nil || data.N != 3 {
+		t.Errorf("event 3 data = %s (%v)", events[0].Data, err)
+	}
+	if events, _ := s.Events(ctx, "r1", 5, 0); len(events) != 0 {
+		t.Errorf("Events after the last = %+v, want none", events)
+	}
+
+	run, err := s.GetRun(ctx, "r1")
+	if err != nil {
+		t.Fatalf("GetRun: %v", err)
+	}
+	if run.EventCount != 5 || run.Finished() || run.AgentID != "a" || run.SessionID != "s" {
+		t.Errorf("run = %+v, want 5 events and running", run)
+	}
+
+	if err := s.FinishRun(ctx, "r1", runstore.StatusFailed, "boom"); err != nil {
+		t.Fatalf("FinishRun: %v", err)
+	}
+	run, _ = s.GetRun(ctx, "r1")
+	if run.Status != runstore.StatusFailed || run.Error != "boom" || run.FinishedAt == nil {
+		t.Errorf("finished run = %+v", run)
+	}
+}
+
+func TestRunStoreNotFound(t *testing.T) {
+	s := NewRunStore(testPool(t, true))
+	ctx := context.Background()
+
+	if _, err := s.GetRun(ctx, "nope"); !errors.Is(err, runstore.ErrNotFound) {
+		t.Errorf("GetRun error = %v, want ErrNotFound", err)
+	}
+	if err := s.FinishRun(ctx, "nope", runstore.StatusCompleted, ""); !errors.Is(err, runstore.ErrNotFound) {
+		t.Errorf("FinishRun error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRunStoreFailInterrupted(t *testing.T) {
+	pool := testPool(t, true)
+	ctx := context.Background()
+	mine := NewRunStore(pool).WithRunnerID("replica-1")
+	other := NewRunStore(pool).WithRunnerID("replica-2")
+	anonymous := NewRunStore(pool)
+
+	create := func(s *RunStore, id string) {
+		if err := s.CreateRun(ctx, runstore.Run{ID: id, Status: runstore.StatusRunning, CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("CreateRun %s: %v", id, err)
+		}
+	}
+	create(mine, "mine")
+	create(mine, "mine-done")
+	create(other, "other")
+	create(anonymous, "anonymous")
+	if err := mine.FinishRun(ctx, "mine-done", runstore.StatusCompleted, ""); err != nil {
+		t.Fatalf("FinishRun: %v", err)
+	}
+
+	if _, err := anonymous.FailInterrupted(ctx, "server restarted"); err == nil {
+		t.Error("FailInterrupted without a runner ID succeeded")
+	}
+	n, err := mine.FailInterrupted(ctx, "server restarted")
+	if err != nil {
+		t.Fatalf("FailInterrupted: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("FailInterrupted updated %d runs, want 1", n)
+	}
+
+	want := map[string]string{
+		"mine":      runstore.StatusFailed,
+		"mine-done": runstore.StatusCompleted,
+		"other":     runstore.StatusRunning,
+		"anonymous": runstore.StatusRunning,
+	}
+	for id, status := range want {
+		run, err := mine.GetRun(ctx, id)
+		if err != nil {
+			t.Fatalf("GetRun %s: %v", id, err)
+		}
+		if run.Status != status {
+			t.Errorf("run %s is %s, want %s", id, run.Status, status)
+		}
+	}
+	if run, _ := mine.GetRun(ctx, "mine"); run.Error != "server restarted" {
+		t.Errorf("interrupted run error = %q", run.Error)
+	}
+}
@@ -0,0 +1,597 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/session"
+)
+
+var _ session.Service = (*SessionService)(nil)
+
+// SessionService is a PostgreSQL implementation of session.Service.
+// Sessions, their events and app/user scoped state are stored in the tables
+// created by configs/migrations/0002_sessions.sql.
+type SessionService struct {
+	pool *pgxpool.Pool
+}
+
+// NewSessionService creates a SessionService on top of an existing pool.
+// The pool is owned by the caller; Close does not close it.
+func NewSessionService(pool *pgxpool.Pool) *SessionService {
+	return &SessionService{pool: pool}
+}
+
+// CreateSession creates (or resets) a session with the given initial state.
+func (s *SessionService) CreateSession(
+	ctx context.Context,
+	key session.Key,
+	state session.StateMap,
+	_ ...session.Option,
+) (*session.Session, error) {
+	if err := key.CheckUserKey(); err != nil {
+		return nil, err
+	}
+	if key.SessionID == "" {
+		key.SessionID = uuid.New().String()
+	}
+
+	sess := session.NewSession(key.AppName, key.UserID, key.SessionID)
+	for k, v := range state {
+		sess.State[k] = v
+	}
+
+	stateJSON, err := marshalState(sess.State)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: begin create session: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	if _, err := tx.Exec(ctx, `
+		DELETE FROM session_events
+		WHERE app_name = $1 AND user_id = $2 AND session_id = $3`,
+		key.AppName, key.UserID, key.SessionID,
+	); err != nil {
+		return nil, fmt.Errorf("postgres: reset session events: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO sessions (app_name, user_id, session_id, state, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		ON CONFLICT (app_name, user_id, session_id)
+		DO UPDATE SET state = EXCLUDED.state, created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at`,
+		key.AppName, key.UserID, key.SessionID, stateJSON, sess.CreatedAt,
+	); err != nil {
+		return nil, fmt.Errorf("postgres: insert session: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("postgres: commit create session: %w", err)
+	}
+
+	return s.mergeScopedState(ctx, sess)
+}
+
+// GetSession loads a session with its events. It returns nil, nil when the
+// session does not exist.
+func (s *SessionService) GetSession(
+	ctx context.Context,
+	key session.Key,
+	opts ...session.Option,
+) (*session.Session, error) {
+	if err := key.CheckSessionKey(); err != nil {
+		return nil, err
+	}
+
+	row := s.pool.QueryRow(ctx, `
+		SELECT state, created_at, updated_at
+		FROM sessions
+		WHERE app_name = $1 AND user_id = $2 AND session_id = $3`,
+		key.AppName, key.UserID, key.SessionID,
+	)
+	sess, err := scanSession(row, key.AppName, key.UserID, key.SessionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.loadEvents(ctx, key.AppName, key.UserID, key.SessionID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	sess.Events = events[sess.ID]
+	sess.ApplyEventFiltering(opts...)
+
+	return s.mergeScopedState(ctx, sess)
+}
+
+// ListSessions returns all sessions of a user.
+func (s *SessionService) ListSessions(
+	ctx context.Context,
+	userKey session.UserKey,
+	opts ...session.Option,
+) ([]*session.Session, error) {
+	if err := userKey.CheckUserKey(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT session_id, state, created_at, updated_at
+		FROM sessions
+		WHERE app_name = $1 AND user_id = $2
+		ORDER BY updated_at DESC`,
+		userKey.AppName, userKey.UserID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: list sessions: %w", err)
+	}
+
+	var sessions []*session.Session
+	for rows.Next() {
+		var (
+			sessionID string
+			stateJSON []byte
+			createdAt time.Time
+			updatedAt time.Time
+		)
+		if err := rows.Scan(&sessionID, &stateJSON, &createdAt, &updatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("postgres: scan session: %w", err)
+		}
+		sess, err := newStoredSession(userKey.AppName, userKey.UserID, sessionID, stateJSON, createdAt, updatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: list sessions: %w", err)
+	}
+
+	if len(sessions) == 0 {
+		return sessions, nil
+	}
+
+	events, err := s.loadEvents(ctx, userKey.AppName, userKey.UserID, "", opts...)
+	if err != nil {
+		return nil, err
+	}
+	scoped, err := s.scopedState(ctx, userKey.AppName, userKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sess := range sessions {
+		sess.Events = events[sess.ID]
+		sess.ApplyEventFiltering(opts...)
+		for k, v := range scoped {
+			sess.State[k] = v
+		}
+	}
+	return sessions, nil
+}
+
+// DeleteSession removes a session and its events.
+func (s *SessionService) DeleteSession(
+	ctx context.Context,
+	key session.Key,
+	_ ...session.Option,
+) error {
+	if err := key.CheckSessionKey(); err != nil {
+		return err
+	}
+	if _, err := s.pool.Exec(ctx, `
+		DELETE FROM sessions
+		WHERE app_name = $1 AND user_id = $2 AND session_id = $3`,
+		key.AppName, key.UserID, key.SessionID,
+	); err != nil {
+		return fmt.Errorf("postgres: delete session: %w", err)
+	}
+	return nil
+}
+
+// UpdateAppState upserts app-scoped state keys.
+func (s *SessionService) UpdateAppState(ctx context.Context, appName string, state session.StateMap) error {
+	if appName == "" {
+		return session.ErrAppNameRequired
+	}
+
+	batch := &pgx.Batch{}
+	for k, v := range state {
+		k = strings.TrimPrefix(k, session.StateAppPrefix)
+		batch.Queue(`
+			INSERT INTO session_app_states (app_name, key, value, updated_at)
+			VALUES ($1, $2, $3, NOW())
+			ON CONFLICT (app_name, key)
+			DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+			appName, k, v,
+		)
+	}
+	if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("postgres: update app state: %w", err)
+	}
+	return nil
+}
+
+// DeleteAppState deletes a single app-scoped state key.
+func (s *SessionService) DeleteAppState(ctx context.Context, appName string, key string) error {
+	if appName == "" {
+		return session.ErrAppNameRequired
+	}
+	key = strings.TrimPrefix(key, session.StateAppPrefix)
+	if _, err := s.pool.Exec(ctx, `
+		DELETE FROM session_app_states WHERE app_name = $1 AND key = $2`,
+		appName, key,
+	); err != nil {
+		return fmt.Errorf("postgres: delete app state: %w", err)
+	}
+	return nil
+}
+
+// ListAppStates returns all app-scoped state keys (without prefix).
+func (s *SessionService) ListAppStates(ctx context.Context, appName string) (session.StateMap, error) {
+	if appName == "" {
+		return nil, session.ErrAppNameRequired
+	}
+	return s.queryState(ctx, `
+		SELECT key, value FROM session_app_states WHERE app_name = $1`,
+		appName,
+	)
+}
+
+// UpdateUserState upserts user-scoped state keys.
+func (s *SessionService) UpdateUserState(ctx context.Context, userKey session.UserKey, state session.StateMap) error {
+	if err := userKey.CheckUserKey(); err != nil {
+		return err
+	}
+	for k := range state {
+		if strings.HasPrefix(k, session.StateAppPrefix) || strings.HasPrefix(k, session.StateTempPrefix) {
+			return fmt.Errorf("postgres: update user state: %s is not allowed", k)
+		}
+	}
+
+	batch := &pgx.Batch{}
+	for k, v := range state {
+		k = strings.TrimPrefix(k, session.StateUserPrefix)
+		batch.Queue(`
+			INSERT INTO session_user_states (app_name, user_id, key, value, updated_at)
+			VALUES ($1, $2, $3, $4, NOW())
+			ON CONFLICT (app_name, user_id, key)
+			DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+			userKey.AppName, userKey.UserID, k, v,
+		)
+	}
+	if err := s.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("postgres: update user state: %w", err)
+	}
+	return nil
+}
+
+// ListUserStates returns all user-scoped state keys (without prefix).
+func (s *SessionService) ListUserStates(ctx context.Context, userKey session.UserKey) (session.StateMap, error) {
+	if err := userKey.CheckUserKey(); err != nil {
+		return nil, err
+	}
+	return s.queryState(ctx, `
+		SELECT key, value FROM session_user_states WHERE app_name = $1 AND user_id = $2`,
+		userKey.AppName, userKey.UserID,
+	)
+}
+
+// DeleteUserState deletes a single user-scoped state key.
+func (s *SessionService) DeleteUserState(ctx context.Context, userKey session.UserKey, key string) error {
+	if err := userKey.CheckUserKey(); err != nil {
+		return err
+	}
+	key = strings.TrimPrefix(key, session.StateUserPrefix)
+	if _, err := s.pool.Exec(ctx, `
+		DELETE FROM session_user_states WHERE app_name = $1 AND user_id = $2 AND key = $3`,
+		userKey.AppName, userKey.UserID, key,
+	); err != nil {
+		return fmt.Errorf("postgres: delete user state: %w", err)
+	}
+	return nil
+}
+
+// UpdateSessionState merges state into the session without appending an event.
+// Keys with app: or user: prefixes are rejected.
+func (s *SessionService) UpdateSessionState(ctx context.Context, key session.Key, state session.StateMap) error {
+	if err := key.CheckSessionKey(); err != nil {
+		return err
+	}
+	for k := range state {
+		if strings.HasPrefix(k, session.StateAppPrefix) {
+			return fmt.Errorf("postgres: update session state: %s is not allowed, use UpdateAppState instead", k)
+		}
+		if strings.HasPrefix(k, session.StateUserPrefix) {
+			return fmt.Errorf("postgres: update session state: %s is not allowed, use UpdateUserState instead", k)
+		}
+	}
+
+	delta, err := marshalState(state)
+	if err != nil {
+		return err
+	}
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE sessions SET state = state || $4::jsonb, updated_at = NOW()
+		WHERE app_name = $1 AND user_id = $2 AND session_id = $3`,
+		key.AppName, key.UserID, key.SessionID, delta,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: update session state: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("postgres: update session state: session not found")
+	}
+	return nil
+}
+
+// AppendEvent persists an event and merges its state delta into the session.
+func (s *SessionService) AppendEvent(
+	ctx context.Context,
+	sess *session.Session,
+	evt *event.Event,
+	opts ...session.Option,
+) error {
+	sess.UpdateUserSession(evt, opts...)
+	key := session.Key{
+		AppName:   sess.AppName,
+		UserID:    sess.UserID,
+		SessionID: sess.ID,
+	}
+	if err := key.CheckSessionKey(); err != nil {
+		return err
+	}
+
+	delta, err := marshalState(evt.StateDelta)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres: begin append event: %w", err)
+	}
+	defer tx.Rollback(ctx) //nolint:errcheck
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE sessions SET state = state || $4::jsonb, updated_at = NOW()
+		WHERE app_name = $1 AND user_id = $2 AND session_id = $3`,
+		key.AppName, key.UserID, key.SessionID, delta,
+	)
+	if err != nil {
+		return fmt.Errorf("postgres: update session: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("postgres: session not found: %s", key.SessionID)
+	}
+
+	// Only complete, content-bearing events become part of the history,
+	// mirroring the in-memory implementation.
+	if evt.Response != nil && !evt.IsPartial && evt.IsValidContent() {
+		data, err := json.Marshal(evt)
+		if err != nil {
+			return fmt.Errorf("postgres: marshal event: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO session_events (app_name, user_id, session_id, event, created_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			key.AppName, key.UserID, key.SessionID, data, evt.Timestamp,
+		); err != nil {
+			return fmt.Errorf("postgres: insert event: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("postgres: commit append event: %w", err)
+	}
+	return nil
+}
+
+// CreateSessionSummary is a no-op: no summarizer is configured for the
+// PostgreSQL backend.
+func (s *SessionService) CreateSessionSummary(_ context.Context, _ *session.Session, _ string, _ bool) error {
+	return nil
+}
+
+// EnqueueSummaryJob is a no-op, see CreateSessionSummary.
+func (s *SessionService) EnqueueSummaryJob(ctx context.Context, sess *session.Session, filterKey string, force bool) error {
+	return s.CreateSessionSummary(ctx, sess, filterKey, force)
+}
+
+// GetSessionSummaryText returns the full-session summary held on sess, if any.
+func (s *SessionService) GetSessionSummaryText(_ context.Context, sess *session.Session) (string, bool) {
+	if sess == nil {
+		return "", false
+	}
+	sess.SummariesMu.RLock()
+	defer sess.SummariesMu.RUnlock()
+	sum, ok := sess.Summaries[session.SummaryFilterKeyAllContents]
+	if !ok || sum == nil || sum.Summary == "" {
+		return "", false
+	}
+	return sum.Summary, true
+}
+
+// Close is a no-op; the pool is owned by the caller.
+func (s *SessionService) Close() error {
+	return nil
+}
+
+// loadEvents reads the events selected by opts for one session, or for all
+// sessions of the user when sessionID is empty, keyed by session ID.
+//
+// Only the last WithEventNum events at or after WithEventTime are read.
+// ApplyEventFiltering also keeps the latest user message when that window has
+// none, so the latest user message before the window is read along with it.
+func (s *SessionService) loadEvents(
+	ctx context.Context,
+	appName, userID, sessionID string,
+	opts ...session.Option,
+) (map[string][]event.Event, error) {
+	var o session.Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var since *time.Time
+	if !o.EventTime.IsZero() {
+		since = &o.EventTime
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		WITH e AS (
+			SELECT session_id, id, event,
+				($4::int <= 0 OR ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY id DESC) <= $4::int)
+				AND ($5::timestamptz IS NULL OR created_at >= $5::timestamptz) AS in_window,
+				event->'choices' @> '[{"message":{"role":"user"}}]' AS is_user
+			FROM session_events
+			WHERE app_name = $1 AND user_id = $2 AND ($3::text = '' OR session_id = $3::text)
+		)
+		SELECT session_id, event FROM (
+			SELECT session_id, id, event, in_window,
+				MAX(id) FILTER (WHERE is_user AND NOT in_window) OVER (PARTITION BY session_id) AS last_user
+			FROM e
+		) w
+		WHERE in_window OR id = last_user
+		ORDER BY session_id, id`,
+		appName, userID, sessionID, o.EventNum, since,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: query session events: %w", err)
+	}
+	defer rows.Close()
+
+	events := make(map[string][]event.Event)
+	for rows.Next() {
+		var (
+			id   string
+			data []byte
+		)
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, fmt.Errorf("postgres: scan session event: %w", err)
+		}
+		var evt event.Event
+		if err := json.Unmarshal(data, &evt); err != nil {
+			return nil, fmt.Errorf("postgres: unmarshal session event: %w", err)
+		}
+		events[id] = append(events[id], evt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: query session events: %w", err)
+	}
+	return events, nil
+}
+
+func (s *SessionService) queryState(ctx context.Context, query string, args ...any) (session.StateMap, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: query state: %w", err)
+	}
+	defer rows.Close()
+
+	state := make(session.StateMap)
+	for rows.Next() {
+		var (
+			k string
+			v []byte
+		)
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, fmt.Errorf("postgres: scan state: %w", err)
+		}
+		state[k] = v
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("postgres: query state: %w", err)
+	}
+	return state, nil
+}
+
+// mergeScopedState adds app: and user: prefixed state to the session state.
+func (s *SessionService) mergeScopedState(ctx context.Context, sess *session.Session) (*session.Session, error) {
+	scoped, err := s.scopedState(ctx, sess.AppName, sess.UserID)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range scoped {
+		sess.State[k] = v
+	}
+	return sess, nil
+}
+
+// scopedState returns the app and user state of a user with their prefixes.
+func (s *SessionService) scopedState(ctx context.Context, appName, userID string) (session.StateMap, error) {
+	appState, err := s.ListAppStates(ctx, appName)
+	if err != nil {
+		return nil, err
+	}
+	userState, err := s.ListUserStates(ctx, session.UserKey{AppName: appName, UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	scoped := make(session.StateMap, len(appState)+len(userState))
+	for k, v := range appState {
+		scoped[session.StateAppPrefix+k] = v
+	}
+	for k, v := range userState {
+		scoped[session.StateUserPrefix+k] = v
+	}
+	return scoped, nil
+}
+
+// marshalState encodes state as a JSON object; nil maps become "{}" so the
+// result can always be merged with the jsonb || operator.
+func marshalState(state session.StateMap) ([]byte, error) {
+	if len(state) == 0 {
+		return []byte("{}"), nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: marshal session state: %w", err)
+	}
+	return data, nil
+}
+
+func scanSession(row pgx.Row, appName, userID, sessionID string) (*session.Session, error) {
+	var (
+		stateJSON []byte
+		createdAt time.Time
+		updatedAt time.Time
+	)
+	if err := row.Scan(&stateJSON, &createdAt, &updatedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("postgres: scan session: %w", err)
+	}
+	return newStoredSession(appName, userID, sessionID, stateJSON, createdAt, updatedAt)
+}
+
+func newStoredSession(appName, userID, sessionID string, stateJSON []byte, createdAt, updatedAt time.Time) (*session.Session, error) {
+	state := make(session.StateMap)
+	if len(stateJSON) > 0 {
+		if err := json.Unmarshal(stateJSON, &state); err != nil {
+			return nil, fmt.Errorf("postgres: unmarshal session state: %w", err)
+		}
+	}
+	return session.NewSession(
+		appName, userID, sessionID,
+		session.WithSessionState(state),
+		session.WithSessionCreatedAt(createdAt),
+		session.WithSessionUpdatedAt(updatedAt),
+	), nil
+}
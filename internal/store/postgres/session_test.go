@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+	"trpc.group/trpc-go/trpc-agent-go/session"
+)
+
+var sessionEpoch = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+// appendMessages appends one event per message, a minute apart. Messages
+// starting with "u" are user messages, the others assistant messages.
+func appendMessages(t *testing.T, svc *SessionService, sess *session.Session, msgs ...string) {
+	t.Helper()
+	for i, msg := range msgs {
+		m := model.NewAssistantMessage(msg)
+		if msg[0] == 'u' {
+			m = model.NewUserMessage(msg)
+		}
+		evt := event.NewResponseEvent("inv", "test", &model.Response{
+			Choices: []model.Choice{{Message: m}},
+			Done:    true,
+		})
+		evt.Timestamp = sessionEpoch.Add(time.Duration(i) * time.Minute)
+		if err := svc.AppendEvent(context.Background(), sess, evt); err != nil {
+			t.Fatalf("AppendEvent %s: %v", msg, err)
+		}
+	}
+}
+
+func contents(sess *session.Session) string {
+	var out []string
+	for _, e := range sess.Events {
+		out = append(out, e.Choices[0].Message.Content)
+	}
+	return fmt.Sprint(out)
+}
+
+func TestSessionServiceEventFiltering(t *testing.T) {
+	svc := NewSessionService(testPool(t, true))
+	ctx := context.Background()
+
+	// Events at 12:00 .. 12:04.
+	history := []string{"u1", "a1", "u2", "a2", "a3"}
+	for _, id := range []string{"s1", "s2"} {
+		sess, err := svc.CreateSession(ctx, session.Key{AppName: "app", UserID: "alice", SessionID: id}, nil)
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		appendMessages(t, svc, sess, history...)
+	}
+
+	tests := []struct {
+		name string
+		opts []session.Option
+		want string
+	}{
+		{"all", nil, "[u1 a1 u2 a2 a3]"},
+		{"last three", []session.Option{session.WithEventNum(3)}, "[u2 a2 a3]"},
+		{"last two keep the user message", []session.Option{session.WithEventNum(2)}, "[u2 a2 a3]"},
+		{"since", []session.Option{session.WithEventTime(sessionEpoch.Add(time.Minute))}, "[u2 a2 a3]"},
+		{"since and last one", []session.Option{
+			session.WithEventTime(sessionEpoch.Add(time.Minute)), session.WithEventNum(1),
+		}, "[u2 a3]"},
+		{"after the last event", []session.Option{session.WithEventTime(sessionEpoch.Add(time.Hour))}, "[u2]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess, err := svc.GetSession(ctx, session.Key{AppName: "app", UserID: "alice", SessionID: "s1"}, tt.opts...)
+			if err != nil {
+				t.Fatalf("GetSession: %v", err)
+			}
+			if got := contents(sess); got != tt.want {
+				t.Errorf("GetSession events = %s, want %s", got, tt.want)
+			}
+
+			list, err := svc.ListSessions(ctx, session.UserKey{AppName: "app", UserID: "alice"}, tt.opts...)
+			if err != nil {
+				t.Fatalf("ListSessions: %v", err)
+			}
+			if len(list) != 2 {
+				t.Fatalf("ListSessions returned %d sessions, want 2", len(list))
+			}
+			for _, sess := range list {
+				if got := contents(sess); got != tt.want {
+					t.Errorf("ListSessions events of %s = %s, want %s", sess.ID, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionServiceState(t *testing.T) {
+	svc := NewSessionService(testPool(t, true))
+	ctx := context.Background()
+	key := session.Key{AppName: "app", UserID: "alice", SessionID: "s1"}
+	userKey := session.UserKey{AppName: "app", UserID: "alice"}
+
+	if _, err := svc.CreateSession(ctx, key, session.StateMap{"topic": []byte("go")}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := svc.UpdateAppState(ctx, "app", session.StateMap{"app:mode": []byte("beta")}); err != nil {
+		t.Fatalf("UpdateAppState: %v", err)
+	}
+	if err := svc.UpdateUserState(ctx, userKey, session.StateMap{"user:lang": []byte("en")}); err != nil {
+		t.Fatalf("UpdateUserState: %v", err)
+	}
+	if err := svc.UpdateSessionState(ctx, key, session.StateMap{"step": []byte("2")}); err != nil {
+		t.Fatalf("UpdateSessionState: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		state session.StateMap
+		err   bool
+	}{
+		{"app prefix", session.StateMap{"app:x": nil}, true},
+		{"user prefix", session.StateMap{"user:x": nil}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := svc.UpdateSessionState(ctx, key, tt.state); (err != nil) != tt.err {
+				t.Errorf("UpdateSessionState(%v) error = %v, want error %v", tt.state, err, tt.err)
+			}
+		})
+	}
+
+	want := map[string]string{"topic": "go", "step": "2", "app:mode": "beta", "user:lang": "en"}
+	sess, err := svc.GetSession(ctx, key)
+	if err != nil || sess == nil {
+		t.Fatalf("GetSession = %v, %v", sess, err)
+	}
+	list, err := svc.ListSessions(ctx, userKey)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("ListSessions = %v, %v", list, err)
+	}
+	for _, s := range []*session.Session{sess, list[0]} {
+		for k, v := range want {
+			if got := string(s.State[k]); got != v {
+				t.Errorf("state[%s] = %q, want %q", k, got, v)
+			}
+		}
+	}
+
+	if err := svc.DeleteSession(ctx, key); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if sess, err := svc.GetSession(ctx, key); err != nil || sess != nil {
+		t.Errorf("GetSession after delete = %v, %v, want nil, nil", sess, err)
+	}
+}
@@ -0,0 +1,185 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	pgstore "helixrun/internal/store/postgres"
+)
+
+// Budget scopes and periods.
+const (
+	BudgetScopeUser  = "user"
+	BudgetScopeAgent = "agent"
+
+	BudgetPeriodDay   = "day"   // UTC calendar day
+	BudgetPeriodMonth = "month" // UTC calendar month
+
+	// BudgetAnyID applies a budget to every user or agent that has no
+	// budget of its own for the same period.
+	BudgetAnyID = "*"
+)
+
+// Budget caps the spend of one user or agent per period. At least one of
+// MaxCostUSD and MaxTokens is set.
+type Budget struct {
+	Scope      string   `json:"scope"`
+	ID         string   `json:"id"`
+	Period     string   `json:"period"`
+	MaxCostUSD *float64 `json:"max_cost_usd,omitempty"`
+	MaxTokens  *int64   `json:"max_tokens,omitempty"`
+}
+
+func (b Budget) validate() error {
+	switch b.Scope {
+	case BudgetScopeUser, BudgetScopeAgent:
+	default:
+		return fmt.Errorf("unsupported scope %q (want %s or %s)", b.Scope, BudgetScopeUser, BudgetScopeAgent)
+	}
+	if b.ID == "" {
+		return fmt.Errorf("id is required (use %q for all)", BudgetAnyID)
+	}
+	switch b.Period {
+	case BudgetPeriodDay, BudgetPeriodMonth:
+	default:
+		return fmt.Errorf("unsupported period %q (want %s or %s)", b.Period, BudgetPeriodDay, BudgetPeriodMonth)
+	}
+	if b.MaxCostUSD == nil && b.MaxTokens == nil {
+		return fmt.Errorf("max_cost_usd or max_tokens is required")
+	}
+	if (b.MaxCostUSD != nil && *b.MaxCostUSD < 0) || (b.MaxTokens != nil && *b.MaxTokens < 0) {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+// periodStart returns the start of the budget period containing now.
+func (b Budget) periodStart(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	if b.Period == BudgetPeriodMonth {
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// LoadBudgets reads a JSON array of budgets. A missing file yields no
+// budgets.
+func LoadBudgets(path string) ([]Budget, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read budgets: %w", err)
+	}
+	var budgets []Budget
+	if err := json.Unmarshal(b, &budgets); err != nil {
+		return nil, fmt.Errorf("parse budgets %s: %w", path, err)
+	}
+	var errs []error
+	for i, bud := range budgets {
+		if err := bud.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("budgets[%d]: %w", i, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid budgets in %s: %w", path, errors.Join(errs...))
+	}
+	return budgets, nil
+}
+
+// BudgetExceededError is returned when a run would exceed a budget.
+type BudgetExceededError struct {
+	Budget  Budget
+	Subject string // the user or agent ID the budget was applied to
+	CostUSD float64
+	Tokens  int64
+}
+
+func (e *BudgetExceededError) Error() string {
+	switch {
+	case e.Budget.MaxCostUSD != nil && e.CostUSD >= *e.Budget.MaxCostUSD:
+		return fmt.Sprintf("%s %s exceeded its %s budget: $%.4f of $%.4f spent",
+			e.Budget.Scope, e.Subject, e.Budget.Period, e.CostUSD, *e.Budget.MaxCostUSD)
+	default:
+		return fmt.Sprintf("%s %s exceeded its %s budget: %d of %d tokens used",
+			e.Budget.Scope, e.Subject, e.Budget.Period, e.Tokens, *e.Budget.MaxTokens)
+	}
+}
+
+// BudgetChecker checks budgets against recorded usage.
+type BudgetChecker struct {
+	repo    *pgstore.UsageRepository
+	budgets []Budget
+	now     func() time.Time
+}
+
+// NewBudgetChecker creates a BudgetChecker.
+func NewBudgetChecker(repo *pgstore.UsageRepository, budgets []Budget) *BudgetChecker {
+	return &BudgetChecker{repo: repo, budgets: budgets, now: time.Now}
+}
+
+// Check returns a *BudgetExceededError when the user or the agent has used
+// up one of its budgets for the current period.
+func (c *BudgetChecker) Check(ctx context.Context, agentID, userID string) error {
+	if c == nil {
+		return nil
+	}
+	now := c.now()
+	for _, b := range c.applicable(agentID, userID) {
+		f := pgstore.UsageSummaryFilter{From: b.periodStart(now)}
+		subject := userID
+		if b.Scope == BudgetScopeAgent {
+			f.AgentID, subject = agentID, agentID
+		} else {
+			f.UserID = userID
+		}
+
+		rows, err := c.repo.Summary(ctx, f)
+		if err != nil {
+			return fmt.Errorf("check budget: %w", err)
+		}
+		var spent pgstore.UsageSummary
+		if len(rows) > 0 {
+			spent = rows[0]
+		}
+		if (b.MaxCostUSD != nil && spent.CostUSD >= *b.MaxCostUSD) ||
+			(b.MaxTokens != nil && spent.TotalTokens >= *b.MaxTokens) {
+			return &BudgetExceededError{Budget: b, Subject: subject, CostUSD: spent.CostUSD, Tokens: spent.TotalTokens}
+		}
+	}
+	return nil
+}
+
+// applicable returns the budgets for the agent and user. A budget for a
+// specific ID replaces the "*" budget of the same scope and period.
+func (c *BudgetChecker) applicable(agentID, userID string) []Budget {
+	type slot struct{ scope, period string }
+	specific := make(map[slot]bool)
+	subjectOf := func(b Budget) string {
+		if b.Scope == BudgetScopeAgent {
+			return agentID
+		}
+		return userID
+	}
+	for _, b := range c.budgets {
+		if b.ID == subjectOf(b) {
+			specific[slot{b.Scope, b.Period}] = true
+		}
+	}
+
+	var out []Budget
+	for _, b := range c.budgets {
+		switch {
+		case b.ID == subjectOf(b):
+			out = append(out, b)
+		case b.ID == BudgetAnyID && !specific[slot{b.Scope, b.Period}]:
+			out = append(out, b)
+		}
+	}
+	return out
+}
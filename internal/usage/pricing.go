@@ -0,0 +1,82 @@
+// Package usage records the token usage of model responses into
+// cliproxy_usage_events and prices it.
+package usage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// Price is the cost of a model in USD per million tokens. Cached input
+// tokens are billed at CachedInputPerMTok when set, otherwise at
+// InputPerMTok.
+type Price struct {
+	InputPerMTok       float64 `json:"input_per_mtok"`
+	OutputPerMTok      float64 `json:"output_per_mtok"`
+	CachedInputPerMTok float64 `json:"cached_input_per_mtok,omitempty"`
+}
+
+// PriceTable maps model names to prices. A key also matches model names it
+// is a prefix of, so "gpt-4o-mini" prices "gpt-4o-mini-2024-07-18"; the
+// longest matching key wins.
+type PriceTable map[string]Price
+
+// LoadPriceTable reads a JSON price table. A missing file yields an empty
+// table, which prices everything at 0.
+func LoadPriceTable(path string) (PriceTable, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return PriceTable{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read price table: %w", err)
+	}
+	var table PriceTable
+	if err := json.Unmarshal(b, &table); err != nil {
+		return nil, fmt.Errorf("parse price table %s: %w", path, err)
+	}
+	for name, p := range table {
+		if p.InputPerMTok < 0 || p.OutputPerMTok < 0 || p.CachedInputPerMTok < 0 {
+			return nil, fmt.Errorf("price table %s: negative price for %q", path, name)
+		}
+	}
+	return table, nil
+}
+
+// Lookup finds the price of a model.
+func (t PriceTable) Lookup(modelName string) (Price, bool) {
+	if p, ok := t[modelName]; ok {
+		return p, true
+	}
+	var (
+		best    Price
+		bestLen int
+	)
+	for name, p := range t {
+		if len(name) > bestLen && strings.HasPrefix(modelName, name) {
+			best, bestLen = p, len(name)
+		}
+	}
+	return best, bestLen > 0
+}
+
+// Cost prices the usage of one response. Unknown models cost 0.
+func (t PriceTable) Cost(modelName string, u model.Usage) float64 {
+	p, ok := t.Lookup(modelName)
+	if !ok {
+		return 0
+	}
+	cached := u.PromptTokensDetails.CachedTokens
+	cachedRate := p.CachedInputPerMTok
+	if cachedRate == 0 {
+		cachedRate = p.InputPerMTok
+	}
+	return (float64(u.PromptTokens-cached)*p.InputPerMTok +
+		float64(cached)*cachedRate +
+		float64(u.CompletionTokens)*p.OutputPerMTok) / 1e6
+}
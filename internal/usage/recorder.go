@@ -0,0 +1,150 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	hmodel "helixrun/internal/model"
+	pgstore "helixrun/internal/store/postgres"
+
+	"trpc.group/trpc-go/trpc-agent-go/event"
+	"trpc.group/trpc-go/trpc-agent-go/model"
+)
+
+// usageSource is stored in cliproxy_usage_events.source for rows written
+// by HelixRun itself.
+const usageSource = "helixrun"
+
+const (
+	// recordTimeout bounds a single insert; recording outlives the request.
+	recordTimeout = 5 * time.Second
+	// recordQueueSize bounds the rows waiting to be inserted. Rows are
+	// dropped, and logged, while the queue is full.
+	recordQueueSize = 1024
+	// recordWorkers is the number of concurrent inserts.
+	recordWorkers = 4
+)
+
+// Tags identify the run a response belongs to.
+type Tags struct {
+	AgentID   string
+	UserID    string
+	SessionID string
+}
+
+// Recorder writes one usage row per final model response. Rows are
+// inserted by a fixed set of workers; Close drains the queue.
+type Recorder struct {
+	repo   *pgstore.UsageRepository
+	prices PriceTable
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan pgstore.UsageEvent
+	workers sync.WaitGroup
+}
+
+// NewRecorder creates a Recorder and starts its workers.
+func NewRecorder(repo *pgstore.UsageRepository, prices PriceTable) *Recorder {
+	r := &Recorder{
+		repo:   repo,
+		prices: prices,
+		queue:  make(chan pgstore.UsageEvent, recordQueueSize),
+	}
+	r.workers.Add(recordWorkers)
+	for i := 0; i < recordWorkers; i++ {
+		go r.work()
+	}
+	return r
+}
+
+// Close stops accepting rows and waits until the queued rows are inserted
+// or ctx is done.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("usage recorder: %d rows not written: %w", len(r.queue), ctx.Err())
+	}
+}
+
+func (r *Recorder) work() {
+	defer r.workers.Done()
+	for row := range r.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+		if err := r.repo.Insert(ctx, row); err != nil {
+			log.Printf("record usage of event %s failed: %v", row.EventID, err)
+		}
+		cancel()
+	}
+}
+
+// Record stores the usage of ev if it is a final model response carrying
+// usage. attrs supplies the provider and key of the response. Recording
+// runs in the background; failures and dropped rows are logged.
+func (r *Recorder) Record(ctx context.Context, tags Tags, attrs *hmodel.AttributionRecorder, ev *event.Event) {
+	if r == nil || !IsModelUsage(ev) {
+		return
+	}
+	var attr hmodel.Attribution
+	if attrs != nil {
+		attr, _ = attrs.Take(ev.Response.ID)
+	}
+
+	u := *ev.Usage
+	row := pgstore.UsageEvent{
+		EventID:      ev.ID,
+		APIKeyID:     attr.KeyID,
+		Provider:     attr.Provider,
+		Model:        ev.Model,
+		Source:       usageSource,
+		Failed:       ev.Error != nil,
+		TotalTokens:  u.TotalTokens,
+		InputTokens:  u.PromptTokens,
+		OutputTokens: u.CompletionTokens,
+		CachedTokens: u.PromptTokensDetails.CachedTokens,
+		CostUSD:      r.prices.Cost(ev.Model, u),
+		Metadata: map[string]any{
+			"agent_id":      tags.AgentID,
+			"user_id":       tags.UserID,
+			"session_id":    tags.SessionID,
+			"invocation_id": ev.InvocationID,
+			"author":        ev.Author,
+		},
+		CreatedAt: ev.Timestamp,
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		log.Printf("record usage of event %s skipped: recorder is closed", row.EventID)
+		return
+	}
+	select {
+	case r.queue <- row:
+	default:
+		log.Printf("record usage of event %s dropped: queue is full", row.EventID)
+	}
+}
+
+// IsModelUsage reports whether ev is a final model response with usage.
+func IsModelUsage(ev *event.Event) bool {
+	return ev != nil && ev.Response != nil && !ev.IsPartial &&
+		ev.Object == model.ObjectTypeChatCompletion && ev.Usage != nil
+}